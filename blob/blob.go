@@ -0,0 +1,28 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+const (
+	File = "file"
+	S3   = "s3"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps the raw event payloads outside of the database. The blobs are
+// content addressed, the same content is stored once under the same key
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// Key returns the content address of the data
+func Key(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// fileStore shards the blobs in two levels of directories by the key prefix,
+// ex.: blobs/3f/a2/3fa2...
+type fileStore struct {
+	dir string
+}
+
+func NewFile(dir string) (Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (f *fileStore) path(key string) string {
+	if len(key) < 4 {
+		return filepath.Join(f.dir, key)
+	}
+	return filepath.Join(f.dir, key[0:2], key[2:4], key)
+}
+
+func (f *fileStore) Put(key string, data []byte) error {
+	p := f.path(key)
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	// write aside and rename, a reader never sees a partial blob
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (f *fileStore) Get(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (f *fileStore) Delete(key string) error {
+	err := os.Remove(f.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
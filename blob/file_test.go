@@ -0,0 +1,31 @@
+package blob
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFile(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	data := []byte("payload")
+	key := Key(data)
+	if err := store.Put(key, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// sharded by the key prefix, without the temporary files
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs", key[0:2], key[2:4]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != key {
+		t.Fatalf("files of the shard: %v", entries)
+	}
+}
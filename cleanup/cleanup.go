@@ -0,0 +1,173 @@
+package cleanup
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/storage"
+)
+
+// Report is the removed data of a project, of the orphans without project
+type Report struct {
+	Project string
+	storage.Removed
+}
+
+// Cleaner applies the retention settings of the projects
+type Cleaner struct {
+	repo     storage.Storage
+	settings *config.Config
+	interval time.Duration
+}
+
+func NewCleaner(repo storage.Storage, settings *config.Config, interval time.Duration) *Cleaner {
+	return &Cleaner{
+		repo:     repo,
+		settings: settings,
+		interval: interval,
+	}
+}
+
+// Start runs the cleanup periodically until the context is done, a zero
+// interval disables it
+func (c *Cleaner) Start(ctx context.Context) {
+	if c.interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := c.Run(false); err != nil {
+					log.Printf("Cleanup failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Run deletes the events over the limits of every project, or only counts
+// them with dryRun
+func (c *Cleaner) Run(dryRun bool) ([]Report, error) {
+	projects, err := c.projects()
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []Report
+	var total storage.Removed
+	for _, id := range projects {
+		events, err := c.expired(c.settings.GetProject(id))
+		if err != nil {
+			return reports, err
+		}
+
+		removed, err := c.repo.Retention().Delete(events, dryRun)
+		if err != nil {
+			return reports, err
+		}
+		if removed.Events == 0 && removed.Payloads == 0 && removed.Groups == 0 {
+			continue
+		}
+		reports = append(reports, Report{Project: id, Removed: removed})
+
+		total.Events += removed.Events
+		total.Payloads += removed.Payloads
+
+		if !dryRun {
+			log.Printf("Cleanup project %s: %d events, %d payloads, %d groups, %d bytes deleted", id, removed.Events, removed.Payloads, removed.Groups, removed.Bytes)
+		}
+	}
+
+	// the leftovers of an interrupted run are swept once with the next
+	// deletion, the project reports are empty without one
+	if total.Events > 0 {
+		removed, err := c.repo.Retention().Orphans(dryRun)
+		if err != nil {
+			return reports, err
+		}
+		if removed.Payloads > 0 || removed.Groups > 0 {
+			reports = append(reports, Report{Removed: removed})
+			total.Payloads += removed.Payloads
+
+			if !dryRun {
+				log.Printf("Cleanup orphans: %d payloads, %d groups, %d bytes deleted", removed.Payloads, removed.Groups, removed.Bytes)
+			}
+		}
+	}
+
+	if !dryRun && total.Events+total.Payloads > 0 {
+		if err := c.repo.Retention().Compact(); err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
+
+// projects returns the configured projects and the ones having events
+func (c *Cleaner) projects() ([]string, error) {
+	ids := make(map[string]bool)
+	for _, p := range c.settings.Project {
+		ids[p.Id] = true
+	}
+
+	counts, err := c.repo.Groups().Projects()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range counts {
+		ids[p.ProjectId] = true
+	}
+
+	var list []string
+	for id := range ids {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// expired returns the events over any of the limits of the project
+func (c *Cleaner) expired(project config.Project) ([]int64, error) {
+	retention := c.repo.Retention()
+	r := project.Retention
+
+	ids := make(map[int64]bool)
+	add := func(list []int64, err error) error {
+		for _, id := range list {
+			ids[id] = true
+		}
+		return err
+	}
+
+	if r.MaxAge > 0 {
+		if err := add(retention.Aged(project.Id, time.Now().AddDate(0, 0, -r.MaxAge))); err != nil {
+			return nil, err
+		}
+	}
+	if r.MaxEvents > 0 {
+		if err := add(retention.Overflow(project.Id, r.MaxEvents)); err != nil {
+			return nil, err
+		}
+	}
+	if r.MaxSize > 0 {
+		if err := add(retention.Oversize(project.Id, r.MaxSize*1024*1024)); err != nil {
+			return nil, err
+		}
+	}
+
+	var list []int64
+	for id := range ids {
+		list = append(list, id)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list, nil
+}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"context"
-	"database/sql"
 	"log"
 	"net/http"
 	"runtime/debug"
@@ -10,13 +9,23 @@ import (
 	"time"
 
 	"github.com/alexedwards/stack"
-	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/sessions"
 	"github.com/nbari/violetear"
+	"github.com/scr34m/proof/cleanup"
 	"github.com/scr34m/proof/config"
-	m "github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/filter"
+	"github.com/scr34m/proof/forward"
+	"github.com/scr34m/proof/live"
+	"github.com/scr34m/proof/meter"
 	"github.com/scr34m/proof/notification"
+	"github.com/scr34m/proof/notify"
+	"github.com/scr34m/proof/queue"
 	r "github.com/scr34m/proof/router"
+	"github.com/scr34m/proof/sample"
+	"github.com/scr34m/proof/scrub"
+	"github.com/scr34m/proof/spool"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
 )
 
 type Frontend interface {
@@ -24,33 +33,89 @@ type Frontend interface {
 }
 
 type frontend struct {
-	ctx      context.Context
-	db       *sql.DB
-	notif    *notification.Notification
-	auth     *config.AuthConfig
-	store    *sessions.CookieStore
-	mailer   *m.Mailer
-	redis    *redis.Client
-	redisKey string
-	queue    bool
+	ctx       context.Context
+	repo      storage.Storage
+	users     storage.UserStore
+	notif     *notification.Notification
+	auth      *config.AuthConfig
+	settings  *config.Config
+	store     *sessions.CookieStore
+	notifier  *notify.Dispatcher
+	hooks     *webhook.Sender
+	forwarder *forward.Forwarder
+	cleaner   *cleanup.Cleaner
+	scrubber  *scrub.Scrubber
+	filter    *filter.Filter
+	sampler   *sample.Sampler
+	events    queue.Queue
+	spool     *spool.Spool
+	queue     bool
+	live      *live.Hub
+	meter     *meter.Meter
 }
 
-func NewFrontend(ctx context.Context, db *sql.DB, notif *notification.Notification, auth *config.AuthConfig, store *sessions.CookieStore, mailer *m.Mailer, redis *redis.Client, redisKey string, queue bool) Frontend {
+func NewFrontend(ctx context.Context, repo storage.Storage, users storage.UserStore, notif *notification.Notification, auth *config.AuthConfig, settings *config.Config, store *sessions.CookieStore, notifier *notify.Dispatcher, hooks *webhook.Sender, forwarder *forward.Forwarder, cleaner *cleanup.Cleaner, scrubber *scrub.Scrubber, filter *filter.Filter, sampler *sample.Sampler, events queue.Queue, spool *spool.Spool, queue bool) Frontend {
 	f := &frontend{
-		ctx:      ctx,
-		db:       db,
-		notif:    notif,
-		auth:     auth,
-		store:    store,
-		mailer:   mailer,
-		redis:    redis,
-		redisKey: redisKey,
-		queue:    queue,
+		ctx:       ctx,
+		repo:      repo,
+		users:     users,
+		notif:     notif,
+		auth:      auth,
+		settings:  settings,
+		store:     store,
+		notifier:  notifier,
+		hooks:     hooks,
+		forwarder: forwarder,
+		cleaner:   cleaner,
+		scrubber:  scrubber,
+		filter:    filter,
+		sampler:   sampler,
+		events:    events,
+		spool:     spool,
+		queue:     queue,
+		live:      live.NewHub(),
+		meter:     meter.NewMeter(),
 	}
 	return f
 }
 
 func (f *frontend) Start(listen string) {
+	if f.forwarder != nil && !f.queue {
+		f.forwarder.Start(f.ctx)
+	}
+
+	// in frontend mode the workers notify
+	if !f.queue {
+		f.notifier.Start(f.ctx)
+	}
+
+	// resolving in the web UI fires webhooks in every mode
+	f.hooks.Start(f.ctx)
+
+	// in frontend mode the workers clean up
+	if f.cleaner != nil && !f.queue {
+		f.cleaner.Start(f.ctx)
+	}
+
+	if f.spool != nil {
+		f.spool.Start(f.ctx)
+	}
+
+	// in frontend mode the workers publish the processed events
+	if f.queue {
+		f.live.Follow(f.ctx, f.events, func(n queue.Notice) {
+			if f.notif != nil && (n.New || n.Regression) {
+				f.notif.Ping(n.GroupId, n.Message, n.ServerName, n.Level)
+			}
+		})
+	}
+
+	log.Fatal(http.ListenAndServe(listen, f.handler()))
+}
+
+// handler routes the requests through the middlewares of the pages, the
+// ingest api and the public links
+func (f *frontend) handler() http.Handler {
 	router := violetear.New()
 	router.AddRegex(":num", `[0-9]+`)
 	router.AddRegex(":any", `*`)
@@ -60,32 +125,66 @@ func (f *frontend) Start(listen string) {
 	router.Handle("/", stk.Then(r.Index), "GET")
 	router.Handle("/login", stk.Then(r.Login), "GET, POST")
 	router.Handle("/status/:any", stk.Then(r.Status), "GET")
+	router.Handle("/live", stk.Then(r.Live), "GET")
 	router.Handle("/acknowledge/:num/:num", stk.Then(r.Acknowledge), "POST")
+	router.Handle("/assign/:num", stk.Then(r.Assign), "POST")
 	router.Handle("/details/:num", stk.Then(r.Details), "GET")
 	router.Handle("/details/:num/:num", stk.Then(r.Details), "GET")
+	router.Handle("/forward", stk.Then(r.Forward), "GET")
+	router.Handle("/queue", stk.Then(r.Queue), "GET")
+	router.Handle("/queue/replay/:any", stk.Then(r.QueueReplay), "POST")
+	router.Handle("/queue/discard/:any", stk.Then(r.QueueDiscard), "POST")
+	router.Handle("/queue/spool", stk.Then(r.QueueSpool), "GET")
+	router.Handle("/queue/stats", stk.Then(r.QueueStats), "GET")
+	router.Handle("/webhooks", stk.Then(r.Webhooks), "GET")
+	router.Handle("/webhooks/redeliver/:num", stk.Then(r.WebhookRedeliver), "POST")
+	router.Handle("/alerts", stk.Then(r.Alerts), "GET")
+	router.Handle("/projects", stk.Then(r.Projects), "GET")
+	router.Handle("/project/:num", stk.Then(r.Project), "GET")
+	router.Handle("/subscribe/:num", stk.Then(r.Subscribe), "POST")
+	router.Handle("/subscribe/project/:num", stk.Then(r.ProjectSubscribe), "POST")
 
 	stk_basic := stack.New(f.loggingHandler, f.authHandler, f.recoverHandler)
 
 	router.Handle("/api/store", stk_basic.Then(r.Parser), "POST")
 	router.Handle("/api/:num/envelope", stk_basic.Then(r.Parser), "POST")
+	router.Handle("/api/:num/security", stk_basic.Then(r.Security), "POST")
+
+	stk_tunnel := stack.New(f.loggingHandler, f.recoverHandler)
+
+	router.Handle("/api/tunnel", stk_tunnel.Then(r.Tunnel), "POST")
+
+	// the links of the emails work without logging in
+	stk_public := stack.New(f.loggingHandler, f.recoverHandler)
+
+	router.Handle("/unsubscribe", stk_public.Then(r.Unsubscribe), "GET, POST")
 
 	fs := http.FileServer(http.Dir("assets"))
 	router.Handle("/assets/*", http.StripPrefix("/assets/", fs))
 
-	log.Fatal(http.ListenAndServe(listen, router))
+	return router
 }
 
 func (f *frontend) loggingHandler(ctx *stack.Context, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx.Put("db", f.db)
+		ctx.Put("repo", f.repo)
+		ctx.Put("users", f.users)
 		ctx.Put("notif", f.notif)
 		ctx.Put("auth", f.auth)
 		ctx.Put("store", f.store)
-		ctx.Put("mailer", f.mailer)
+		ctx.Put("notifier", f.notifier)
+		ctx.Put("webhooks", f.hooks)
+		ctx.Put("forwarder", f.forwarder)
+		ctx.Put("scrubber", f.scrubber)
+		ctx.Put("filter", f.filter)
+		ctx.Put("sampler", f.sampler)
+		ctx.Put("settings", f.settings)
 		ctx.Put("queue", f.queue)
 		ctx.Put("ctx", f.ctx)
-		ctx.Put("redis", f.redis)
-		ctx.Put("redisKey", f.redisKey)
+		ctx.Put("events", f.events)
+		ctx.Put("spool", f.spool)
+		ctx.Put("live", f.live)
+		ctx.Put("meter", f.meter)
 		t1 := time.Now()
 		next.ServeHTTP(w, r)
 		t2 := time.Now()
@@ -134,6 +233,9 @@ func (f *frontend) authHandler(ctx *stack.Context, next http.Handler) http.Handl
 		user, pass, ok := r.BasicAuth()
 
 		sentry_auth := parseSentryAuth(r.Header.Get("X-Sentry-Auth"))
+		if len(sentry_auth) == 0 {
+			sentry_auth = parseSentryQuery(r)
+		}
 		if len(sentry_auth) > 0 {
 
 			version := sentry_auth["sentry_version"]
@@ -187,6 +289,22 @@ func parseSentryAuth(header string) map[string]string {
 	return list
 }
 
+// Parse sentry_key from the query string, used by browsers which can not set headers
+// ex.: /api/1/security/?sentry_key=a4f7646fd83544dd9499c18561338d56
+func parseSentryQuery(r *http.Request) map[string]string {
+	list := make(map[string]string)
+	query := r.URL.Query()
+	if query.Get("sentry_key") == "" {
+		return list
+	}
+	list["sentry_key"] = query.Get("sentry_key")
+	list["sentry_version"] = query.Get("sentry_version")
+	if list["sentry_version"] == "" {
+		list["sentry_version"] = "7"
+	}
+	return list
+}
+
 func (f *frontend) recoverHandler(ctx *stack.Context, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/notify"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
+)
+
+const siteKey = "a4f7646fd83544dd9499c18561338d56"
+
+// TestMain runs the tests from the root of the repository, the templates are
+// read from there
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestFrontend returns the frontend storing the events right away in the
+// in-memory store, auth may be nil
+func newTestFrontend(t *testing.T, auth *config.AuthConfig) (*frontend, storage.Storage) {
+	t.Helper()
+
+	repo := storage.NewMemory()
+	settings := &config.Config{}
+	hooks, err := webhook.NewSender(nil, repo, "http://localhost:2017")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := notify.NewDispatcher(settings, "http://localhost:2017", nil, nil, repo, hooks, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFrontend(context.Background(), repo, nil, nil, auth, settings, nil, notifier, hooks, nil, nil, nil, nil, nil, nil, nil, false)
+	return f.(*frontend), repo
+}
+
+func testAuth() *config.AuthConfig {
+	return &config.AuthConfig{Site: []config.AuthSite{{Name: "1", Username: siteKey, Password: "62b2a152380044f28753c26a83cf2ee3", Project: "1", Enabled: true}}}
+}
+
+func request(t *testing.T, h http.Handler, method string, target string, body string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestTunnelMessageEvent(t *testing.T) {
+	f, repo := newTestFrontend(t, testAuth())
+
+	// the browser SDKs send captured messages without an exception
+	envelope := `{"event_id":"9ec79c33ec9942ab8353589fcb2e04dc","dsn":"https://` + siteKey + `@proof.example.com/1"}` + "\n" +
+		`{"type":"event"}` + "\n" +
+		`{"message":"Checkout opened","level":"info","platform":"javascript","timestamp":1700000000.5}` + "\n"
+
+	w := request(t, f.handler(), "POST", "/api/tunnel", envelope, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("tunnel responded %d: %s", w.Code, w.Body.String())
+	}
+
+	groups, err := repo.Groups().List(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Message != "Checkout opened" || groups[0].Level != "info" || groups[0].ProjectId != "1" {
+		t.Fatalf("stored groups: %+v", groups)
+	}
+}
+
+func TestTunnelUnknownKey(t *testing.T) {
+	f, repo := newTestFrontend(t, testAuth())
+
+	envelope := `{"dsn":"https://0000@proof.example.com/1"}` + "\n" +
+		`{"type":"event"}` + "\n" +
+		`{"message":"Checkout opened","timestamp":1700000000}` + "\n"
+
+	w := request(t, f.handler(), "POST", "/api/tunnel", envelope, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("tunnel responded %d", w.Code)
+	}
+	if groups, _ := repo.Groups().List(0); len(groups) != 0 {
+		t.Fatalf("stored groups: %+v", groups)
+	}
+}
+
+func TestStore(t *testing.T) {
+	f, repo := newTestFrontend(t, testAuth())
+
+	envelope := `{"event_id":"9ec79c33ec9942ab8353589fcb2e04dc"}` + "\n" +
+		`{"type":"event"}` + "\n" +
+		`{"platform":"go","level":"error","timestamp":1700000000,"exception":{"values":[{"type":"TypeError","value":"undefined is not a function"}]}}` + "\n"
+	header := map[string]string{"X-Sentry-Auth": "Sentry sentry_version=7, sentry_client=sentry.go/0.20.0, sentry_key=" + siteKey}
+
+	w := request(t, f.handler(), "POST", "/api/1/envelope", envelope, header)
+	if w.Code != http.StatusOK {
+		t.Fatalf("envelope responded %d: %s", w.Code, w.Body.String())
+	}
+
+	groups, err := repo.Groups().List(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Message != "undefined is not a function" || groups[0].ProjectId != "1" {
+		t.Fatalf("stored groups: %+v", groups)
+	}
+	if _, err := repo.Events().Latest(groups[0].Id); err != nil {
+		t.Fatalf("stored event: %v", err)
+	}
+}
+
+func TestStoreAuthFailure(t *testing.T) {
+	f, repo := newTestFrontend(t, testAuth())
+
+	body := `{"message":"Checkout opened","timestamp":1700000000}`
+	for name, header := range map[string]map[string]string{
+		"no header":    nil,
+		"unknown key":  {"X-Sentry-Auth": "Sentry sentry_version=7, sentry_key=0000"},
+		"wrong secret": {"X-Sentry-Auth": "Sentry sentry_version=4, sentry_key=" + siteKey + ", sentry_secret=0000"},
+	} {
+		w := request(t, f.handler(), "POST", "/api/store", body, header)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: store responded %d", name, w.Code)
+		}
+	}
+
+	if groups, _ := repo.Groups().List(0); len(groups) != 0 {
+		t.Fatalf("stored groups: %+v", groups)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	f, repo := newTestFrontend(t, nil)
+
+	open := storage.Group{ProjectId: "1", Checksum: "a", Level: "error", Message: "Connection refused\nat dial", Platform: "go"}
+	resolved := storage.Group{ProjectId: "1", Checksum: "b", Level: "error", Message: "Resolved before", Platform: "go"}
+	for _, g := range []*storage.Group{&open, &resolved} {
+		if _, err := repo.Groups().Upsert(g, time.Unix(1700000000, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Groups().SetStatus(resolved.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	w := request(t, f.handler(), "GET", "/", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("index responded %d: %s", w.Code, w.Body.String())
+	}
+	page := w.Body.String()
+	if link := fmt.Sprintf(`<a href="/details/%d">Connection refused</a>`, open.Id); !strings.Contains(page, link) {
+		t.Errorf("the open group is not linked by the first line of its message")
+	}
+	if strings.Contains(page, "Resolved before") {
+		t.Errorf("the resolved group is listed")
+	}
+}
+
+func TestIgnoreAssign(t *testing.T) {
+	auth := testAuth()
+	auth.User = []config.AuthUser{{Name: "Ops", Email: "ops@example.com", Password: "1", Enabled: true}}
+
+	repo := storage.NewMemory()
+	settings := &config.Config{}
+	hooks, err := webhook.NewSender([]config.Webhook{{Url: "http://bot.example.com/proof", Enabled: true}}, repo, "http://localhost:2017")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := notify.NewDispatcher(settings, "http://localhost:2017", nil, nil, repo, hooks, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := sessions.NewCookieStore([]byte("secret"))
+	h := NewFrontend(context.Background(), repo, storage.NewConfigUsers(auth), nil, auth, settings, store, notifier, hooks, nil, nil, nil, nil, nil, nil, nil, false).(*frontend).handler()
+
+	// the session cookie of the logged in user
+	w := httptest.NewRecorder()
+	session, _ := store.Get(httptest.NewRequest("GET", "/", nil), config.SESSION_NAME)
+	session.Values[config.COOKIE_KEY_AUTH] = 0
+	if err := session.Save(httptest.NewRequest("GET", "/", nil), w); err != nil {
+		t.Fatal(err)
+	}
+	header := map[string]string{"Cookie": strings.Split(w.Header().Get("Set-Cookie"), ";")[0], "Content-Type": "application/x-www-form-urlencoded"}
+
+	g := storage.Group{ProjectId: "1", Checksum: "a", Level: "error", Message: "Connection refused", Platform: "go"}
+	if _, err := repo.Groups().Upsert(&g, time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{"assignee=nobody@example.com", "assignee=ops@example.com"} {
+		if w := request(t, h, "POST", fmt.Sprintf("/assign/%d", g.Id), body, header); w.Code != http.StatusOK {
+			t.Fatalf("assign responded %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if w := request(t, h, "POST", fmt.Sprintf("/acknowledge/%d/2", g.Id), "", header); w.Code != http.StatusOK {
+		t.Fatalf("acknowledge responded %d: %s", w.Code, w.Body.String())
+	}
+
+	found, err := repo.Groups().Get(g.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Assignee != "ops@example.com" || found.Status != storage.GroupIgnored {
+		t.Fatalf("group: %+v", found)
+	}
+
+	// the unknown user is refused
+	deliveries, err := repo.Deliveries().List(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Action != webhook.Ignored || deliveries[1].Action != webhook.Assigned {
+		t.Fatalf("deliveries: %+v", deliveries)
+	}
+	if !strings.Contains(deliveries[0].Payload, `"ignored":true,"assignee":"ops@example.com"`) {
+		t.Errorf("payload: %s", deliveries[0].Payload)
+	}
+}
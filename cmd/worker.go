@@ -2,42 +2,118 @@ package cmd
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/go-redis/redis/v8"
-	"github.com/scr34m/proof/config"
-	m "github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/cleanup"
+	"github.com/scr34m/proof/filter"
+	"github.com/scr34m/proof/forward"
+	"github.com/scr34m/proof/meter"
+	"github.com/scr34m/proof/notify"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/queue"
 	"github.com/scr34m/proof/router"
+	"github.com/scr34m/proof/sample"
+	"github.com/scr34m/proof/scrub"
 	"github.com/scr34m/proof/shared"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
+)
+
+const (
+	// DrainTimeout is how long the in-flight packets may take on shutdown
+	// before they are logged, they are waited for anyway
+	DrainTimeout = 30 * time.Second
+	// StatsInterval of the per-worker throughput log
+	StatsInterval = time.Minute
+	// DueInterval of moving the due retries back to the queue
+	DueInterval = time.Second
+	// HeartbeatInterval of registering the worker process, it is gone from
+	// the queue page after HeartbeatTTL
+	HeartbeatInterval = 10 * time.Second
+	HeartbeatTTL      = 30 * time.Second
+	// RecoverInterval of queueing again the packets of the worker processes
+	// without a heartbeat
+	RecoverInterval = HeartbeatTTL
+	// BatchPoll is the wait between the tries to fill a batch
+	BatchPoll = 10 * time.Millisecond
 )
 
 type Worker interface {
 	Start()
 }
 
+// inflight are the packets taken from the queue and not finished yet
+type inflight struct {
+	messages []*queue.Message
+	started  time.Time
+}
+
+type workerStats struct {
+	processed int64
+	failed    int64
+	dead      int64
+}
+
 type worker struct {
-	ctx      context.Context
-	db       *sql.DB
-	auth     *config.AuthConfig
-	mailer   *m.Mailer
-	redis    *redis.Client
-	redisKey string
+	ctx       context.Context
+	repo      storage.Storage
+	notifier  *notify.Dispatcher
+	hooks     *webhook.Sender
+	forwarder *forward.Forwarder
+	cleaner   *cleanup.Cleaner
+	scrubber  *scrub.Scrubber
+	filter    *filter.Filter
+	sampler   *sample.Sampler
+	events    queue.Queue
+	name      string
+	workers   int
+	// batchSize packets are stored in one transaction, the first one waits at
+	// most batchLatency for the others
+	batchSize    int
+	batchLatency time.Duration
+
+	meter    *meter.Meter
+	started  time.Time
+	mu       sync.Mutex
+	inflight map[int]inflight
+	stats    []workerStats
 }
 
-func NewWorker(ctx context.Context, db *sql.DB, auth *config.AuthConfig, mailer *m.Mailer, redis *redis.Client, redisKey string) Worker {
+func NewWorker(ctx context.Context, repo storage.Storage, notifier *notify.Dispatcher, hooks *webhook.Sender, forwarder *forward.Forwarder, cleaner *cleanup.Cleaner, scrubber *scrub.Scrubber, filter *filter.Filter, sampler *sample.Sampler, events queue.Queue, name string, workers int, batchSize int, batchLatency time.Duration) Worker {
+	if workers < 1 {
+		workers = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
 	w := &worker{
-		ctx:      ctx,
-		db:       db,
-		auth:     auth,
-		mailer:   mailer,
-		redis:    redis,
-		redisKey: redisKey,
+		ctx:          ctx,
+		repo:         repo,
+		notifier:     notifier,
+		hooks:        hooks,
+		forwarder:    forwarder,
+		cleaner:      cleaner,
+		scrubber:     scrubber,
+		filter:       filter,
+		sampler:      sampler,
+		events:       events,
+		name:         name,
+		workers:      workers,
+		batchSize:    batchSize,
+		batchLatency: batchLatency,
+		meter:        meter.NewMeter(),
+		started:      time.Now(),
+		inflight:     make(map[int]inflight),
+		stats:        make([]workerStats, workers),
 	}
 	return w
 }
@@ -60,34 +136,370 @@ func (w *worker) Start() {
 		}
 	}()
 
-	if err := w.loop(ctx); err != nil {
-		log.Panic(err)
+	if w.forwarder != nil {
+		w.forwarder.Start(ctx)
+	}
+
+	if w.cleaner != nil {
+		w.cleaner.Start(ctx)
+	}
+
+	// the draining events may notify too
+	w.notifier.Start(w.ctx)
+	w.hooks.Start(w.ctx)
+
+	// the heartbeat goes on while the events are draining, so the others do
+	// not recover them meanwhile
+	beat, stopBeat := context.WithCancel(w.ctx)
+	defer stopBeat()
+	w.beat(beat)
+	go w.heartbeat(beat)
+
+	// the packets of the worker processes gone, a previous run of this one
+	// included
+	w.recover(ctx)
+
+	if w.batchSize > 1 {
+		log.Printf("Starting %d workers as %s, storing up to %d events at once", w.workers, w.name, w.batchSize)
+	} else {
+		log.Printf("Starting %d workers as %s", w.workers, w.name)
 	}
+
+	var wg sync.WaitGroup
+	for id := 0; id < w.workers; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			w.loop(ctx, id)
+		}(id)
+	}
+
+	go w.logStats(ctx)
+	go w.due(ctx)
+
+	<-ctx.Done()
+	log.Println("Shutting down, waiting for the in-flight events")
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// a running event may still be stored, it is not given back to the queue
+	select {
+	case <-done:
+	case <-time.After(DrainTimeout):
+		w.logInflight()
+		<-done
+	}
+	w.logTotals()
+}
+
+// consumer names the processing list of the worker
+func (w *worker) consumer(id int) string {
+	return fmt.Sprintf("%s:%d", w.name, id)
 }
 
-func (w *worker) loop(ctx context.Context) error {
+func (w *worker) loop(ctx context.Context, id int) {
 	for {
 		select {
 		case <-ctx.Done():
-			return nil
+			return
 		default:
-			val, _ := w.redis.BRPop(w.ctx, 1*time.Second, w.redisKey).Result()
-			if val != nil {
-				if val[1] != "0" {
-					log.Println("Processing event")
-
-					queuePacket := shared.QueuePacket{}
-					err := json.Unmarshal([]byte(val[1]), &queuePacket)
-					if err != nil {
-						return err
-					}
-
-					_, err = router.ProcessBody(w.db, w.auth, w.mailer, queuePacket)
-					if err != nil {
-						return err
-					}
+		}
+
+		msg, err := w.events.Pop(ctx, w.consumer(id), time.Second)
+		if errors.Is(err, queue.ErrMalformed) {
+			w.count(id, false, true)
+			log.Printf("Worker %d: %v, moved to the dead letters", id, err)
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Worker %d: %v", id, err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+
+		batch := w.collect(ctx, id, msg)
+
+		// taken while shutting down, leave them for the next start
+		if ctx.Err() != nil {
+			for _, msg := range batch {
+				if err := w.events.Requeue(context.Background(), msg); err != nil {
+					log.Printf("Worker %d: requeue failed: %v", id, err)
+				}
+			}
+			return
+		}
+
+		w.process(id, batch)
+	}
+}
+
+// collect takes more packets after the first one until the batch is full or
+// the latency is over
+func (w *worker) collect(ctx context.Context, id int, first *queue.Message) []*queue.Message {
+	batch := []*queue.Message{first}
+	deadline := time.Now().Add(w.batchLatency)
+
+	for len(batch) < w.batchSize && ctx.Err() == nil {
+		msg, err := w.events.Pop(ctx, w.consumer(id), 0)
+		if errors.Is(err, queue.ErrMalformed) {
+			w.count(id, false, true)
+			log.Printf("Worker %d: %v, moved to the dead letters", id, err)
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Worker %d: %v", id, err)
+			}
+			break
+		}
+		if msg != nil {
+			batch = append(batch, msg)
+			continue
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		if wait > BatchPoll {
+			wait = BatchPoll
+		}
+		time.Sleep(wait)
+	}
+	return batch
+}
+
+func (w *worker) process(id int, batch []*queue.Message) {
+	w.mu.Lock()
+	w.inflight[id] = inflight{messages: batch, started: time.Now()}
+	w.mu.Unlock()
+
+	statuses, errs := w.handle(batch)
+
+	w.mu.Lock()
+	delete(w.inflight, id)
+	w.mu.Unlock()
+
+	for i, msg := range batch {
+		w.finish(id, msg, statuses[i], errs[i])
+	}
+}
+
+// finish acknowledges the processed packet or fails it
+func (w *worker) finish(id int, msg *queue.Message, status *parser.ProcessStatus, err error) {
+	// the shutdown must not stop the bookkeeping of a finished packet
+	c := context.Background()
+	if err == nil {
+		w.count(id, true, false)
+		w.meter.Out()
+		if err := w.events.Ack(c, msg); err != nil {
+			log.Printf("Worker %d: ack failed: %v", id, err)
+		}
+
+		// the event is stored, a frontend missing the notice is not a failure
+		if status != nil {
+			if err := w.events.Publish(c, router.NewNotice(status)); err != nil {
+				log.Printf("Live update publish failed: %v", err)
+			}
+		}
+		return
+	}
+
+	w.meter.Fail(err, msg.Packet)
+	dead, ferr := w.events.Fail(c, msg, err)
+	w.count(id, false, dead)
+	if ferr != nil {
+		log.Printf("Worker %d: processing failed: %v, keeping it failed too: %v", id, err, ferr)
+	} else if dead {
+		log.Printf("Worker %d: processing failed: %v, moved to the dead letters after %d attempts", id, err, msg.Packet.Attempts)
+	} else {
+		log.Printf("Worker %d: processing failed: %v, retry %d of %d", id, err, msg.Packet.Attempts, queue.MaxAttempts-1)
+	}
+}
+
+func (w *worker) count(id int, processed bool, dead bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if processed {
+		w.stats[id].processed++
+	} else {
+		w.stats[id].failed++
+	}
+	if dead {
+		w.stats[id].dead++
+	}
+}
+
+// handle processes the batch, a panic fails its packets. They are not
+// processed again here, the stored events would be announced twice
+func (w *worker) handle(batch []*queue.Message) (statuses []*parser.ProcessStatus, errs []error) {
+	// a malformed packet must not take the other workers down
+	defer func() {
+		if r := recover(); r != nil {
+			statuses = make([]*parser.ProcessStatus, len(batch))
+			errs = make([]error, len(batch))
+			for i := range batch {
+				errs[i] = fmt.Errorf("panic: %v", r)
+			}
+		}
+	}()
+
+	packets := make([]shared.QueuePacket, len(batch))
+	for i, msg := range batch {
+		packets[i] = msg.Packet
+	}
+	return router.ProcessBatch(w.repo, w.notifier, w.hooks, w.forwarder, w.scrubber, w.filter, w.sampler, packets)
+}
+
+func (w *worker) logInflight() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for id, p := range w.inflight {
+		log.Printf("Worker %d: still waiting for %d events running for %v", id, len(p.messages), time.Since(p.started))
+	}
+}
+
+// due moves the retries back to the queue when their delay is over and
+// recovers the packets of the gone worker processes
+func (w *worker) due(ctx context.Context) {
+	ticker := time.NewTicker(DueInterval)
+	defer ticker.Stop()
+
+	recovered := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.events.Due(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Moving the due retries failed: %v", err)
+			}
+			if time.Since(recovered) > RecoverInterval {
+				recovered = time.Now()
+				w.recover(ctx)
+			}
+		}
+	}
+}
+
+// recover queues again the packets of the worker processes without a
+// heartbeat, the names are unique to a run so a live one is never taken over
+func (w *worker) recover(ctx context.Context) {
+	workers, err := w.events.Workers(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Recovering the unfinished events failed: %v", err)
+		}
+		return
+	}
+
+	alive := make(map[string]bool)
+	for _, h := range workers {
+		alive[h.Name] = true
+	}
+	stale := func(consumer string) bool {
+		// the consumers are named <worker name>:<id>
+		i := strings.LastIndex(consumer, ":")
+		if i == -1 {
+			return false
+		}
+		return !alive[consumer[:i]]
+	}
+
+	n, err := w.events.Recover(ctx, stale)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Recovering the unfinished events failed: %v", err)
+		}
+	} else if n > 0 {
+		log.Printf("%d unfinished events queued again", n)
+	}
+}
+
+// heartbeat registers the worker process for the queue page and the
+// recovery of the others
+func (w *worker) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.beat(ctx)
+	}
+}
+
+func (w *worker) beat(ctx context.Context) {
+	host, _ := os.Hostname()
+	h := queue.Heartbeat{
+		Name:     w.name,
+		Host:     host,
+		Pid:      os.Getpid(),
+		Workers:  w.workers,
+		Started:  w.started,
+		Seen:     time.Now(),
+		Failures: w.meter.Failures(),
+	}
+	_, h.Rate = w.meter.Rates()
+
+	w.mu.Lock()
+	h.Busy = len(w.inflight)
+	for _, s := range w.stats {
+		h.Processed += s.processed
+		h.Failed += s.failed
+		h.Dead += s.dead
+	}
+	w.mu.Unlock()
+
+	if err := w.events.Beat(ctx, h, HeartbeatTTL); err != nil && ctx.Err() == nil {
+		log.Printf("Heartbeat failed: %v", err)
+	}
+}
+
+func (w *worker) logStats(ctx context.Context) {
+	ticker := time.NewTicker(StatsInterval)
+	defer ticker.Stop()
+
+	last := make([]workerStats, w.workers)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			for id, s := range w.stats {
+				processed := s.processed - last[id].processed
+				failed := s.failed - last[id].failed
+				dead := s.dead - last[id].dead
+				if processed > 0 || failed > 0 {
+					log.Printf("Worker %d: %d events processed, %d failed, %d dead in the last %v", id, processed, failed, dead, StatsInterval)
 				}
+				last[id] = s
 			}
+			w.mu.Unlock()
 		}
 	}
 }
+
+func (w *worker) logTotals() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for id, s := range w.stats {
+		log.Printf("Worker %d: %d events processed, %d failed, %d dead", id, s.processed, s.failed, s.dead)
+	}
+}
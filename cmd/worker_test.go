@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/queue"
+	"github.com/scr34m/proof/shared"
+)
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	events := queue.NewMemory()
+	w := &worker{events: events, name: "web-1-5f3a9c"}
+
+	// a previous run on the same host, another running process and this one
+	consumers := []string{"web-1-0b71e2:0", "web-2-c4d820:0", w.consumer(0)}
+	for i, consumer := range consumers {
+		if err := events.Push(ctx, shared.QueuePacket{Body: []byte(consumer), ProjectId: "1"}); err != nil {
+			t.Fatal(err)
+		}
+		m, err := events.Pop(ctx, consumer, 0)
+		if err != nil || m == nil {
+			t.Fatalf("pop %d: %v", i, err)
+		}
+	}
+	for name, ttl := range map[string]time.Duration{"web-1-0b71e2": -time.Second, "web-2-c4d820": time.Minute, w.name: time.Minute} {
+		if err := events.Beat(ctx, queue.Heartbeat{Name: name}, ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w.recover(ctx)
+
+	m, err := events.Pop(ctx, w.consumer(1), 0)
+	if err != nil || m == nil || string(m.Packet.Body) != "web-1-0b71e2:0" {
+		t.Fatalf("recovered %+v: %v", m, err)
+	}
+	if m, _ := events.Pop(ctx, w.consumer(1), 0); m != nil {
+		t.Fatalf("the packet of a live process recovered: %s", m.Packet.Body)
+	}
+}
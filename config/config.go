@@ -18,10 +18,201 @@ type AuthSite struct {
 	Name     string
 	Username string
 	Password string
+	Project  string
 	Enabled  bool
 }
 
+// Forward sends the received events to an upstream Sentry or Proof instance
+type Forward struct {
+	Dsn          string
+	Projects     []string
+	Levels       []string
+	Environments []string
+	Enabled      bool
+}
+
+// Webhook posts the issue lifecycle events of the projects as JSON signed with
+// the secret. Events are created, regressed, resolved and event (every
+// stored event), all but event when empty
+type Webhook struct {
+	Url      string
+	Secret   string
+	Events   []string
+	Projects []string
+	Enabled  bool
+}
+
+// ScrubRule masks, hashes or removes values matched by key name, regular
+// expression or JSON path (dot separated, * matches any key or index)
+type ScrubRule struct {
+	Key     string
+	Pattern string
+	Path    string
+	Action  string
+}
+
+// Scrub configures the data scrubbing, the built-in rules are enabled unless
+// Disabled is set
+type Scrub struct {
+	Disabled bool
+	Ip       bool
+	Rules    []ScrubRule
+}
+
+// Filter drops matching events before they are stored, the patterns are case
+// insensitive globs
+type Filter struct {
+	Localhost         bool
+	BrowserExtensions bool
+	LegacyBrowsers    bool
+	Messages          []string
+	Releases          []string
+	Environments      []string
+	UserAgents        []string
+}
+
+// SampleRule stores only the Rate share of payloads of matching events, level
+// is exact, environment and release are globs
+type SampleRule struct {
+	Level       string
+	Environment string
+	Release     string
+	Rate        float64
+}
+
+// Sampling keeps updating the group counters but stores only a sample of the
+// payloads. Spike protection kicks in when one group receives more than
+// SpikeThreshold events in a minute, a negative threshold disables it. The
+// events are counted by each process, so every worker has its own threshold.
+// SpikeRate is nil when it is not set, zero keeps no payloads
+type Sampling struct {
+	Rules          []SampleRule
+	SpikeThreshold int
+	SpikeRate      *float64
+}
+
+// Retention limits the stored events, the oldest ones are deleted first.
+// MaxAge is in days, MaxEvents is per group, MaxSize is the payload megabytes
+// of the project, zero means unlimited
+type Retention struct {
+	MaxAge    int
+	MaxEvents int
+	MaxSize   int64
+}
+
+// Notify posts the new and regressed events of the project to a chat
+// incoming webhook. Type is slack, mattermost, discord or teams, Levels
+// limits the events when set, Channel overrides the one of the webhook where
+// it is supported
+type Notify struct {
+	Type    string
+	Url     string
+	Channel string
+	Levels  []string
+}
+
+// Alert fires its actions for an event of the project when every set
+// condition holds, at most once in Frequency minutes (default 30) for an
+// issue. New and Regression match either of them when both are set, Events
+// and Users count the last Minutes (default 60), Level is the lowest level,
+// Environment, Release (the release the issue was first seen in) and the
+// Tags values are globs. Email lists addresses, team names or users for every
+// enabled user, Webhook lists the urls of configured webhooks
+type Alert struct {
+	Name        string
+	New         bool
+	Regression  bool
+	Events      int
+	Users       int
+	Minutes     int
+	Level       string
+	Environment string
+	Release     string
+	Tags        map[string]string
+	Frequency   int
+	Email       []string
+	Webhook     []string
+	Notify      []Notify
+}
+
+// Digest collects the emails of the subscribed users for Minutes (default 5)
+// and sends one email of the issues instead. Daily and Weekly list the
+// recipients of the summary reports, addresses, team names or users for every
+// enabled user. The reports are sent after Hour o'clock, the weekly one on
+// Monday
+type Digest struct {
+	Enabled bool
+	Minutes int
+	Daily   []string
+	Weekly  []string
+	Hour    int
+}
+
+// Team is a named list of email addresses for the alerts
+type Team struct {
+	Name    string
+	Members []string
+}
+
+type Project struct {
+	Id        string
+	Name      string
+	Scrub     Scrub
+	Filter    Filter
+	Sampling  Sampling
+	Retention Retention
+	Notify    []Notify
+	Alert     []Alert
+}
+
+// Config holds the project settings, it may live in the same file as the
+// authentication config. Retention is the default of the projects
+type Config struct {
+	Forward   []Forward
+	Webhook   []Webhook
+	Team      []Team
+	Digest    Digest
+	Retention Retention
+	Project   []Project
+}
+
+// GetProject returns the settings of the project or the defaults
+func (c *Config) GetProject(id string) Project {
+	p := Project{Id: id}
+	for _, cp := range c.Project {
+		if cp.Id == id {
+			p = cp
+			break
+		}
+	}
+
+	if p.Retention.MaxAge == 0 {
+		p.Retention.MaxAge = c.Retention.MaxAge
+	}
+	if p.Retention.MaxEvents == 0 {
+		p.Retention.MaxEvents = c.Retention.MaxEvents
+	}
+	if p.Retention.MaxSize == 0 {
+		p.Retention.MaxSize = c.Retention.MaxSize
+	}
+	return p
+}
+
 type AuthConfig struct {
 	User []AuthUser
 	Site []AuthSite
 }
+
+// AllowKey reports whether the public key belongs to an enabled site which
+// may send events to the project
+func (c *AuthConfig) AllowKey(key string, projectId string) bool {
+	for _, site := range c.Site {
+		if !site.Enabled || site.Username != key {
+			continue
+		}
+		if site.Project == "" || site.Project == projectId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+const (
+	MySQL    = "mysql"
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// Querier runs the queries on the pool or in a transaction
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Insert(query string, args ...interface{}) (int64, error)
+}
+
+// DB wraps the connection pool and rewrites the queries for the dialect. The
+// queries are written with ? placeholders and `quoted` identifiers, which
+// MySQL and SQLite understand as is
+type DB struct {
+	*sql.DB
+	Dialect string
+}
+
+func Open(dialect string, dsn string) (*DB, error) {
+	driver := dialect
+	switch dialect {
+	case SQLite:
+		driver = "sqlite3"
+	case Postgres:
+		driver = "postgres"
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{DB: db, Dialect: dialect}, nil
+}
+
+// Rebind converts the placeholders to $1, $2... and the identifier quotes to
+// double quotes for Postgres
+func (db *DB) Rebind(query string) string {
+	if db.Dialect != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	literal := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			literal = !literal
+			b.WriteRune(c)
+		case literal:
+			b.WriteRune(c)
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		case c == '`':
+			b.WriteRune('"')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.Rebind(query))
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.Rebind(query), args...)
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.Rebind(query), args...)
+}
+
+// Insert runs the insert and returns the generated id, Postgres has no
+// LastInsertId so RETURNING id is used there
+func (db *DB) Insert(query string, args ...interface{}) (int64, error) {
+	return insert(db, db.Dialect, query, args...)
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, db: db}, nil
+}
+
+// Tx rewrites the queries for the dialect like DB
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.db.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.db.Rebind(query), args...)
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.db.Rebind(query), args...)
+}
+
+func (tx *Tx) Insert(query string, args ...interface{}) (int64, error) {
+	return insert(tx, tx.db.Dialect, query, args...)
+}
+
+func insert(q Querier, dialect string, query string, args ...interface{}) (int64, error) {
+	var id int64
+	if dialect == Postgres {
+		err := q.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	res, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
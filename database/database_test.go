@@ -0,0 +1,102 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// PostgresEnv is the DSN of a throwaway Postgres database for the tests
+const PostgresEnv = "PROOF_TEST_POSTGRES"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query    string
+		postgres string
+	}{
+		{"SELECT id FROM `group` WHERE id = ?", `SELECT id FROM "group" WHERE id = $1`},
+		{"UPDATE `group` SET `level` = ?, seen = seen + ? WHERE id = ?", `UPDATE "group" SET "level" = $1, seen = seen + $2 WHERE id = $3`},
+		// the literals are left as is
+		{"SELECT '?', '`' FROM t WHERE a = ? AND b = 'x?'", `SELECT '?', '` + "`" + `' FROM t WHERE a = $1 AND b = 'x?'`},
+	}
+
+	for _, dialect := range []string{MySQL, SQLite, Postgres} {
+		db := &DB{Dialect: dialect}
+		for _, tt := range tests {
+			want := tt.query
+			if dialect == Postgres {
+				want = tt.postgres
+			}
+			if got := db.Rebind(tt.query); got != want {
+				t.Errorf("%s: %q rebound to %q instead of %q", dialect, tt.query, got, want)
+			}
+		}
+	}
+}
+
+func TestInsert(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := Open(SQLite, filepath.Join(t.TempDir(), "proof.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		testInsert(t, db, "CREATE TABLE `item` (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)")
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(PostgresEnv)
+		if dsn == "" {
+			t.Skipf("%s is not set", PostgresEnv)
+		}
+		db, err := Open(Postgres, dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		if _, err := db.Exec("DROP TABLE IF EXISTS `item`"); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DROP TABLE `item`")
+		testInsert(t, db, "CREATE TABLE `item` (id SERIAL PRIMARY KEY, name TEXT NOT NULL)")
+	})
+}
+
+// testInsert checks the generated ids on the pool and in a transaction
+func testInsert(t *testing.T, db *DB, schema string) {
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := db.Insert("INSERT INTO `item` (name) VALUES (?)", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tx.Insert("INSERT INTO `item` (name) VALUES (?)", "second")
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if first == 0 || second <= first {
+		t.Fatalf("generated ids %d and %d", first, second)
+	}
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM `item` WHERE id = ?", second).Scan(&name); err != nil || name != "second" {
+		t.Fatalf("inserted row %q: %v", name, err)
+	}
+}
@@ -0,0 +1,229 @@
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/parser"
+)
+
+/**
+ * https://docs.sentry.io/product/data-management-settings/filtering/
+ */
+
+const (
+	ReasonLocalhost        = "localhost"
+	ReasonBrowserExtension = "browser-extension"
+	ReasonLegacyBrowser    = "legacy-browser"
+	ReasonMessage          = "message"
+	ReasonRelease          = "release"
+	ReasonEnvironment      = "environment"
+	ReasonUserAgent        = "user-agent"
+)
+
+var extensionFrame = regexp.MustCompile(`^(chrome|chrome-extension|moz-extension|safari-extension|safari-web-extension|ms-browser-extension|resource|webkit-masked-url):`)
+
+var extensionMessages = []string{
+	"top.GLOBALS",
+	"originalCreateNotification",
+	"canvas.contentDocument",
+	"MyApp_RemoveAllHighlights",
+	"http://tt.epicplay.com",
+	"Can't find variable: ZiteReader",
+	"jigsaw is not defined",
+	"ComboSearch is not defined",
+	"http://loading.retry.widdit.com/",
+	"atomicFindClose",
+	"fb_xd_fragment",
+	"bmi_SafeAddOnload",
+	"EBCallBackMessageReceived",
+	"conduitPage",
+}
+
+var legacyBrowser = []*regexp.Regexp{
+	regexp.MustCompile(`MSIE \d+\.`),
+	regexp.MustCompile(`Trident/\d+\.`),
+	regexp.MustCompile(`Opera/9\.80 .*Presto/`),
+	regexp.MustCompile(`Android [1-3]\.\d.*AppleWebKit/53[0-4]\.`),
+	regexp.MustCompile(`Version/[1-5]\.\d.* Safari/`),
+}
+
+var localhosts = []string{"localhost", "127.0.0.1", "::1"}
+
+type rules struct {
+	config.Filter
+	messages     []*regexp.Regexp
+	releases     []*regexp.Regexp
+	environments []*regexp.Regexp
+	userAgents   []*regexp.Regexp
+}
+
+type Filter struct {
+	projects map[string]*rules
+}
+
+func NewFilter(settings *config.Config) (*Filter, error) {
+	f := &Filter{projects: make(map[string]*rules)}
+
+	for _, p := range settings.Project {
+		r := &rules{Filter: p.Filter}
+		var err error
+		if r.messages, err = globs(p.Filter.Messages); err != nil {
+			return nil, fmt.Errorf("project %s: %v", p.Id, err)
+		}
+		if r.releases, err = globs(p.Filter.Releases); err != nil {
+			return nil, fmt.Errorf("project %s: %v", p.Id, err)
+		}
+		if r.environments, err = globs(p.Filter.Environments); err != nil {
+			return nil, fmt.Errorf("project %s: %v", p.Id, err)
+		}
+		if r.userAgents, err = globs(p.Filter.UserAgents); err != nil {
+			return nil, fmt.Errorf("project %s: %v", p.Id, err)
+		}
+		f.projects[p.Id] = r
+	}
+
+	return f, nil
+}
+
+// Check returns the reason when the event has to be dropped
+func (f *Filter) Check(p *parser.Packet, protocol string) string {
+	r, ok := f.projects[p.Project]
+	if !ok {
+		return ""
+	}
+
+	request := p.GetRequest(protocol)
+	userAgent := header(request.Headers, "User-Agent")
+
+	if r.Localhost && isLocalhost(p, request) {
+		return ReasonLocalhost
+	}
+
+	if r.BrowserExtensions && isBrowserExtension(p, protocol) {
+		return ReasonBrowserExtension
+	}
+
+	if r.LegacyBrowsers && userAgent != "" {
+		for _, re := range legacyBrowser {
+			if re.MatchString(userAgent) {
+				return ReasonLegacyBrowser
+			}
+		}
+	}
+
+	if match(r.messages, p.Message) {
+		return ReasonMessage
+	}
+
+	if p.Release != "" && match(r.releases, p.Release) {
+		return ReasonRelease
+	}
+
+	if match(r.environments, p.Environment) {
+		return ReasonEnvironment
+	}
+
+	if userAgent != "" && match(r.userAgents, userAgent) {
+		return ReasonUserAgent
+	}
+
+	return ""
+}
+
+func isLocalhost(p *parser.Packet, request parser.Request) bool {
+	user := p.User
+	if user == nil {
+		user = p.InterfaceUser
+	}
+	if ip, ok := user["ip_address"].(string); ok {
+		for _, h := range localhosts {
+			if ip == h {
+				return true
+			}
+		}
+	}
+
+	u, err := url.Parse(request.Url)
+	if err != nil {
+		return false
+	}
+	for _, h := range localhosts {
+		if u.Hostname() == h {
+			return true
+		}
+	}
+	return false
+}
+
+func isBrowserExtension(p *parser.Packet, protocol string) bool {
+	for _, m := range extensionMessages {
+		if strings.Contains(p.Message, m) {
+			return true
+		}
+	}
+
+	var frames []parser.StackFrame
+	if protocol == "7" {
+		for _, v := range p.InterfaceException7.Values {
+			frames = append(frames, v.Stacktrace.Frames...)
+		}
+	} else {
+		frames = p.InterfaceStacktrace.Frames
+	}
+
+	for _, frame := range frames {
+		if extensionFrame.MatchString(frame.AbsPath) || extensionFrame.MatchString(frame.Filename) {
+			return true
+		}
+	}
+	return false
+}
+
+func header(headers parser.M, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			s, _ := v.(string)
+			return s
+		}
+	}
+	return ""
+}
+
+func match(list []*regexp.Regexp, s string) bool {
+	for _, re := range list {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func globs(patterns []string) ([]*regexp.Regexp, error) {
+	var list []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, re)
+	}
+	return list, nil
+}
+
+// Glob compiles a case insensitive pattern, * matches any characters and ? a
+// single one
+func Glob(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	re, err := regexp.Compile("(?is)^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s", strconv.Quote(pattern))
+	}
+	return re, nil
+}
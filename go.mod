@@ -5,9 +5,12 @@ go 1.16
 require (
 	github.com/BurntSushi/toml v1.0.0
 	github.com/alexedwards/stack v0.0.0-20160719074228-3ba431d5d12d
+	github.com/alicebob/miniredis/v2 v2.31.0
 	github.com/go-redis/redis/v8 v8.11.4
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/gorilla/sessions v1.2.1
+	github.com/lib/pq v1.9.0
 	github.com/mattn/go-sqlite3 v1.14.7
 	github.com/nbari/violetear v0.0.0-20210524103009-ce83b52538c9
 	github.com/scr34m/gosx-notifier v0.0.0-20171028061049-1e2edd800ab5
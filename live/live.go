@@ -0,0 +1,77 @@
+package live
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/scr34m/proof/queue"
+)
+
+// RetryInterval of subscribing again to the queue after a failure
+const RetryInterval = 5 * time.Second
+
+// Hub hands the notices of the processed events to the connected browsers
+type Hub struct {
+	mu      sync.Mutex
+	clients map[chan queue.Notice]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[chan queue.Notice]struct{})}
+}
+
+// Subscribe returns the notices of a browser and the function to call when
+// it is gone
+func (h *Hub) Subscribe() (<-chan queue.Notice, func()) {
+	ch := make(chan queue.Notice, 100)
+
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.clients, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Broadcast skips the browsers falling behind, they miss the notice
+func (h *Hub) Broadcast(n queue.Notice) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.clients {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+// Follow broadcasts the notices published by the workers until the context
+// is done, fn is called with each of them too
+func (h *Hub) Follow(ctx context.Context, events queue.Queue, fn func(queue.Notice)) {
+	go func() {
+		for ctx.Err() == nil {
+			notices, err := events.Subscribe(ctx)
+			if err != nil {
+				log.Printf("Live updates subscribe failed: %v", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(RetryInterval):
+				}
+				continue
+			}
+
+			for n := range notices {
+				h.Broadcast(n)
+				if fn != nil {
+					fn(n)
+				}
+			}
+		}
+	}()
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/storage"
 	gomail "gopkg.in/gomail.v2"
 )
 
@@ -33,19 +34,32 @@ func NewMailer(host string, port int, user string, password string, verify bool,
 	}
 }
 
-func (m *Mailer) Event(to []string, status *parser.ProcessStatus) {
-	msg := gomail.NewMessage()
-
-	subject := "[Proof] " + status.Site + " - " + strings.ToUpper(status.Level) + ": " + status.Message
-	if len(subject) > 80 {
-		subject = subject[:80]
-	}
-
+// Event emails the event to the recipient, unsubscribe is the url of
+// stopping these emails
+func (m *Mailer) Event(to string, unsubscribe string, status *parser.ProcessStatus) {
 	var event string
 	if status.IsNew {
 		event = "New event"
-	} else {
+	} else if status.IsRegression {
 		event = "Regression"
+	} else {
+		event = "Event"
+	}
+
+	m.send([]string{to}, "[Proof] ", event, "", unsubscribe, status)
+}
+
+// Alert emails the event the alert rule fired for with the reason
+func (m *Mailer) Alert(to []string, rule string, reason string, status *parser.ProcessStatus) {
+	m.send(to, "[Proof alert] ", "Alert "+rule, reason, "", status)
+}
+
+func (m *Mailer) send(to []string, prefix string, event string, reason string, unsubscribe string, status *parser.ProcessStatus) {
+	msg := gomail.NewMessage()
+
+	subject := prefix + status.Site + " - " + strings.ToUpper(status.Level) + ": " + status.Message
+	if len(subject) > 80 {
+		subject = subject[:80]
 	}
 
 	addresses := make([]string, len(to))
@@ -56,6 +70,11 @@ func (m *Mailer) Event(to []string, status *parser.ProcessStatus) {
 	msg.SetHeader("From", m.FromEmail)
 	msg.SetHeader("To", addresses...)
 	msg.SetHeader("Subject", subject)
+	if unsubscribe != "" {
+		// one click unsubscribe of RFC 8058
+		msg.SetHeader("List-Unsubscribe", "<"+unsubscribe+">")
+		msg.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
 
 	var body bytes.Buffer
 
@@ -85,21 +104,146 @@ func (m *Mailer) Event(to []string, status *parser.ProcessStatus) {
 
 	t, _ := template.ParseFiles("tpl/mail/regression.html")
 	t.Execute(&body, struct {
-		Event      string
-		DetailsUrl string
-		Site       string
-		Message    string
-		Stacktrace string
+		Event       string
+		DetailsUrl  string
+		Site        string
+		Message     string
+		Reason      string
+		Stacktrace  string
+		Unsubscribe string
+	}{
+		Event:       event,
+		Reason:      reason,
+		Unsubscribe: unsubscribe,
+		DetailsUrl:  fmt.Sprintf("%s/details/%d", m.SiteUrl, status.GroupId),
+		Site:        status.ServerName,
+		Message:     status.Message,
+		Stacktrace:  stacktrace,
+	})
+
+	msg.SetBody("text/html", body.String())
+
+	m.dial(msg)
+}
+
+// DigestIssue is an issue of a digest email
+type DigestIssue struct {
+	storage.DigestItem
+	Event      string
+	Summary    string
+	DetailsUrl string
+}
+
+// DigestProject is the unsubscribe link of a project in a digest email
+type DigestProject struct {
+	Id          string
+	Unsubscribe string
+}
+
+// Digest emails the collected issues to the recipient, unsubscribe holds the
+// url of stopping the emails of each project
+func (m *Mailer) Digest(to string, items []storage.DigestItem, unsubscribe map[string]string) {
+	msg := gomail.NewMessage()
+
+	subject := fmt.Sprintf("[Proof] %d issues", len(items))
+	if len(items) == 1 {
+		subject = "[Proof] 1 issue"
+	}
+
+	msg.SetHeader("From", m.FromEmail)
+	msg.SetHeader("To", msg.FormatAddress(to, ""))
+	msg.SetHeader("Subject", subject)
+	if len(unsubscribe) == 1 {
+		for _, u := range unsubscribe {
+			msg.SetHeader("List-Unsubscribe", "<"+u+">")
+			msg.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		}
+	}
+
+	var issues []DigestIssue
+	var projects []DigestProject
+	seen := make(map[string]bool)
+	for _, item := range items {
+		event := "Event"
+		if item.Kind == storage.SubscribeNew {
+			event = "New event"
+		} else if item.Kind == storage.SubscribeRegression {
+			event = "Regression"
+		}
+
+		issues = append(issues, DigestIssue{
+			DigestItem: item,
+			Event:      event,
+			Summary:    summary(item.Message, 80),
+			DetailsUrl: fmt.Sprintf("%s/details/%d", m.SiteUrl, item.GroupId),
+		})
+
+		if u, ok := unsubscribe[item.ProjectId]; ok && !seen[item.ProjectId] {
+			seen[item.ProjectId] = true
+			projects = append(projects, DigestProject{Id: item.ProjectId, Unsubscribe: u})
+		}
+	}
+
+	var body bytes.Buffer
+	t, _ := template.ParseFiles("tpl/mail/digest.html")
+	t.Execute(&body, struct {
+		Issues   []DigestIssue
+		Projects []DigestProject
 	}{
-		Event:      event,
-		DetailsUrl: fmt.Sprintf("%s/details/%d", m.SiteUrl, status.GroupId),
-		Site:       status.ServerName,
-		Message:    status.Message,
-		Stacktrace: stacktrace,
+		Issues:   issues,
+		Projects: projects,
 	})
 
 	msg.SetBody("text/html", body.String())
 
+	m.dial(msg)
+}
+
+// ReportProject is a project of a summary report with its new, regressed and
+// most frequent issues
+type ReportProject struct {
+	Id        string
+	Name      string
+	Events    int
+	Issues    int
+	New       []storage.ReportItem
+	Regressed []storage.ReportItem
+	Top       []storage.ReportItem
+}
+
+// Report emails the summary report of the projects
+func (m *Mailer) Report(to []string, title string, projects []ReportProject) {
+	msg := gomail.NewMessage()
+
+	addresses := make([]string, len(to))
+	for i, recipient := range to {
+		addresses[i] = msg.FormatAddress(recipient, "")
+	}
+
+	msg.SetHeader("From", m.FromEmail)
+	msg.SetHeader("To", addresses...)
+	msg.SetHeader("Subject", "[Proof] "+title)
+
+	var body bytes.Buffer
+	t, _ := template.New("report.html").Funcs(template.FuncMap{
+		"summary": func(message string) string { return summary(message, 80) },
+	}).ParseFiles("tpl/mail/report.html")
+	t.Execute(&body, struct {
+		Title    string
+		SiteUrl  string
+		Projects []ReportProject
+	}{
+		Title:    title,
+		SiteUrl:  m.SiteUrl,
+		Projects: projects,
+	})
+
+	msg.SetBody("text/html", body.String())
+
+	m.dial(msg)
+}
+
+func (m *Mailer) dial(msg *gomail.Message) {
 	d := gomail.NewDialer(m.Host, m.Port, m.User, m.Password)
 	d.TLSConfig = &tls.Config{InsecureSkipVerify: m.SkipVerify}
 
@@ -107,3 +251,14 @@ func (m *Mailer) Event(to []string, status *parser.ProcessStatus) {
 		panic(err)
 	}
 }
+
+// summary returns the first line of the message cut to the size
+func summary(message string, size int) string {
+	if i := strings.Index(message, "\n"); i != -1 {
+		message = message[:i]
+	}
+	if len(message) > size {
+		message = message[:size] + "..."
+	}
+	return strings.ToValidUTF8(message, "")
+}
@@ -2,30 +2,50 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
+	"fmt"
 	"log"
+	neturl "net/url"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	rdb "github.com/go-redis/redis/v8"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/sessions"
+	_ "github.com/lib/pq"
+	"github.com/scr34m/proof/blob"
+	"github.com/scr34m/proof/cleanup"
 	"github.com/scr34m/proof/cmd"
 	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/database"
+	"github.com/scr34m/proof/filter"
+	"github.com/scr34m/proof/forward"
 	m "github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/migrate"
 	"github.com/scr34m/proof/notification"
+	"github.com/scr34m/proof/notify"
+	"github.com/scr34m/proof/queue"
+	"github.com/scr34m/proof/sample"
+	"github.com/scr34m/proof/scrub"
+	"github.com/scr34m/proof/spool"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
 )
 
-var databaseType = flag.String("database-type", "sqlite", "Database type (mysql|sqlite)")
+var databaseType = flag.String("database-type", "sqlite", "Database type (mysql|postgres|sqlite)")
 var databaseHost = flag.String("database-host", "127.0.0.1", "Database host")
-var databasePort = flag.Int("database-port", 3306, "Database port")
+var databasePort = flag.Int("database-port", 3306, "Database port (postgres defaults to 5432)")
 var databaseUser = flag.String("database-user", "", "Database user")
 var databasePassword = flag.String("database-password", "", "Database password")
+var databaseSslMode = flag.String("database-sslmode", "disable", "Database SSL mode (only postgres)")
 var databaseName = flag.String("database", "proof.db", "Database name or file")
 var listen = flag.String("listen", ":2017", "Location to listen for connections")
-var notificationShow = flag.Bool("notification", true, "Local notification (only OSX)")
+var notificationShow = flag.Bool("notification", true, "Local desktop notification (macOS and Linux)")
+var notificationBackend = flag.String("notification-backend", "", "Desktop notification backend (macos|dbus|notify-send), empty picks the one of the system")
 var authMode = flag.Bool("auth", false, "Authenticated mode")
 var authDatabase = flag.String("auth-database", "proof.toml", "Authentication config")
 var mail = flag.Bool("mail", false, "Enable email notifications (only with authenticated mode)")
@@ -41,14 +61,47 @@ var redis = flag.String("redis", "localhost:6379", "Redis host and port")
 var redisPassword = flag.String("redis-password", "", "Redis password")
 var redisDb = flag.Int("redis-db", 0, "Redis database id")
 var redisKey = flag.String("redis-key", "proof_events", "Redis key used to store queued events")
+var workers = flag.Int("workers", 0, "Number of concurrent workers, defaults to 4 and is at most 1 with SQLite (only worker mode)")
+var queueType = flag.String("queue", "redis", "Queue backend of the frontend and worker modes (redis|stream|disk)")
+var queueFile = flag.String("queue-file", "queue.db", "SQLite file of the disk queue")
+var queueSpool = flag.String("queue-spool", "spool", "Directory of the events the queue could not take, empty disables it (only frontend mode)")
+var queueSpoolEvents = flag.Int("queue-spool-events", 100000, "Maximum number of spooled events, 0 is unlimited")
+var queueSpoolSize = flag.Int64("queue-spool-size", 1024, "Maximum size of the spooled events in megabytes, 0 is unlimited")
+var workerName = flag.String("worker-name", "", "Name of the worker process, defaults to the host name, every run adds a random suffix (only worker mode)")
+var batchSize = flag.Int("batch-size", 1, "Maximum number of events stored in one transaction (only worker mode)")
+var batchLatency = flag.Duration("batch-latency", 100*time.Millisecond, "Maximum wait for a batch to fill up (only worker mode)")
 var url = flag.String("url", "http://localhost:2017", "Frontend URL")
+var configFile = flag.String("config", "proof.toml", "Project settings config (forwarding, scrubbing, filters, sampling)")
+var autoMigrate = flag.Bool("auto-migrate", true, "Apply pending schema migrations on startup")
+var forwardSpool = flag.String("forward-spool", "forward", "Directory of the forwarding retry queue")
+var cleanupInterval = flag.Duration("cleanup-interval", time.Hour, "Interval of the retention cleanup, 0 disables it")
+var blobType = flag.String("blob", "", "Payload blob store (file|s3), empty keeps the payloads in the database")
+var blobDir = flag.String("blob-dir", "blobs", "Directory of the file blob store")
+var blobEndpoint = flag.String("blob-endpoint", "", "S3 compatible endpoint, ex.: http://127.0.0.1:9000")
+var blobBucket = flag.String("blob-bucket", "proof", "S3 bucket")
+var blobRegion = flag.String("blob-region", "us-east-1", "S3 region")
+var blobAccessKey = flag.String("blob-access-key", "", "S3 access key")
+var blobSecretKey = flag.String("blob-secret-key", "", "S3 secret key")
 
-var db *sql.DB
+var db *database.DB
 var notif *notification.Notification
 var auth *config.AuthConfig
 var store *sessions.CookieStore
 var mailer *m.Mailer
 var redisCli *rdb.Client
+var events queue.Queue
+var spooler *spool.Spool
+var settings *config.Config
+var forwarder *forward.Forwarder
+var scrubber *scrub.Scrubber
+var filters *filter.Filter
+var sampler *sample.Sampler
+var blobs blob.Store
+var repo storage.Storage
+var cleaner *cleanup.Cleaner
+var users storage.UserStore
+var notifier *notify.Dispatcher
+var hooks *webhook.Sender
 
 func main() {
 	log.Printf("Proof %s starting", config.VERSION)
@@ -57,7 +110,8 @@ func main() {
 
 	var err error
 
-	if *databaseType == "mysql" {
+	switch *databaseType {
+	case database.MySQL:
 		var dsn = ""
 
 		if *databaseUser != "" && *databasePassword != "" {
@@ -70,23 +124,84 @@ func main() {
 		dsn += "tcp(" + *databaseHost + ":" + strconv.Itoa(*databasePort) + ")"
 		dsn += "/" + *databaseName
 
-		db, err = sql.Open("mysql", dsn)
-		if err != nil {
-			log.Fatal(err)
+		db, err = database.Open(database.MySQL, dsn)
+	case database.Postgres:
+		port := *databasePort
+		if !flagSet("database-port") {
+			port = 5432
 		}
-	} else {
-		db, err = sql.Open("sqlite3", *databaseName)
-		if err != nil {
+
+		dsn := neturl.URL{
+			Scheme:   "postgres",
+			Host:     *databaseHost + ":" + strconv.Itoa(port),
+			Path:     "/" + *databaseName,
+			RawQuery: "sslmode=" + neturl.QueryEscape(*databaseSslMode),
+		}
+		if *databaseUser != "" && *databasePassword != "" {
+			dsn.User = neturl.UserPassword(*databaseUser, *databasePassword)
+		} else if *databaseUser != "" {
+			dsn.User = neturl.User(*databaseUser)
+		}
+
+		db, err = database.Open(database.Postgres, dsn.String())
+	default:
+		db, err = database.Open(database.SQLite, *databaseName)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	migrator, err := migrate.NewMigrator(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// proof [flags] migrate up|down|status
+	if flag.Arg(0) == "migrate" {
+		runMigrate(migrator, flag.Arg(1))
+		return
+	}
+
+	if err := migrator.Check(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *autoMigrate {
+		if err := migrator.Up(); err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	if *notificationShow {
-		// XXX for terminal-notification
-		os.Setenv("PATH", os.Getenv("PATH")+":/usr/local/bin")
-		os.Setenv("PATH", os.Getenv("PATH")+":/opt/homebrew/bin")
+	switch *blobType {
+	case "":
+	case blob.File:
+		blobs, err = blob.NewFile(*blobDir)
+	case blob.S3:
+		blobs, err = blob.NewS3(*blobEndpoint, *blobBucket, *blobRegion, *blobAccessKey, *blobSecretKey)
+	default:
+		log.Fatalf("Unknown blob store: %s", *blobType)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		notif = notification.NewNotification(*listen, 1000)
+	// proof [flags] blob migrate
+	if flag.Arg(0) == "blob" {
+		runBlob(blobs, flag.Arg(1))
+		return
+	}
+
+	if *notificationShow {
+		backend, err := notification.NewBackend(*notificationBackend)
+		if err != nil && *notificationBackend != "" {
+			log.Fatal(err)
+		}
+		if err != nil {
+			log.Printf("Desktop notifications disabled: %v", err)
+		} else {
+			log.Printf("Desktop notifications by %s", backend.Name())
+			notif = notification.NewNotification(*url, backend, 1000)
+		}
 	}
 
 	if *authMode {
@@ -95,26 +210,136 @@ func main() {
 			log.Fatal(err)
 		}
 
+		users = storage.NewConfigUsers(auth)
+
 		store = sessions.NewCookieStore([]byte(*sessionKey))
 
 		if *mail {
+			// the unsubscribe links are signed with it
+			if *sessionKey == "" {
+				log.Fatal("Email notifications need a -sessionkey")
+			}
 			mailer = m.NewMailer(*mailHost, *mailPort, *mailUser, *mailPassword, *mailVerify, *mailFrom, *url)
 		}
 	}
 
-	if *mode == "worker" || *mode == "frontend" {
-		redisCli = rdb.NewClient(&rdb.Options{
-			Addr:     *redis,
-			Password: *redisPassword,
-			DB:       *redisDb,
-		})
+	settings = &config.Config{}
+	if _, err := os.Stat(*configFile); err == nil {
+		if _, err := toml.DecodeFile(*configFile, settings); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	scrubber, err = scrub.NewScrubber(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	filters, err = filter.NewFilter(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sampler, err = sample.NewSampler(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(settings.Forward) > 0 {
+		forwarder, err = forward.NewForwarder(settings.Forward, *forwardSpool)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	repo = storage.NewSQL(db, blobs)
+	cleaner = cleanup.NewCleaner(repo, settings, *cleanupInterval)
+
+	hooks, err = webhook.NewSender(settings.Webhook, repo, *url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	notifier, err = notify.NewDispatcher(settings, *url, mailer, users, repo, hooks, *sessionKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// proof [flags] notify test <project>
+	if flag.Arg(0) == "notify" {
+		runNotify(notifier, flag.Arg(1), flag.Arg(2))
+		return
+	}
+
+	// proof [flags] cleanup [-dry-run]
+	if flag.Arg(0) == "cleanup" {
+		runCleanup(cleaner, flag.Args()[1:])
+		return
+	}
+
+	if *mode == "worker" || *mode == "frontend" || flag.Arg(0) == "queue" {
+		switch *queueType {
+		case queue.Redis, queue.Stream:
+			redisCli = rdb.NewClient(&rdb.Options{
+				Addr:     *redis,
+				Password: *redisPassword,
+				DB:       *redisDb,
+			})
+			if *queueType == queue.Stream {
+				events = queue.NewStream(redisCli, *redisKey)
+			} else {
+				events = queue.NewRedis(redisCli, *redisKey)
+			}
+		case queue.Disk:
+			events, err = queue.NewDisk(*queueFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatalf("Unknown queue: %s", *queueType)
+		}
 	}
 
 	ctx := context.Background()
 
+	// proof [flags] queue status|dead|replay|discard
+	if flag.Arg(0) == "queue" {
+		runQueue(ctx, events, flag.Arg(1), flag.Arg(2))
+		return
+	}
+
 	// Start in worker mode
 	if *mode == "worker" {
-		c := cmd.NewWorker(ctx, db, auth, mailer, redisCli, *redisKey)
+		name := *workerName
+		if name == "" {
+			name, err = os.Hostname()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		// the in-flight events of a previous run are recovered once its
+		// heartbeat expired, a run never takes over the name of another
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			log.Fatal(err)
+		}
+		name += "-" + hex.EncodeToString(suffix)
+
+		// SQLite takes one writer at a time, the others would fail with
+		// database is locked
+		n := *workers
+		if *databaseType == database.SQLite && n > 1 {
+			log.Printf("SQLite stores the events of one worker only, -workers %d is lowered to 1", n)
+			n = 1
+		} else if n <= 0 {
+			n = 4
+			if *databaseType == database.SQLite {
+				n = 1
+			}
+		}
+
+		c := cmd.NewWorker(ctx, repo, notifier, hooks, forwarder, cleaner, scrubber, filters, sampler, events, name, n, *batchSize, *batchLatency)
 		c.Start()
 		return
 	}
@@ -127,6 +352,148 @@ func main() {
 		queue = false
 	}
 
-	c := cmd.NewFrontend(ctx, db, notif, auth, store, mailer, redisCli, *redisKey, queue)
+	if queue && *queueSpool != "" {
+		spooler, err = spool.NewSpool(events, *queueSpool, *queueSpoolEvents, *queueSpoolSize*1024*1024)
+		if err != nil {
+			log.Fatal(err)
+		}
+		events = spooler
+	}
+
+	c := cmd.NewFrontend(ctx, repo, users, notif, auth, settings, store, notifier, hooks, forwarder, cleaner, scrubber, filters, sampler, events, spooler, queue)
 	c.Start(*listen)
 }
+
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func runMigrate(migrator *migrate.Migrator, command string) {
+	var err error
+	switch command {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		err = migrator.Down()
+	case "status":
+		var list []migrate.Status
+		list, err = migrator.Status()
+		for _, s := range list {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt
+			}
+			fmt.Printf("%04d %-24s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		log.Fatal("Usage: proof [flags] migrate up|down|status")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runBlob(blobs blob.Store, command string) {
+	if command != "migrate" {
+		log.Fatal("Usage: proof [flags] -blob file|s3 blob migrate")
+	}
+	if blobs == nil {
+		log.Fatal("Blob store is not configured, use -blob file|s3")
+	}
+
+	moved, err := storage.MovePayloads(db, blobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d payloads moved", moved)
+}
+
+func runNotify(notifier *notify.Dispatcher, command string, projectId string) {
+	if command != "test" || projectId == "" {
+		log.Fatal("Usage: proof [flags] notify test <project>")
+	}
+	if err := notifier.Test(projectId); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runCleanup(cleaner *cleanup.Cleaner, args []string) {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Only report what would be deleted")
+	fs.Parse(args)
+
+	reports, err := cleaner.Run(*dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	verb := "deleted"
+	if *dryRun {
+		verb = "would be deleted"
+	}
+	for _, r := range reports {
+		if r.Project == "" {
+			fmt.Printf("orphans: %d payloads, %d groups, %d bytes %s\n", r.Payloads, r.Groups, r.Bytes, verb)
+			continue
+		}
+		fmt.Printf("project %s: %d events, %d payloads, %d groups, %d bytes %s\n", r.Project, r.Events, r.Payloads, r.Groups, r.Bytes, verb)
+	}
+	if len(reports) == 0 {
+		fmt.Println("nothing to delete")
+	}
+}
+
+func runQueue(ctx context.Context, events queue.Queue, command string, id string) {
+	switch command {
+	case "status":
+		waiting, retrying, dead, err := events.Depth(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("waiting %d, retrying %d, dead %d\n", waiting, retrying, dead)
+	case "dead":
+		letters, err := events.Dead(ctx, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, d := range letters {
+			fmt.Printf("%s %s project %s, %d attempts: %s\n", d.Id, d.Failed.Format("2006-01-02 15:04:05"), d.Packet.ProjectId, d.Packet.Attempts, d.Error)
+		}
+	case "replay", "discard":
+		if id == "" {
+			log.Fatalf("Usage: proof [flags] queue %s <id>|all", command)
+		}
+
+		action, verb := events.Replay, "replayed"
+		if command == "discard" {
+			action, verb = events.Discard, "discarded"
+		}
+
+		ids := []string{id}
+		if id == "all" {
+			letters, err := events.Dead(ctx, 0)
+			if err != nil {
+				log.Fatal(err)
+			}
+			ids = nil
+			for _, d := range letters {
+				ids = append(ids, d.Id)
+			}
+		}
+
+		for _, id := range ids {
+			if err := action(ctx, id); err != nil {
+				log.Fatalf("%s: %v", id, err)
+			}
+		}
+		fmt.Printf("%d dead letters %s\n", len(ids), verb)
+	default:
+		log.Fatal("Usage: proof [flags] queue status|dead|replay <id>|all|discard <id>|all")
+	}
+}
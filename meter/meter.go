@@ -0,0 +1,143 @@
+package meter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scr34m/proof/queue"
+	"github.com/scr34m/proof/shared"
+)
+
+const (
+	// Window of the rates in seconds
+	Window = 60
+	// FailureLimit is the number of failures kept
+	FailureLimit = 10
+	// PreviewSize is the length of the packet preview of a failure
+	PreviewSize = 512
+)
+
+// Meter counts the events received and processed by the process and keeps
+// the last processing failures
+type Meter struct {
+	mu       sync.Mutex
+	started  time.Time
+	in       rate
+	out      rate
+	failures []queue.Failure
+}
+
+// rate counts the events of the last Window seconds, one bucket a second
+type rate struct {
+	counts  [Window]int64
+	seconds [Window]int64
+}
+
+func NewMeter() *Meter {
+	return &Meter{started: time.Now()}
+}
+
+func (r *rate) add(now time.Time) {
+	s := now.Unix()
+	i := s % Window
+	if r.seconds[i] != s {
+		r.seconds[i] = s
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+}
+
+func (r *rate) perSecond(now time.Time, started time.Time) float64 {
+	s := now.Unix()
+	var n int64
+	for i := range r.counts {
+		if s-r.seconds[i] < Window {
+			n += r.counts[i]
+		}
+	}
+
+	// a process running for less than the window
+	window := now.Sub(started).Seconds()
+	if window > Window {
+		window = Window
+	}
+	if window < 1 {
+		window = 1
+	}
+	return math.Round(float64(n)/window*100) / 100
+}
+
+// In counts a received event
+func (m *Meter) In() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.in.add(time.Now())
+}
+
+// Out counts a processed event
+func (m *Meter) Out() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.out.add(time.Now())
+}
+
+// Fail keeps the error of the packet, the oldest one is dropped over
+// FailureLimit
+func (m *Meter) Fail(err error, packet shared.QueuePacket) {
+	f := queue.Failure{
+		Time:      time.Now(),
+		Error:     err.Error(),
+		ProjectId: packet.ProjectId,
+		Protocol:  packet.Protocol,
+		Preview:   preview(packet.Body),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures = append([]queue.Failure{f}, m.failures...)
+	if len(m.failures) > FailureLimit {
+		m.failures = m.failures[:FailureLimit]
+	}
+}
+
+// Rates returns the received and processed events per second in the last
+// minute
+func (m *Meter) Rates() (float64, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	return m.in.perSecond(now, m.started), m.out.perSecond(now, m.started)
+}
+
+// Failures returns the last failures, newest first
+func (m *Meter) Failures() []queue.Failure {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]queue.Failure(nil), m.failures...)
+}
+
+// preview returns the start of the body, a compressed one is not shown
+func preview(body []byte) string {
+	short := body
+	if len(short) > PreviewSize {
+		short = short[:PreviewSize]
+	}
+
+	// a multibyte character may be cut at the end
+	s := strings.ToValidUTF8(string(short), "")
+	if len(s) < len(short)-3 {
+		return fmt.Sprintf("%d bytes of binary data", len(body))
+	}
+	if len(body) > PreviewSize {
+		s += "..."
+	}
+	return s
+}
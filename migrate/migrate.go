@@ -0,0 +1,300 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scr34m/proof/database"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+var ErrNewerSchema = errors.New("database schema is newer than this binary, upgrade Proof")
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt string
+}
+
+type Migrator struct {
+	db         *database.DB
+	dialect    string
+	migrations []migration
+}
+
+func NewMigrator(db *database.DB) (*Migrator, error) {
+	dialect := db.Dialect
+	m := &Migrator{db: db, dialect: dialect}
+
+	entries, err := fs.ReadDir(files, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for database type %s", dialect)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		// ex.: 0002_outcome.up.sql
+		name := entry.Name()
+		p := strings.Index(name, "_")
+		if p == -1 {
+			continue
+		}
+		version, err := strconv.Atoi(name[:p])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+
+		b, err := files.ReadFile(dialect + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+
+		mi, ok := byVersion[version]
+		if !ok {
+			mi = &migration{Version: version}
+			byVersion[version] = mi
+		}
+		if strings.HasSuffix(name, ".up.sql") {
+			mi.Name = strings.TrimSuffix(name[p+1:], ".up.sql")
+			mi.Up = string(b)
+		} else if strings.HasSuffix(name, ".down.sql") {
+			mi.Down = string(b)
+		}
+	}
+
+	for _, mi := range byVersion {
+		m.migrations = append(m.migrations, *mi)
+	}
+	sort.Slice(m.migrations, func(i, j int) bool { return m.migrations[i].Version < m.migrations[j].Version })
+
+	return m, nil
+}
+
+// Latest returns the newest schema version known by the binary
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the current schema version of the database
+func (m *Migrator) Version() (int, error) {
+	err := m.init()
+	if err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err = m.db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// Check refuses a database migrated by a newer binary
+func (m *Migrator) Check() error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return ErrNewerSchema
+	}
+	return nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up() error {
+	return m.locked(func(conn *sql.Conn) error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+		for _, mi := range m.migrations {
+			if _, ok := applied[mi.Version]; ok {
+				continue
+			}
+			log.Printf("Migrating up to %04d %s", mi.Version, mi.Name)
+			err = m.exec(conn, mi.Up, "INSERT INTO schema_version (version, applied_at) VALUES (?, ?)", mi.Version, time.Now().Format("2006-01-02 15:04:05"))
+			if err != nil {
+				return fmt.Errorf("migration %04d %s: %v", mi.Version, mi.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the last applied migration
+func (m *Migrator) Down() error {
+	return m.locked(func(conn *sql.Conn) error {
+		version, err := m.Version()
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			return nil
+		}
+
+		for _, mi := range m.migrations {
+			if mi.Version != version {
+				continue
+			}
+			if mi.Down == "" {
+				return fmt.Errorf("migration %04d %s can not be reverted", mi.Version, mi.Name)
+			}
+			log.Printf("Migrating down from %04d %s", mi.Version, mi.Name)
+			err = m.exec(conn, mi.Down, "DELETE FROM schema_version WHERE version = ?", mi.Version)
+			if err != nil {
+				return fmt.Errorf("migration %04d %s: %v", mi.Version, mi.Name, err)
+			}
+			return nil
+		}
+		return ErrNewerSchema
+	})
+}
+
+// Status lists the known migrations and when they were applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Status
+	for _, mi := range m.migrations {
+		s := Status{Version: mi.Version, Name: mi.Name}
+		s.AppliedAt, s.Applied = applied[mi.Version]
+		delete(applied, mi.Version)
+		list = append(list, s)
+	}
+	for version, at := range applied {
+		list = append(list, Status{Version: version, Name: "unknown", Applied: true, AppliedAt: at})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+func (m *Migrator) init() error {
+	_, err := m.db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INT NOT NULL PRIMARY KEY, applied_at VARCHAR(19) NOT NULL)")
+	return err
+}
+
+func (m *Migrator) applied() (map[int]string, error) {
+	err := m.init()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var at string
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// exec runs the statements of the script and records the version in one
+// transaction, MySQL commits DDL statements implicitly though
+func (m *Migrator) exec(conn *sql.Conn, script string, record string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range statements(script) {
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, m.db.Rebind(record), args...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// locked runs fn on a single connection, with MySQL and Postgres the
+// migrations of concurrently starting processes are serialized with a lock
+func (m *Migrator) locked(fn func(conn *sql.Conn) error) error {
+	err := m.init()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch m.dialect {
+	case database.MySQL:
+		var ok sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK('proof_migrate', 60)").Scan(&ok)
+		if err != nil {
+			return err
+		}
+		if ok.Int64 != 1 {
+			return errors.New("migration lock timeout")
+		}
+		defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK('proof_migrate')")
+	case database.Postgres:
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock(20170001)")
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock(20170001)")
+	}
+
+	return fn(conn)
+}
+
+// statements splits the script on semicolons at the end of lines
+func statements(script string) []string {
+	var list []string
+	for _, s := range strings.Split(script, ";\n") {
+		s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), ";"))
+		if s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
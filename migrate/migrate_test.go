@@ -0,0 +1,135 @@
+package migrate
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/scr34m/proof/database"
+)
+
+// PostgresEnv is the DSN of a throwaway Postgres database for the tests, its
+// schema is dropped
+const PostgresEnv = "PROOF_TEST_POSTGRES"
+
+func TestStatements(t *testing.T) {
+	script := "CREATE TABLE a (id INT);\n\nCREATE INDEX a_id ON a (id);\nINSERT INTO a VALUES (1);"
+	want := []string{"CREATE TABLE a (id INT)", "CREATE INDEX a_id ON a (id)", "INSERT INTO a VALUES (1)"}
+	if got := statements(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("statements: %q", got)
+	}
+}
+
+// TestDialects checks that every database has the same migrations
+func TestDialects(t *testing.T) {
+	names := make(map[string][]string)
+	for _, dialect := range []string{database.MySQL, database.Postgres, database.SQLite} {
+		entries, err := fs.ReadDir(files, dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			names[dialect] = append(names[dialect], entry.Name())
+		}
+		sort.Strings(names[dialect])
+	}
+
+	if !reflect.DeepEqual(names[database.MySQL], names[database.SQLite]) || !reflect.DeepEqual(names[database.Postgres], names[database.SQLite]) {
+		t.Fatalf("the migrations differ: %v", names)
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "proof.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		testUpDown(t, db)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(PostgresEnv)
+		if dsn == "" {
+			t.Skipf("%s is not set", PostgresEnv)
+		}
+		db, err := database.Open(database.Postgres, dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		m, err := NewMigrator(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		down(t, m)
+		testUpDown(t, db)
+	})
+}
+
+// down reverts every applied migration
+func down(t *testing.T, m *Migrator) {
+	for {
+		version, err := m.Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version == 0 {
+			return
+		}
+		if err := m.Down(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testUpDown applies the migrations, reverts them one by one and applies
+// them again
+func testUpDown(t *testing.T, db *database.DB) {
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	version, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != m.Latest() {
+		t.Fatalf("version %d after up instead of %d", version, m.Latest())
+	}
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range list {
+		if !s.Applied {
+			t.Fatalf("migration %04d %s is pending", s.Version, s.Name)
+		}
+	}
+
+	// the queries of the application work on the schema
+	if _, err := db.Insert("INSERT INTO `group` (logger, `level`, message, checksum, seen, sampled, last_seen, first_seen, project_id, `server_name`, url, site, platform, status) VALUES ('go', 'error', 'm', 'c', 1, 0, '2026-01-01 00:00:00', '2026-01-01 00:00:00', '1', '', '', '', 'go', 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	down(t, m)
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE `data`;
+DROP TABLE `group`;
+DROP TABLE `event`;
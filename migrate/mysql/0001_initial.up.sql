@@ -1,4 +1,4 @@
-CREATE TABLE `event` (
+CREATE TABLE IF NOT EXISTS `event` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `data_id` varchar(32) NOT NULL,
   `group_id` int(11) DEFAULT NULL,
@@ -10,7 +10,7 @@ CREATE TABLE `event` (
   KEY `idx_3` (`data_id`(16))
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
-CREATE TABLE `group` (
+CREATE TABLE IF NOT EXISTS `group` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `logger` varchar(64) NOT NULL,
   `level` varchar(32) NOT NULL,
@@ -30,7 +30,7 @@ CREATE TABLE `group` (
   KEY `idx_2` (`status`,`last_seen`) USING BTREE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
-CREATE TABLE `data` (
+CREATE TABLE IF NOT EXISTS `data` (
   `id` varchar(32) NOT NULL,
   `data` longtext NOT NULL,
   `timestamp` datetime NOT NULL,
@@ -38,4 +38,3 @@ CREATE TABLE `data` (
   PRIMARY KEY (`id`),
   KEY `id` (`id`(16))
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
-
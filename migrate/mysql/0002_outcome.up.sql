@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `outcome` (
+  `project_id` int(11) NOT NULL,
+  `reason` varchar(64) NOT NULL,
+  `day` date NOT NULL,
+  `quantity` int(10) unsigned NOT NULL,
+  PRIMARY KEY (`project_id`,`reason`,`day`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
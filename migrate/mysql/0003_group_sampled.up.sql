@@ -0,0 +1 @@
+ALTER TABLE `group` ADD COLUMN `sampled` int(10) unsigned NOT NULL DEFAULT 0 AFTER `seen`;
@@ -0,0 +1 @@
+ALTER TABLE `data` DROP COLUMN `blob_key`, DROP COLUMN `size`, DROP COLUMN `codec`;
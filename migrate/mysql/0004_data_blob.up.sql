@@ -0,0 +1 @@
+ALTER TABLE `data` ADD COLUMN `blob_key` varchar(64) NOT NULL DEFAULT '' AFTER `data`, ADD COLUMN `size` int(11) NOT NULL DEFAULT 0 AFTER `blob_key`, ADD COLUMN `codec` varchar(16) NOT NULL DEFAULT '' AFTER `size`;
@@ -0,0 +1 @@
+ALTER TABLE `group` DROP KEY `uniq_project_checksum`, ADD KEY `idx_1` (`checksum`, `project_id`);
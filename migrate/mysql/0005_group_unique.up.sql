@@ -0,0 +1,4 @@
+UPDATE `event` e JOIN `group` g ON g.id = e.group_id JOIN (SELECT project_id, checksum, MIN(id) AS id FROM `group` GROUP BY project_id, checksum HAVING COUNT(*) > 1) k ON k.project_id = g.project_id AND k.checksum = g.checksum SET e.group_id = k.id;
+UPDATE `group` g JOIN (SELECT MIN(id) AS id, SUM(seen) AS seen, SUM(sampled) AS sampled, MAX(last_seen) AS last_seen, MIN(first_seen) AS first_seen FROM `group` GROUP BY project_id, checksum HAVING COUNT(*) > 1) d ON g.id = d.id SET g.seen = d.seen, g.sampled = d.sampled, g.last_seen = d.last_seen, g.first_seen = d.first_seen;
+DELETE g FROM `group` g JOIN (SELECT project_id, checksum, MIN(id) AS id FROM `group` GROUP BY project_id, checksum) k ON k.project_id = g.project_id AND k.checksum = g.checksum AND g.id > k.id;
+ALTER TABLE `group` DROP KEY `idx_1`, ADD UNIQUE KEY `uniq_project_checksum` (`project_id`, `checksum`);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS `delivery` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `webhook` varchar(32) NOT NULL,
+  `url` varchar(255) NOT NULL,
+  `action` varchar(32) NOT NULL,
+  `group_id` int(11) NOT NULL,
+  `payload` longtext NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `attempts` int(10) unsigned NOT NULL,
+  `code` int(10) unsigned NOT NULL,
+  `error` text NOT NULL,
+  `created` bigint NOT NULL,
+  `next_at` bigint NOT NULL,
+  `finished` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_1` (`status`,`next_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
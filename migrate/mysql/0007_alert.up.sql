@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS `alert_count` (
+  `group_id` int(11) NOT NULL,
+  `minute` bigint NOT NULL,
+  `quantity` int(10) unsigned NOT NULL,
+  PRIMARY KEY (`group_id`,`minute`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `alert_user` (
+  `group_id` int(11) NOT NULL,
+  `user_hash` varchar(32) NOT NULL,
+  `last_seen` bigint NOT NULL,
+  PRIMARY KEY (`group_id`,`user_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `alert_release` (
+  `group_id` int(11) NOT NULL,
+  `first_release` varchar(255) NOT NULL,
+  PRIMARY KEY (`group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `alert_fired` (
+  `rule_id` varchar(255) NOT NULL,
+  `group_id` int(11) NOT NULL,
+  `fired` bigint NOT NULL,
+  PRIMARY KEY (`rule_id`,`group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `alert_log` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `rule_id` varchar(255) NOT NULL,
+  `project_id` int(11) NOT NULL,
+  `group_id` int(11) NOT NULL,
+  `fired` tinyint NOT NULL,
+  `reason` text NOT NULL,
+  `created` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_1` (`created`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `subscription` (
+  `email` varchar(255) NOT NULL,
+  `project_id` int(11) NOT NULL,
+  `group_id` int(11) NOT NULL,
+  `mode` varchar(16) NOT NULL,
+  PRIMARY KEY (`email`,`project_id`,`group_id`),
+  KEY `idx_1` (`project_id`,`group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,3 @@
+DROP TABLE `report_sent`;
+DROP TABLE `report_day`;
+DROP TABLE `digest`;
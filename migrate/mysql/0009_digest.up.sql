@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS `digest` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `email` varchar(255) NOT NULL,
+  `project_id` int(11) NOT NULL,
+  `group_id` int(11) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `message` text NOT NULL,
+  `level` varchar(255) NOT NULL,
+  `stacktrace` text NOT NULL,
+  `quantity` int(10) unsigned NOT NULL,
+  `created` bigint NOT NULL,
+  `last_seen` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uniq_email_group` (`email`,`group_id`),
+  KEY `idx_1` (`created`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `report_day` (
+  `group_id` int(11) NOT NULL,
+  `day` char(10) NOT NULL,
+  `project_id` int(11) NOT NULL,
+  `quantity` int(10) unsigned NOT NULL,
+  `new_count` int(10) unsigned NOT NULL,
+  `regressed_count` int(10) unsigned NOT NULL,
+  PRIMARY KEY (`group_id`,`day`),
+  KEY `idx_1` (`day`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `report_sent` (
+  `kind` varchar(16) NOT NULL,
+  `day` char(10) NOT NULL,
+  PRIMARY KEY (`kind`,`day`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1 @@
+ALTER TABLE `group` ADD COLUMN `assignee` varchar(255) NOT NULL DEFAULT '' AFTER `status`;
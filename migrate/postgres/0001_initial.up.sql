@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS "event" (
+  id SERIAL PRIMARY KEY,
+  data_id VARCHAR(32) NOT NULL,
+  group_id INTEGER DEFAULT NULL,
+  message TEXT NOT NULL,
+  checksum VARCHAR(32) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS event_idx_1 ON "event" (group_id);
+
+CREATE INDEX IF NOT EXISTS event_idx_3 ON "event" (data_id);
+
+CREATE TABLE IF NOT EXISTS "group" (
+  id SERIAL PRIMARY KEY,
+  logger VARCHAR(64) NOT NULL,
+  level VARCHAR(32) NOT NULL,
+  message TEXT NOT NULL,
+  checksum VARCHAR(32) NOT NULL,
+  status INTEGER NOT NULL,
+  seen INTEGER NOT NULL,
+  last_seen TIMESTAMP NOT NULL,
+  first_seen TIMESTAMP NOT NULL,
+  project_id INTEGER DEFAULT NULL,
+  server_name VARCHAR(128) DEFAULT NULL,
+  platform VARCHAR(64) DEFAULT NULL,
+  url VARCHAR(200) DEFAULT NULL,
+  site VARCHAR(128) DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS group_idx_1 ON "group" (checksum, project_id);
+
+CREATE INDEX IF NOT EXISTS group_idx_2 ON "group" (status, last_seen);
+
+CREATE TABLE IF NOT EXISTS "data" (
+  id VARCHAR(32) NOT NULL PRIMARY KEY,
+  data TEXT NOT NULL,
+  timestamp TIMESTAMP NOT NULL,
+  protocol SMALLINT NOT NULL DEFAULT 4
+);
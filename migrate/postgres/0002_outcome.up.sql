@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "outcome" (
+  project_id INTEGER NOT NULL,
+  reason VARCHAR(64) NOT NULL,
+  day DATE NOT NULL,
+  quantity INTEGER NOT NULL,
+  PRIMARY KEY (project_id, reason, day)
+);
@@ -0,0 +1 @@
+ALTER TABLE "group" ADD COLUMN sampled INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE "data" DROP COLUMN codec;
+ALTER TABLE "data" DROP COLUMN size;
+ALTER TABLE "data" DROP COLUMN blob_key;
@@ -0,0 +1,3 @@
+ALTER TABLE "data" ADD COLUMN blob_key VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE "data" ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "data" ADD COLUMN codec VARCHAR(16) NOT NULL DEFAULT '';
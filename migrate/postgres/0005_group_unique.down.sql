@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS group_project_checksum;
+CREATE INDEX IF NOT EXISTS group_idx_1 ON "group" (checksum, project_id);
@@ -0,0 +1,5 @@
+UPDATE "event" e SET group_id = k.id FROM "group" g, (SELECT project_id, checksum, MIN(id) AS id FROM "group" GROUP BY project_id, checksum HAVING COUNT(*) > 1) k WHERE g.id = e.group_id AND g.project_id = k.project_id AND g.checksum = k.checksum AND e.group_id <> k.id;
+UPDATE "group" g SET seen = d.seen, sampled = d.sampled, last_seen = d.last_seen, first_seen = d.first_seen FROM (SELECT MIN(id) AS id, SUM(seen) AS seen, SUM(sampled) AS sampled, MAX(last_seen) AS last_seen, MIN(first_seen) AS first_seen FROM "group" GROUP BY project_id, checksum HAVING COUNT(*) > 1) d WHERE g.id = d.id;
+DELETE FROM "group" g USING "group" k WHERE g.project_id = k.project_id AND g.checksum = k.checksum AND g.id > k.id;
+DROP INDEX IF EXISTS group_idx_1;
+CREATE UNIQUE INDEX IF NOT EXISTS group_project_checksum ON "group" (project_id, checksum);
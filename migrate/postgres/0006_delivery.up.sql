@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS "delivery" (
+  id SERIAL PRIMARY KEY,
+  webhook VARCHAR(32) NOT NULL,
+  url VARCHAR(255) NOT NULL,
+  action VARCHAR(32) NOT NULL,
+  group_id INTEGER NOT NULL,
+  payload TEXT NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  attempts INTEGER NOT NULL,
+  code INTEGER NOT NULL,
+  error TEXT NOT NULL,
+  created BIGINT NOT NULL,
+  next_at BIGINT NOT NULL,
+  finished BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS delivery_idx_1 ON "delivery" (status, next_at);
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS "alert_count" (
+  group_id INTEGER NOT NULL,
+  minute BIGINT NOT NULL,
+  quantity INTEGER NOT NULL,
+  PRIMARY KEY (group_id, minute)
+);
+
+CREATE TABLE IF NOT EXISTS "alert_user" (
+  group_id INTEGER NOT NULL,
+  user_hash VARCHAR(32) NOT NULL,
+  last_seen BIGINT NOT NULL,
+  PRIMARY KEY (group_id, user_hash)
+);
+
+CREATE TABLE IF NOT EXISTS "alert_release" (
+  group_id INTEGER NOT NULL PRIMARY KEY,
+  first_release VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "alert_fired" (
+  rule_id VARCHAR(255) NOT NULL,
+  group_id INTEGER NOT NULL,
+  fired BIGINT NOT NULL,
+  PRIMARY KEY (rule_id, group_id)
+);
+
+CREATE TABLE IF NOT EXISTS "alert_log" (
+  id SERIAL PRIMARY KEY,
+  rule_id VARCHAR(255) NOT NULL,
+  project_id INTEGER NOT NULL,
+  group_id INTEGER NOT NULL,
+  fired SMALLINT NOT NULL,
+  reason TEXT NOT NULL,
+  created BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS alert_log_idx_1 ON "alert_log" (created);
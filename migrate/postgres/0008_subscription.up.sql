@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "subscription" (
+  email VARCHAR(255) NOT NULL,
+  project_id INTEGER NOT NULL,
+  group_id INTEGER NOT NULL,
+  mode VARCHAR(16) NOT NULL,
+  PRIMARY KEY (email, project_id, group_id)
+);
+
+CREATE INDEX IF NOT EXISTS subscription_idx_1 ON "subscription" (project_id, group_id);
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS "digest" (
+  id SERIAL PRIMARY KEY,
+  email VARCHAR(255) NOT NULL,
+  project_id INTEGER NOT NULL,
+  group_id INTEGER NOT NULL,
+  kind VARCHAR(16) NOT NULL,
+  message TEXT NOT NULL,
+  level VARCHAR(255) NOT NULL,
+  stacktrace TEXT NOT NULL,
+  quantity INTEGER NOT NULL,
+  created BIGINT NOT NULL,
+  last_seen BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS digest_uniq_email_group ON "digest" (email, group_id);
+CREATE INDEX IF NOT EXISTS digest_idx_1 ON "digest" (created);
+
+CREATE TABLE IF NOT EXISTS "report_day" (
+  group_id INTEGER NOT NULL,
+  day CHAR(10) NOT NULL,
+  project_id INTEGER NOT NULL,
+  quantity INTEGER NOT NULL,
+  new_count INTEGER NOT NULL,
+  regressed_count INTEGER NOT NULL,
+  PRIMARY KEY (group_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS report_day_idx_1 ON "report_day" (day);
+
+CREATE TABLE IF NOT EXISTS "report_sent" (
+  kind VARCHAR(16) NOT NULL,
+  day CHAR(10) NOT NULL,
+  PRIMARY KEY (kind, day)
+);
@@ -0,0 +1 @@
+ALTER TABLE "group" ADD COLUMN assignee VARCHAR(255) NOT NULL DEFAULT '';
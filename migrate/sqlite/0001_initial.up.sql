@@ -1,8 +1,4 @@
-DROP TABLE `event`;
-DROP TABLE `group`;
-DROP TABLE `data`;
-
-CREATE TABLE `event` (
+CREATE TABLE IF NOT EXISTS `event` (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   data_id CHAR(32) NOT NULL,
   group_id INT NOT NULL,
@@ -10,7 +6,7 @@ CREATE TABLE `event` (
   checksum CHAR(32) NOT NULL
 );
 
-CREATE TABLE `group` (
+CREATE TABLE IF NOT EXISTS `group` (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   logger CHAR(64) NOT NULL,
   level CHAR(32) NOT NULL,
@@ -27,10 +23,9 @@ CREATE TABLE `group` (
   site CHAR(128) NOT NULL
 );
 
-CREATE TABLE `data` (
+CREATE TABLE IF NOT EXISTS `data` (
   id CHAR(32) NOT NULL,
   data TEXT NOT NULL,
   timestamp TEXT NOT NULL,
-  protocol INT NOT NULL,
+  protocol INT NOT NULL
 );
-
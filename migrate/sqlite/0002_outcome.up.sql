@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `outcome` (
+  project_id INT NOT NULL,
+  reason CHAR(64) NOT NULL,
+  day TEXT NOT NULL,
+  quantity INT NOT NULL,
+  PRIMARY KEY (project_id, reason, day)
+);
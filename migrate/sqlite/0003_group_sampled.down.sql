@@ -0,0 +1 @@
+ALTER TABLE `group` DROP COLUMN sampled;
@@ -0,0 +1 @@
+ALTER TABLE `group` ADD COLUMN sampled INT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE `data` ADD COLUMN blob_key CHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE `data` ADD COLUMN size INT NOT NULL DEFAULT 0;
+ALTER TABLE `data` ADD COLUMN codec CHAR(16) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS data_id;
+DROP INDEX IF EXISTS group_project_checksum;
@@ -0,0 +1,6 @@
+UPDATE `event` SET group_id = (SELECT MIN(g2.id) FROM `group` g1 JOIN `group` g2 ON g2.project_id = g1.project_id AND g2.checksum = g1.checksum WHERE g1.id = `event`.group_id) WHERE group_id IN (SELECT id FROM `group`);
+UPDATE `group` SET seen = d.seen, sampled = d.sampled, last_seen = d.last_seen, first_seen = d.first_seen FROM (SELECT MIN(id) AS id, SUM(seen) AS seen, SUM(sampled) AS sampled, MAX(last_seen) AS last_seen, MIN(first_seen) AS first_seen FROM `group` GROUP BY project_id, checksum HAVING COUNT(*) > 1) AS d WHERE `group`.id = d.id;
+DELETE FROM `group` WHERE id NOT IN (SELECT MIN(id) FROM `group` GROUP BY project_id, checksum);
+CREATE UNIQUE INDEX IF NOT EXISTS group_project_checksum ON `group` (project_id, checksum);
+DELETE FROM `data` WHERE rowid NOT IN (SELECT MIN(rowid) FROM `data` GROUP BY id);
+CREATE UNIQUE INDEX IF NOT EXISTS data_id ON `data` (id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS `delivery` (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  webhook CHAR(32) NOT NULL,
+  url TEXT NOT NULL,
+  action CHAR(32) NOT NULL,
+  group_id INT NOT NULL,
+  payload TEXT NOT NULL,
+  status CHAR(16) NOT NULL,
+  attempts INT NOT NULL,
+  code INT NOT NULL,
+  error TEXT NOT NULL,
+  created INT NOT NULL,
+  next_at INT NOT NULL,
+  finished INT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS delivery_idx_1 ON `delivery` (status, next_at);
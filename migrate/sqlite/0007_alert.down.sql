@@ -0,0 +1,5 @@
+DROP TABLE `alert_log`;
+DROP TABLE `alert_fired`;
+DROP TABLE `alert_release`;
+DROP TABLE `alert_user`;
+DROP TABLE `alert_count`;
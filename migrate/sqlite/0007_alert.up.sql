@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS `alert_count` (
+  group_id INT NOT NULL,
+  minute INT NOT NULL,
+  quantity INT NOT NULL,
+  PRIMARY KEY (group_id, minute)
+);
+
+CREATE TABLE IF NOT EXISTS `alert_user` (
+  group_id INT NOT NULL,
+  user_hash CHAR(32) NOT NULL,
+  last_seen INT NOT NULL,
+  PRIMARY KEY (group_id, user_hash)
+);
+
+CREATE TABLE IF NOT EXISTS `alert_release` (
+  group_id INT NOT NULL PRIMARY KEY,
+  first_release TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS `alert_fired` (
+  rule_id TEXT NOT NULL,
+  group_id INT NOT NULL,
+  fired INT NOT NULL,
+  PRIMARY KEY (rule_id, group_id)
+);
+
+CREATE TABLE IF NOT EXISTS `alert_log` (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  rule_id TEXT NOT NULL,
+  project_id INT NOT NULL,
+  group_id INT NOT NULL,
+  fired INT NOT NULL,
+  reason TEXT NOT NULL,
+  created INT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS alert_log_idx_1 ON `alert_log` (created);
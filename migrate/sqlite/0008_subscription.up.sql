@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `subscription` (
+  email TEXT NOT NULL,
+  project_id INT NOT NULL,
+  group_id INT NOT NULL,
+  mode TEXT NOT NULL,
+  PRIMARY KEY (email, project_id, group_id)
+);
+
+CREATE INDEX IF NOT EXISTS subscription_idx_1 ON `subscription` (project_id, group_id);
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS `digest` (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  email TEXT NOT NULL,
+  project_id INT NOT NULL,
+  group_id INT NOT NULL,
+  kind TEXT NOT NULL,
+  message TEXT NOT NULL,
+  level TEXT NOT NULL,
+  stacktrace TEXT NOT NULL,
+  quantity INT NOT NULL,
+  created INT NOT NULL,
+  last_seen INT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS digest_uniq_email_group ON `digest` (email, group_id);
+CREATE INDEX IF NOT EXISTS digest_idx_1 ON `digest` (created);
+
+CREATE TABLE IF NOT EXISTS `report_day` (
+  group_id INT NOT NULL,
+  day CHAR(10) NOT NULL,
+  project_id INT NOT NULL,
+  quantity INT NOT NULL,
+  new_count INT NOT NULL,
+  regressed_count INT NOT NULL,
+  PRIMARY KEY (group_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS report_day_idx_1 ON `report_day` (day);
+
+CREATE TABLE IF NOT EXISTS `report_sent` (
+  kind TEXT NOT NULL,
+  day CHAR(10) NOT NULL,
+  PRIMARY KEY (kind, day)
+);
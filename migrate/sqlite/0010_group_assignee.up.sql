@@ -0,0 +1 @@
+ALTER TABLE `group` ADD COLUMN assignee TEXT NOT NULL DEFAULT '';
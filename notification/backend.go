@@ -0,0 +1,61 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+const (
+	MacOS      = "macos"
+	DBus       = "dbus"
+	NotifySend = "notify-send"
+)
+
+// AppIcon is the icon of the notifications, relative to the working directory
+const AppIcon = "assets/appicon.png"
+
+var ErrUnsupported = errors.New("no desktop notification backend found")
+
+// NewBackend returns the backend by name, or the one of the system when name
+// is empty: terminal-notifier on macOS, the freedesktop notification service
+// on D-Bus elsewhere, with notify-send when D-Bus is not reachable
+func NewBackend(name string) (Backend, error) {
+	switch name {
+	case MacOS:
+		return newMacOS(), nil
+	case DBus:
+		return newDBus()
+	case NotifySend:
+		return newNotifySend()
+	case "":
+	default:
+		return nil, fmt.Errorf("unknown notification backend: %s", name)
+	}
+
+	if runtime.GOOS == "darwin" {
+		return newMacOS(), nil
+	}
+
+	b, err := newDBus()
+	if err == nil {
+		return b, nil
+	}
+	if _, lerr := exec.LookPath("notify-send"); lerr == nil {
+		return newNotifySend()
+	}
+	if err != ErrUnsupported {
+		return nil, fmt.Errorf("%v: %v", ErrUnsupported, err)
+	}
+	return nil, err
+}
+
+// open shows the link in the default browser
+func open(link string) error {
+	cmd := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		cmd = "open"
+	}
+	return exec.Command(cmd, link).Start()
+}
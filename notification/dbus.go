@@ -0,0 +1,148 @@
+package notification
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	dbusName      = "org.freedesktop.Notifications"
+	dbusPath      = "/org/freedesktop/Notifications"
+	dbusInterface = "org.freedesktop.Notifications"
+)
+
+// dbusBackend talks to the notification service of the desktop on the
+// session bus. A new notification replaces the previous one
+type dbusBackend struct {
+	conn *dbus.Conn
+	icon string
+
+	mu    sync.Mutex
+	last  uint32
+	links map[uint32]string
+}
+
+func newDBus() (*dbusBackend, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	// the service may be started on the first call, it only has to exist
+	var names []string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.ListActivatableNames", 0).Store(&names); err == nil && !contains(names, dbusName) {
+		var owned bool
+		if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, dbusName).Store(&owned); err != nil || !owned {
+			conn.Close()
+			return nil, ErrUnsupported
+		}
+	}
+
+	b := &dbusBackend{conn: conn, links: make(map[uint32]string)}
+	b.icon, _ = filepath.Abs(AppIcon)
+
+	err = conn.AddMatchSignal(dbus.WithMatchObjectPath(dbusPath), dbus.WithMatchInterface(dbusInterface))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	go b.listen(signals)
+
+	return b, nil
+}
+
+func (b *dbusBackend) Name() string {
+	return DBus
+}
+
+func (b *dbusBackend) Show(n Note) error {
+	summary := n.Title
+	if summary == "" {
+		summary = "Proof"
+	}
+	if n.Subtitle != "" {
+		summary += " - " + n.Subtitle
+	}
+
+	hints := map[string]dbus.Variant{
+		"urgency":       dbus.MakeVariant(urgency(n.Subtitle)),
+		"desktop-entry": dbus.MakeVariant("proof"),
+	}
+
+	b.mu.Lock()
+	replaces := b.last
+	b.mu.Unlock()
+
+	var id uint32
+	call := b.conn.Object(dbusName, dbusPath).Call(dbusInterface+".Notify", 0,
+		"Proof", replaces, b.icon, summary, escape(n.Message), []string{"default", "Details"}, hints, int32(n.Timeout*1000))
+	if err := call.Store(&id); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.links, replaces)
+	b.last = id
+	b.links[id] = n.Link
+	return nil
+}
+
+// listen opens the link of the clicked notifications until the connection
+// is closed
+func (b *dbusBackend) listen(signals chan *dbus.Signal) {
+	for s := range signals {
+		if len(s.Body) == 0 {
+			continue
+		}
+		id, ok := s.Body[0].(uint32)
+		if !ok {
+			continue
+		}
+
+		b.mu.Lock()
+		link := b.links[id]
+		if s.Name == dbusInterface+".NotificationClosed" {
+			delete(b.links, id)
+		}
+		b.mu.Unlock()
+
+		if s.Name == dbusInterface+".ActionInvoked" && link != "" {
+			if err := open(link); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+// urgency returns low, normal or critical for the level
+func urgency(level string) byte {
+	switch strings.ToLower(level) {
+	case "debug", "info":
+		return 0
+	case "fatal", "critical":
+		return 2
+	}
+	return 1
+}
+
+// escape keeps the message as text, the body may be shown as markup
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
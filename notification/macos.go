@@ -0,0 +1,35 @@
+package notification
+
+import (
+	"os"
+
+	"github.com/scr34m/gosx-notifier"
+)
+
+// macOS shows the notifications by terminal-notifier
+type macOS struct{}
+
+func newMacOS() *macOS {
+	// terminal-notifier is installed by Homebrew
+	os.Setenv("PATH", os.Getenv("PATH")+":/usr/local/bin")
+	os.Setenv("PATH", os.Getenv("PATH")+":/opt/homebrew/bin")
+	return &macOS{}
+}
+
+func (b *macOS) Name() string {
+	return MacOS
+}
+
+func (b *macOS) Show(n Note) error {
+	note := gosxnotifier.NewNotification(n.Message)
+	note.Title = n.Title
+	note.Subtitle = n.Subtitle
+	note.Sound = gosxnotifier.Basso // gosxnotifier.Default
+	note.Group = "proof"
+	note.Remove = "proof"
+	note.Sender = "com.apple.Safari"
+	note.Link = n.Link
+	note.Timeout = n.Timeout
+	note.AppIcon = AppIcon
+	return note.Push()
+}
@@ -1,27 +1,46 @@
 package notification
 
 import (
-	"time"
-	"github.com/scr34m/gosx-notifier"
 	"fmt"
 	"log"
+	"time"
 )
 
-type Notification struct {
-	url      string
-	duration time.Duration
-	last     time.Time
-	timer    *time.Timer
+// Note is a desktop notification, Link is opened when it is clicked
+type Note struct {
+	Title    string
+	Subtitle string
 	Message  string
-	Id       int64
-	Timeout  int
-	ServerName  string
-	Level  string
+	Link     string
+	// Timeout in seconds
+	Timeout int
+}
+
+// Backend shows the notifications on the desktop, see NewBackend
+type Backend interface {
+	Name() string
+	Show(note Note) error
+}
+
+type Notification struct {
+	url        string
+	backend    Backend
+	duration   time.Duration
+	last       time.Time
+	timer      *time.Timer
+	Message    string
+	Id         int64
+	Timeout    int
+	ServerName string
+	Level      string
 }
 
-func NewNotification(url string, interval time.Duration) *Notification {
+// NewNotification shows the last of the events pinged within interval
+// milliseconds, url is the frontend URL of the details links
+func NewNotification(url string, backend Backend, interval time.Duration) *Notification {
 	n := new(Notification)
 	n.url = url
+	n.backend = backend
 	n.duration = time.Millisecond * interval
 	n.timer = nil
 	n.Timeout = 10
@@ -43,17 +62,13 @@ func (n *Notification) Ping(id int64, message string, servername string, level s
 func (n *Notification) timeout() {
 	n.timer = nil
 
-	note := gosxnotifier.NewNotification(n.Message)
-	note.Title = n.ServerName
-	note.Subtitle = n.Level
-	note.Sound = gosxnotifier.Basso // gosxnotifier.Default
-	note.Group = "proof"
-	note.Remove = "proof"
-	note.Sender = "com.apple.Safari"
-	note.Link = fmt.Sprintf("http://%s/details/%d", n.url, n.Id)
-	note.Timeout = n.Timeout
-	note.AppIcon = "assets/appicon.png"
-	err := note.Push()
+	err := n.backend.Show(Note{
+		Title:    n.ServerName,
+		Subtitle: n.Level,
+		Message:  n.Message,
+		Link:     fmt.Sprintf("%s/details/%d", n.url, n.Id),
+		Timeout:  n.Timeout,
+	})
 	if err != nil {
 		log.Print(err)
 	}
@@ -0,0 +1,78 @@
+package notification
+
+import (
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// notifySend runs notify-send of libnotify, the versions supporting --action
+// wait for the click in the background
+type notifySend struct {
+	path   string
+	icon   string
+	action bool
+}
+
+func newNotifySend() (*notifySend, error) {
+	path, err := exec.LookPath("notify-send")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &notifySend{path: path}
+	b.icon, _ = filepath.Abs(AppIcon)
+
+	help, _ := exec.Command(path, "--help").Output()
+	b.action = strings.Contains(string(help), "--action")
+	return b, nil
+}
+
+func (b *notifySend) Name() string {
+	return NotifySend
+}
+
+func (b *notifySend) Show(n Note) error {
+	summary := n.Title
+	if summary == "" {
+		summary = "Proof"
+	}
+	if n.Subtitle != "" {
+		summary += " - " + n.Subtitle
+	}
+
+	urgencies := []string{"low", "normal", "critical"}
+	args := []string{
+		"--app-name=Proof",
+		"--icon=" + b.icon,
+		"--urgency=" + urgencies[urgency(n.Subtitle)],
+		"--expire-time=" + strconv.Itoa(n.Timeout*1000),
+	}
+	if !b.action {
+		return exec.Command(b.path, append(args, "--", summary, escape(n.Message))...).Run()
+	}
+
+	// the invoked action is printed when the notification is closed
+	args = append(args, "--action=default=Details", "--wait")
+	cmd := exec.Command(b.path, append(args, "--", summary, escape(n.Message))...)
+	out := &strings.Builder{}
+	cmd.Stdout = out
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("notify-send failed: %v", err)
+			return
+		}
+		if strings.TrimSpace(out.String()) == "default" && n.Link != "" {
+			if err := open(n.Link); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+	return nil
+}
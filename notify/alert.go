@@ -0,0 +1,542 @@
+package notify
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/filter"
+	"github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
+)
+
+const (
+	// AlertMinutes is the default window of the event and user counts
+	AlertMinutes = 60
+	// AlertFrequency is the default minutes between two alerts of a rule for
+	// an issue
+	AlertFrequency = 30
+	// HistoryAge of the evaluations
+	HistoryAge = 7 * 24 * time.Hour
+	// PruneInterval of deleting the old counters and evaluations
+	PruneInterval = time.Hour
+)
+
+// Users in the email list of an alert are the enabled users
+const Users = "users"
+
+// severities in increasing order, the clients sending other levels are
+// treated as error
+var severities = []string{"debug", "info", "warning", "error", "fatal"}
+
+func severity(level string) int {
+	switch strings.ToLower(level) {
+	case "warn":
+		level = "warning"
+	case "critical":
+		level = "fatal"
+	}
+	for i, s := range severities {
+		if s == strings.ToLower(level) {
+			return i
+		}
+	}
+	return 3
+}
+
+// rule is a compiled alert rule of a project
+type rule struct {
+	config.Alert
+	id          string
+	projectId   string
+	level       int
+	environment *regexp.Regexp
+	release     *regexp.Regexp
+	tags        map[string]*regexp.Regexp
+	email       []string
+	users       bool
+	chats       []chat
+}
+
+// chat is a chat webhook, it sends the messages of the alerts too
+type chat interface {
+	Notifier
+	send(m message) error
+}
+
+// RuleStatus is an alert rule as shown on the alerts page
+type RuleStatus struct {
+	Id         string
+	ProjectId  string
+	Name       string
+	Conditions []string
+	Actions    []string
+	Frequency  int
+}
+
+func newRule(p config.Project, i int, a config.Alert, teams map[string][]string, hooks *webhook.Sender, siteUrl string, client *http.Client) (*rule, error) {
+	if a.Name == "" {
+		a.Name = fmt.Sprintf("rule %d", i+1)
+	}
+	if a.Minutes <= 0 {
+		a.Minutes = AlertMinutes
+	}
+	if a.Frequency <= 0 {
+		a.Frequency = AlertFrequency
+	}
+
+	r := &rule{Alert: a, id: p.Id + "/" + a.Name, projectId: p.Id, level: -1}
+
+	var err error
+	if a.Level != "" {
+		r.level = severity(a.Level)
+	}
+	if a.Environment != "" {
+		if r.environment, err = filter.Glob(a.Environment); err != nil {
+			return nil, err
+		}
+	}
+	if a.Release != "" {
+		if r.release, err = filter.Glob(a.Release); err != nil {
+			return nil, err
+		}
+	}
+	if len(a.Tags) > 0 {
+		r.tags = make(map[string]*regexp.Regexp)
+		for k, v := range a.Tags {
+			if r.tags[k], err = filter.Glob(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if r.email, r.users, err = addresses(a.Email, teams); err != nil {
+		return nil, fmt.Errorf("alert %s: %v", a.Name, err)
+	}
+	for _, u := range a.Webhook {
+		if hooks == nil || !hooks.Has(u) {
+			return nil, fmt.Errorf("alert %s: %s is not an enabled webhook", a.Name, u)
+		}
+	}
+	for _, n := range a.Notify {
+		c, err := newChat(n, siteUrl, client)
+		if err != nil {
+			return nil, fmt.Errorf("alert %s: %v", a.Name, err)
+		}
+		r.chats = append(r.chats, c)
+	}
+
+	if len(r.email) == 0 && !r.users && len(a.Webhook) == 0 && len(r.chats) == 0 {
+		return nil, fmt.Errorf("alert %s has no actions", a.Name)
+	}
+	return r, nil
+}
+
+// check returns the condition the event does not meet of the ones known
+// without the counters and why, empty when it meets them
+func (r *rule) check(status *parser.ProcessStatus, firstRelease string) (string, string) {
+	if r.New || r.Regression {
+		if !(r.New && status.IsNew) && !(r.Regression && status.IsRegression) {
+			return "lifecycle", "not a " + strings.Join(r.lifecycle(), " or ")
+		}
+	}
+	if r.level >= 0 && severity(status.Severity) < r.level {
+		return "level", fmt.Sprintf("level %s is below %s", status.Severity, severities[r.level])
+	}
+	if r.environment != nil && !r.environment.MatchString(status.Environment) {
+		return "environment", fmt.Sprintf("environment %q does not match %s", status.Environment, r.Environment)
+	}
+
+	for _, k := range r.tagKeys() {
+		v, ok := status.Tags[k]
+		if !ok {
+			return "tag " + k, fmt.Sprintf("tag %s is missing", k)
+		}
+		if !r.tags[k].MatchString(v) {
+			return "tag " + k, fmt.Sprintf("tag %s=%s does not match %s", k, v, r.Tags[k])
+		}
+	}
+
+	if r.release != nil && !r.release.MatchString(firstRelease) {
+		return "release", fmt.Sprintf("first seen in release %q, not %s", firstRelease, r.Release)
+	}
+	return "", ""
+}
+
+func (r *rule) tagKeys() []string {
+	keys := make([]string, 0, len(r.Tags))
+	for k := range r.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *rule) lifecycle() []string {
+	var list []string
+	if r.New {
+		list = append(list, "new issue")
+	}
+	if r.Regression {
+		list = append(list, "regression")
+	}
+	return list
+}
+
+// Status returns the rule as shown on the alerts page
+func (r *rule) Status() RuleStatus {
+	s := RuleStatus{Id: r.id, ProjectId: r.projectId, Name: r.Name, Frequency: r.Frequency}
+
+	if len(r.lifecycle()) > 0 {
+		s.Conditions = append(s.Conditions, strings.Join(r.lifecycle(), " or "))
+	}
+	if r.Events > 0 {
+		s.Conditions = append(s.Conditions, fmt.Sprintf("more than %d events in %d minutes", r.Events, r.Minutes))
+	}
+	if r.Users > 0 {
+		s.Conditions = append(s.Conditions, fmt.Sprintf("more than %d users in %d minutes", r.Users, r.Minutes))
+	}
+	if r.level >= 0 {
+		s.Conditions = append(s.Conditions, "level "+severities[r.level]+" or higher")
+	}
+	if r.environment != nil {
+		s.Conditions = append(s.Conditions, "environment "+r.Environment)
+	}
+	for _, k := range r.tagKeys() {
+		s.Conditions = append(s.Conditions, "tag "+k+"="+r.Tags[k])
+	}
+	if r.release != nil {
+		s.Conditions = append(s.Conditions, "first seen in release "+r.Release)
+	}
+	if len(s.Conditions) == 0 {
+		s.Conditions = []string{"every event"}
+	}
+
+	if r.users {
+		s.Actions = append(s.Actions, "email the users")
+	}
+	if len(r.email) > 0 {
+		s.Actions = append(s.Actions, "email "+strings.Join(r.email, ", "))
+	}
+	for _, u := range r.Webhook {
+		s.Actions = append(s.Actions, "webhook "+host(u))
+	}
+	for _, n := range r.Notify {
+		s.Actions = append(s.Actions, n.Type+" "+host(n.Url))
+	}
+	return s
+}
+
+// alerts evaluates the rules of the projects. The counters are kept in the
+// database, so the workers sharing it count the events together
+type alerts struct {
+	rules   map[string][]*rule
+	repo    storage.Storage
+	mailer  *mail.Mailer
+	users   storage.UserStore
+	hooks   *webhook.Sender
+	siteUrl string
+
+	mu sync.Mutex
+	// logged keeps when a reason of not firing was logged, it is logged once
+	// in the frequency of the rule for an issue
+	logged map[string]time.Time
+}
+
+func newAlerts(settings *config.Config, siteUrl string, mailer *mail.Mailer, users storage.UserStore, repo storage.Storage, hooks *webhook.Sender, client *http.Client) (*alerts, error) {
+	a := &alerts{
+		rules:   make(map[string][]*rule),
+		repo:    repo,
+		mailer:  mailer,
+		users:   users,
+		hooks:   hooks,
+		siteUrl: siteUrl,
+		logged:  make(map[string]time.Time),
+	}
+
+	teams := teamMembers(settings)
+	for _, p := range settings.Project {
+		names := make(map[string]bool)
+		for i, c := range p.Alert {
+			r, err := newRule(p, i, c, teams, hooks, siteUrl, client)
+			if err != nil {
+				return nil, fmt.Errorf("project %s: %v", p.Id, err)
+			}
+			if names[r.Name] {
+				return nil, fmt.Errorf("project %s: duplicate alert %s", p.Id, r.Name)
+			}
+			names[r.Name] = true
+			if (r.users || len(r.email) > 0) && mailer == nil {
+				return nil, fmt.Errorf("project %s: alert %s emails without a mail server", p.Id, r.Name)
+			}
+			if r.users && users == nil {
+				return nil, fmt.Errorf("project %s: alert %s emails the users without the auth mode", p.Id, r.Name)
+			}
+			a.rules[p.Id] = append(a.rules[p.Id], r)
+		}
+	}
+	return a, nil
+}
+
+// teamMembers returns the addresses of the teams by their names
+func teamMembers(settings *config.Config) map[string][]string {
+	teams := make(map[string][]string)
+	for _, t := range settings.Team {
+		teams[t.Name] = t.Members
+	}
+	return teams
+}
+
+// addresses resolves the team names of the email list, users tells if the
+// enabled users are listed too
+func addresses(list []string, teams map[string][]string) (emails []string, users bool, err error) {
+	for _, e := range list {
+		switch {
+		case e == Users:
+			users = true
+		case teams[e] != nil:
+			emails = append(emails, teams[e]...)
+		case strings.Contains(e, "@"):
+			emails = append(emails, e)
+		default:
+			return nil, false, fmt.Errorf("unknown team %s", e)
+		}
+	}
+	return emails, users, nil
+}
+
+// evaluate counts the event and returns the actions of the rules that fire
+func (a *alerts) evaluate(status *parser.ProcessStatus) ([]route, error) {
+	rules := a.rules[status.Project]
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var userHash string
+	if status.User != "" {
+		hasher := md5.New()
+		hasher.Write([]byte(status.User))
+		userHash = hex.EncodeToString(hasher.Sum(nil))
+	}
+	if err := a.repo.Alerts().Count(status.GroupId, now, userHash); err != nil {
+		return nil, err
+	}
+	firstRelease, err := a.repo.Alerts().FirstRelease(status.GroupId, status.Release)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []route
+	for _, r := range rules {
+		fired, condition, reason, err := a.fire(r, status, firstRelease, now)
+		if err != nil {
+			return routes, err
+		}
+		if !a.log(r, status, fired, condition, reason, now) {
+			continue
+		}
+		if fired {
+			routes = append(routes, a.actions(r, reason)...)
+		}
+	}
+	return routes, nil
+}
+
+// fire checks the conditions of the rule and its frequency, the reason
+// lists the met conditions when it fires, or tells why the condition is not
+// met
+func (a *alerts) fire(r *rule, status *parser.ProcessStatus, firstRelease string, now time.Time) (bool, string, string, error) {
+	if condition, reason := r.check(status, firstRelease); condition != "" {
+		return false, condition, reason, nil
+	}
+
+	var met []string
+	if len(r.lifecycle()) > 0 {
+		if status.IsNew {
+			met = append(met, "new issue")
+		} else {
+			met = append(met, "regression")
+		}
+	}
+
+	since := now.Add(-time.Duration(r.Minutes) * time.Minute)
+	if r.Events > 0 {
+		n, err := a.repo.Alerts().Events(status.GroupId, since)
+		if err != nil {
+			return false, "", "", err
+		}
+		if n <= r.Events {
+			return false, "events", fmt.Sprintf("%d events in %d minutes, not more than %d", n, r.Minutes, r.Events), nil
+		}
+		met = append(met, fmt.Sprintf("%d events in %d minutes", n, r.Minutes))
+	}
+	if r.Users > 0 {
+		n, err := a.repo.Alerts().Users(status.GroupId, since)
+		if err != nil {
+			return false, "", "", err
+		}
+		if n <= r.Users {
+			return false, "users", fmt.Sprintf("%d users in %d minutes, not more than %d", n, r.Minutes, r.Users), nil
+		}
+		met = append(met, fmt.Sprintf("%d users in %d minutes", n, r.Minutes))
+	}
+	if r.level >= 0 {
+		met = append(met, "level "+status.Severity)
+	}
+	if r.environment != nil {
+		met = append(met, "environment "+status.Environment)
+	}
+	for _, k := range r.tagKeys() {
+		met = append(met, "tag "+k+"="+status.Tags[k])
+	}
+	if r.release != nil {
+		met = append(met, "first seen in release "+firstRelease)
+	}
+	if len(met) == 0 {
+		met = append(met, "event")
+	}
+
+	ok, err := a.repo.Alerts().Fire(r.id, status.GroupId, now, now.Add(-time.Duration(r.Frequency)*time.Minute))
+	if err != nil {
+		return false, "", "", err
+	}
+	if !ok {
+		return false, "frequency", fmt.Sprintf("already fired in the last %d minutes", r.Frequency), nil
+	}
+	return true, "", strings.Join(met, ", "), nil
+}
+
+// log stores the evaluation, false means a repeated condition of not firing
+// that is not stored. The reason may hold the counters, it is not a part of
+// the key
+func (a *alerts) log(r *rule, status *parser.ProcessStatus, fired bool, condition string, reason string, now time.Time) bool {
+	if !fired {
+		key := fmt.Sprintf("%s\x00%d\x00%s", r.id, status.GroupId, condition)
+		a.mu.Lock()
+		last, ok := a.logged[key]
+		if ok && now.Sub(last) < time.Duration(r.Frequency)*time.Minute {
+			a.mu.Unlock()
+			return false
+		}
+		a.logged[key] = now
+		a.mu.Unlock()
+	}
+
+	e := &storage.Evaluation{Rule: r.id, ProjectId: status.Project, GroupId: status.GroupId, Fired: fired, Reason: reason, Created: now}
+	if err := a.repo.Alerts().Log(e); err != nil {
+		log.Printf("Logging alert %s failed: %v", r.id, err)
+	}
+	return true
+}
+
+// actions returns the notifiers of the fired rule
+func (a *alerts) actions(r *rule, reason string) []route {
+	var routes []route
+	if r.users || len(r.email) > 0 {
+		routes = append(routes, route{name: "alert " + r.id + " email", notifier: &alertMail{alerts: a, rule: r, reason: reason}})
+	}
+	if len(r.Webhook) > 0 {
+		routes = append(routes, route{name: "alert " + r.id + " webhook", notifier: &alertWebhook{hooks: a.hooks, rule: r, reason: reason}})
+	}
+	for i, c := range r.chats {
+		routes = append(routes, route{name: "alert " + r.id + " " + r.Notify[i].Type, notifier: &alertChat{chat: c, siteUrl: a.siteUrl, rule: r, reason: reason}})
+	}
+	return routes
+}
+
+// prune deletes the counters older than the longest window and the
+// evaluations older than HistoryAge
+func (a *alerts) prune() {
+	now := time.Now()
+	window := 0
+	for _, rules := range a.rules {
+		for _, r := range rules {
+			if r.Minutes > window {
+				window = r.Minutes
+			}
+			if r.Frequency > window {
+				window = r.Frequency
+			}
+		}
+	}
+
+	if err := a.repo.Alerts().Prune(now.Add(-time.Duration(window)*time.Minute), now.Add(-HistoryAge)); err != nil {
+		log.Printf("Pruning the alert counters failed: %v", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, last := range a.logged {
+		if now.Sub(last) > time.Duration(window)*time.Minute {
+			delete(a.logged, key)
+		}
+	}
+}
+
+// alertMail emails the users and addresses of the rule
+type alertMail struct {
+	alerts *alerts
+	rule   *rule
+	reason string
+}
+
+func (n *alertMail) Notify(status *parser.ProcessStatus) (err error) {
+	// the mailer panics on failure
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	to := append([]string(nil), n.rule.email...)
+	if n.rule.users {
+		for _, u := range n.alerts.users.Recipients() {
+			if !contains(to, u) {
+				to = append(to, u)
+			}
+		}
+	}
+	if len(to) == 0 {
+		return nil
+	}
+
+	n.alerts.mailer.Alert(to, n.rule.Name, n.reason, status)
+	return nil
+}
+
+// alertWebhook stores the deliveries of the webhooks of the rule
+type alertWebhook struct {
+	hooks  *webhook.Sender
+	rule   *rule
+	reason string
+}
+
+func (n *alertWebhook) Notify(status *parser.ProcessStatus) error {
+	return n.hooks.Alert(n.rule.Webhook, n.rule.Name, n.reason, status.GroupId)
+}
+
+// alertChat posts the event to the chat webhook of the rule with the reason
+type alertChat struct {
+	chat    chat
+	siteUrl string
+	rule    *rule
+	reason  string
+}
+
+func (n *alertChat) Notify(status *parser.ProcessStatus) error {
+	m := newMessage(status, n.siteUrl)
+	m.title = fmt.Sprintf("[%s] Alert %s: %s", status.Project, n.rule.Name, summary(status.Message))
+	m.fields = append([][2]string{{"Reason", n.reason}}, m.fields...)
+	return n.chat.send(m)
+}
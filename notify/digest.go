@@ -0,0 +1,309 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/storage"
+)
+
+const (
+	DigestMinutes = 5
+	// FlushInterval of sending the due digests and reports
+	FlushInterval = time.Minute
+	// ReportTop is the number of the most frequent issues of a project in a
+	// report
+	ReportTop = 10
+	// ReportDays of keeping the day counters
+	ReportDays = 14
+	// Day is the format of the report days
+	Day = "2006-01-02"
+)
+
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+// digestKind returns the subscription mode the event is emailed for
+func digestKind(status *parser.ProcessStatus) string {
+	if status.IsNew {
+		return storage.SubscribeNew
+	}
+	if status.IsRegression {
+		return storage.SubscribeRegression
+	}
+	return storage.SubscribeAll
+}
+
+// collect adds the event to the digest of each recipient
+func (m *mailing) collect(status *parser.ProcessStatus) error {
+	now := time.Now()
+	lastSeen := status.LastSeen
+	if lastSeen.IsZero() {
+		lastSeen = now
+	}
+
+	for _, email := range m.to {
+		err := m.n.repo.Digests().Add(storage.DigestItem{
+			Email:      email,
+			ProjectId:  status.Project,
+			GroupId:    status.GroupId,
+			Kind:       digestKind(status),
+			Message:    status.Message,
+			Level:      status.Level,
+			Stacktrace: stacktrace(status.Frames),
+			Created:    now,
+			LastSeen:   lastSeen,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush emails the digests waiting for the window, more processes may run it
+// at the same time
+func (n *mailNotifier) flush() {
+	due, err := n.repo.Digests().Due(time.Now().Add(-n.window))
+	if err != nil {
+		log.Printf("Loading the email digests failed: %v", err)
+		return
+	}
+
+	for _, email := range due {
+		items, err := n.repo.Digests().Take(email)
+		if err != nil {
+			log.Printf("Taking the email digest of %s failed: %v", email, err)
+			continue
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		unsubscribe := make(map[string]string)
+		for _, item := range items {
+			unsubscribe[item.ProjectId] = n.unsubscribe(email, item.ProjectId)
+		}
+		if err := n.digest(email, items, unsubscribe); err != nil {
+			log.Printf("Email digest of %s failed, it is retried: %v", email, err)
+			if err := n.repo.Digests().Restore(items); err != nil {
+				log.Printf("Restoring the email digest of %s failed: %v", email, err)
+			}
+		}
+	}
+}
+
+func (n *mailNotifier) digest(email string, items []storage.DigestItem, unsubscribe map[string]string) (err error) {
+	// the mailer panics on failure
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	n.mailer.Digest(email, items, unsubscribe)
+	return nil
+}
+
+// reports counts the events of the days and emails the daily and weekly
+// summaries of the projects
+type reports struct {
+	mailer      *mail.Mailer
+	users       storage.UserStore
+	repo        storage.Storage
+	hour        int
+	daily       []string
+	dailyUsers  bool
+	weekly      []string
+	weeklyUsers bool
+	names       map[string]string
+	pruned      string
+}
+
+func newReports(settings *config.Config, mailer *mail.Mailer, users storage.UserStore, repo storage.Storage) (*reports, error) {
+	c := settings.Digest
+	if len(c.Daily) == 0 && len(c.Weekly) == 0 {
+		return nil, nil
+	}
+	if mailer == nil {
+		return nil, errors.New("summary reports without a mail server")
+	}
+	if c.Hour < 0 || c.Hour > 23 {
+		return nil, fmt.Errorf("summary report hour %d is not between 0 and 23", c.Hour)
+	}
+
+	r := &reports{mailer: mailer, users: users, repo: repo, hour: c.Hour, names: make(map[string]string)}
+
+	teams := teamMembers(settings)
+	var err error
+	if r.daily, r.dailyUsers, err = addresses(c.Daily, teams); err != nil {
+		return nil, fmt.Errorf("daily report: %v", err)
+	}
+	if r.weekly, r.weeklyUsers, err = addresses(c.Weekly, teams); err != nil {
+		return nil, fmt.Errorf("weekly report: %v", err)
+	}
+	if (r.dailyUsers || r.weeklyUsers) && users == nil {
+		return nil, errors.New("summary reports email the users without the auth mode")
+	}
+
+	for _, p := range settings.Project {
+		r.names[p.Id] = p.Name
+	}
+	return r, nil
+}
+
+// count adds the event to the counters of the day
+func (r *reports) count(status *parser.ProcessStatus) error {
+	return r.repo.Reports().Count(status.Project, status.GroupId, time.Now().Format(Day), status.IsNew, status.IsRegression)
+}
+
+// run sends the reports due after the hour, once a day the old counters are
+// pruned
+func (r *reports) run(now time.Time) {
+	if now.Hour() < r.hour {
+		return
+	}
+
+	yesterday := now.AddDate(0, 0, -1).Format(Day)
+	if len(r.daily) > 0 || r.dailyUsers {
+		r.send(Daily, yesterday, yesterday, r.daily, r.dailyUsers)
+	}
+	if (len(r.weekly) > 0 || r.weeklyUsers) && now.Weekday() == time.Monday {
+		r.send(Weekly, now.AddDate(0, 0, -7).Format(Day), yesterday, r.weekly, r.weeklyUsers)
+	}
+
+	today := now.Format(Day)
+	if r.pruned != today {
+		r.pruned = today
+		if err := r.repo.Reports().Prune(now.AddDate(0, 0, -ReportDays).Format(Day)); err != nil {
+			log.Printf("Pruning the report counters failed: %v", err)
+		}
+	}
+}
+
+// send emails the report of the days once, the first process recording it
+// sends it. The record is forgotten when sending fails
+func (r *reports) send(kind string, since string, until string, to []string, users bool) {
+	ok, err := r.repo.Reports().Sent(kind, since)
+	if err != nil {
+		log.Printf("Recording the %s report of %s failed: %v", kind, since, err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	to = append([]string(nil), to...)
+	if users {
+		for _, u := range r.users.Recipients() {
+			if !contains(to, u) {
+				to = append(to, u)
+			}
+		}
+	}
+	if len(to) == 0 {
+		return
+	}
+
+	items, err := r.repo.Reports().Summary(since, until)
+	if err != nil {
+		log.Printf("Loading the %s report of %s failed, it is retried: %v", kind, since, err)
+		r.unsent(kind, since)
+		return
+	}
+
+	title := fmt.Sprintf("Daily report of %s", since)
+	if kind == Weekly {
+		title = fmt.Sprintf("Weekly report of %s - %s", since, until)
+	}
+	if err := r.email(to, title, r.projects(items)); err != nil {
+		log.Printf("Email of the %s report of %s failed, it is retried: %v", kind, since, err)
+		r.unsent(kind, since)
+		return
+	}
+	log.Printf("The %s report of %s sent to %d recipients", kind, since, len(to))
+}
+
+// unsent forgets the record of the report, the next run sends it
+func (r *reports) unsent(kind string, since string) {
+	if err := r.repo.Reports().Unsent(kind, since); err != nil {
+		log.Printf("Forgetting the %s report of %s failed: %v", kind, since, err)
+	}
+}
+
+// projects groups the issues by project with the new, the regressed and the
+// most frequent ones
+func (r *reports) projects(items []storage.ReportItem) []mail.ReportProject {
+	var list []mail.ReportProject
+	index := make(map[string]int)
+	for _, item := range items {
+		i, ok := index[item.ProjectId]
+		if !ok {
+			i = len(list)
+			index[item.ProjectId] = i
+			list = append(list, mail.ReportProject{Id: item.ProjectId, Name: r.names[item.ProjectId]})
+		}
+
+		p := &list[i]
+		p.Events += item.Quantity
+		p.Issues++
+		if item.New > 0 {
+			p.New = append(p.New, item)
+		}
+		if item.Regressed > 0 {
+			p.Regressed = append(p.Regressed, item)
+		}
+		// the issues come the most frequent first
+		if len(p.Top) < ReportTop {
+			p.Top = append(p.Top, item)
+		}
+	}
+	return list
+}
+
+func (r *reports) email(to []string, title string, projects []mail.ReportProject) (err error) {
+	// the mailer panics on failure
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	r.mailer.Report(to, title, projects)
+	return nil
+}
+
+// startDigests sends the due digests and reports until the context is done
+func (d *Dispatcher) startDigests(ctx context.Context) {
+	if (d.mail == nil || d.mail.window == 0) && d.reports == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if d.mail != nil && d.mail.window > 0 {
+				d.mail.flush()
+			}
+			if d.reports != nil {
+				d.reports.run(time.Now())
+			}
+		}
+	}()
+}
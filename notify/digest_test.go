@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/storage"
+)
+
+// failingMailer returns a mailer of a closed SMTP port
+func failingMailer(t *testing.T) *mail.Mailer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	return mail.NewMailer("127.0.0.1", port, "", "", false, "proof@example.com", "http://localhost:2017")
+}
+
+func TestReportFailed(t *testing.T) {
+	repo := storage.NewMemory()
+	settings := &config.Config{Digest: config.Digest{Daily: []string{"ops@example.com"}}}
+	r, err := newReports(settings, failingMailer(t), nil, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1).Format(Day)
+	if err := repo.Reports().Count("1", 1, yesterday, true, false); err != nil {
+		t.Fatal(err)
+	}
+	r.run(now)
+
+	// not recorded as sent, the next run sends it
+	ok, err := repo.Reports().Sent(Daily, yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("the failed report is recorded as sent")
+	}
+}
+
+func TestDigestFailed(t *testing.T) {
+	repo := storage.NewMemory()
+	n := &mailNotifier{mailer: failingMailer(t), repo: repo, secret: "secret", siteUrl: "http://localhost:2017", window: time.Minute}
+
+	created := time.Now().Add(-time.Hour)
+	err := repo.Digests().Add(storage.DigestItem{Email: "ops@example.com", ProjectId: "1", GroupId: 1, Kind: storage.SubscribeAll, Message: "a", Created: created, LastSeen: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.flush()
+
+	// put back, the next flush sends it
+	due, err := repo.Digests().Due(time.Now().Add(-n.window))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0] != "ops@example.com" {
+		t.Fatalf("due digests after the failure: %v", due)
+	}
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scr34m/proof/parser"
+)
+
+// discord posts an embed, its description is limited to 4096 characters
+type discord struct {
+	url     string
+	siteUrl string
+	client  *http.Client
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Url         string         `json:"url"`
+	Description string         `json:"description,omitempty"`
+	Color       int64          `json:"color"`
+	Fields      []discordField `json:"fields"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordMessage struct {
+	Username string         `json:"username"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+func (n *discord) Notify(status *parser.ProcessStatus) error {
+	return n.send(newMessage(status, n.siteUrl))
+}
+
+func (n *discord) send(m message) error {
+	color, _ := strconv.ParseInt(strings.TrimPrefix(m.color, "#"), 16, 64)
+	e := discordEmbed{
+		Title:     m.title,
+		Url:       m.link,
+		Color:     color,
+		Timestamp: m.time.UTC().Format(time.RFC3339),
+	}
+	if m.stacktrace != "" {
+		stacktrace := m.stacktrace
+		if len(stacktrace) > 4000 {
+			stacktrace = "..." + stacktrace[len(stacktrace)-4000:]
+		}
+		e.Description = "```\n" + strings.ToValidUTF8(stacktrace, "") + "\n```"
+	}
+	for _, f := range m.fields {
+		e.Fields = append(e.Fields, discordField{Name: f[0], Value: f[1], Inline: true})
+	}
+
+	return post(n.client, n.url, discordMessage{Username: "Proof", Embeds: []discordEmbed{e}})
+}
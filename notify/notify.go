@@ -0,0 +1,403 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
+)
+
+const (
+	Slack      = "slack"
+	Mattermost = "mattermost"
+	Discord    = "discord"
+	Teams      = "teams"
+)
+
+const (
+	// MaxPending notifications wait for the slow webhooks, the later ones are
+	// dropped
+	MaxPending = 1000
+	Timeout    = 10 * time.Second
+	// StackFrames is the number of the innermost frames in a message
+	StackFrames = 5
+)
+
+var ErrBusy = errors.New("too many pending notifications")
+
+// Notifier tells about an event
+type Notifier interface {
+	Notify(status *parser.ProcessStatus) error
+}
+
+// route sends the events of a project to a notifier
+type route struct {
+	name      string
+	projectId string
+	levels    []string
+	notifier  Notifier
+}
+
+func (r route) match(status *parser.ProcessStatus) bool {
+	if r.projectId != status.Project {
+		return false
+	}
+	if len(r.levels) > 0 && !contains(r.levels, strings.ToLower(status.Severity)) {
+		return false
+	}
+	return true
+}
+
+type job struct {
+	route  route
+	status *parser.ProcessStatus
+}
+
+// Dispatcher fans the events out to the subscribed users, the new and
+// regressed ones to the chat webhooks of the project and the ones meeting the
+// alert rules to their actions in the background. The unsubscribe links are
+// signed with the secret
+type Dispatcher struct {
+	routes  []route
+	mail    *mailNotifier
+	alerts  *alerts
+	reports *reports
+	repo    storage.Storage
+	secret  string
+	jobs    chan job
+}
+
+func NewDispatcher(settings *config.Config, siteUrl string, mailer *mail.Mailer, users storage.UserStore, repo storage.Storage, hooks *webhook.Sender, secret string) (*Dispatcher, error) {
+	d := &Dispatcher{repo: repo, secret: secret, jobs: make(chan job, MaxPending)}
+
+	client := &http.Client{Timeout: Timeout}
+	var err error
+	d.alerts, err = newAlerts(settings, siteUrl, mailer, users, repo, hooks, client)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.reports, err = newReports(settings, mailer, users, repo); err != nil {
+		return nil, err
+	}
+
+	if settings.Digest.Enabled && mailer == nil {
+		return nil, errors.New("email digest without a mail server")
+	}
+	if mailer != nil && secret == "" {
+		return nil, errors.New("email notifications without a secret of the unsubscribe links")
+	}
+	if mailer != nil {
+		d.mail = &mailNotifier{mailer: mailer, users: users, repo: repo, secret: secret, siteUrl: siteUrl}
+		// the alert rules email the users of their projects
+		for projectId := range d.alerts.rules {
+			d.mail.silent = append(d.mail.silent, projectId)
+		}
+		if settings.Digest.Enabled {
+			minutes := settings.Digest.Minutes
+			if minutes <= 0 {
+				minutes = DigestMinutes
+			}
+			d.mail.window = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	for _, p := range settings.Project {
+		for _, n := range p.Notify {
+			notifier, err := NewWebhook(n, siteUrl, client)
+			if err != nil {
+				return nil, fmt.Errorf("project %s: %v", p.Id, err)
+			}
+
+			var levels []string
+			for _, level := range n.Levels {
+				levels = append(levels, strings.ToLower(level))
+			}
+			d.routes = append(d.routes, route{name: n.Type + " " + host(n.Url), projectId: p.Id, levels: levels, notifier: notifier})
+		}
+	}
+	return d, nil
+}
+
+// NewWebhook returns the notifier of the chat incoming webhook
+func NewWebhook(n config.Notify, siteUrl string, client *http.Client) (Notifier, error) {
+	return newChat(n, siteUrl, client)
+}
+
+func newChat(n config.Notify, siteUrl string, client *http.Client) (chat, error) {
+	if n.Url == "" {
+		return nil, fmt.Errorf("%s notification without url", n.Type)
+	}
+
+	switch n.Type {
+	case Slack, Mattermost:
+		return &slack{url: n.Url, channel: n.Channel, siteUrl: siteUrl, client: client, mattermost: n.Type == Mattermost}, nil
+	case Discord:
+		return &discord{url: n.Url, siteUrl: siteUrl, client: client}, nil
+	case Teams:
+		return &teams{url: n.Url, siteUrl: siteUrl, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown notification type: %s", n.Type)
+}
+
+// Start sends the notifications, the digests and the reports and prunes the
+// alert counters until the context is done
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.startDigests(ctx)
+
+	if len(d.alerts.rules) > 0 {
+		go func() {
+			ticker := time.NewTicker(PruneInterval)
+			defer ticker.Stop()
+
+			for {
+				d.alerts.prune()
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case j := <-d.jobs:
+				if err := j.route.notifier.Notify(j.status); err != nil {
+					log.Printf("Notification %s failed: %v", j.route.name, err)
+				}
+			}
+		}
+	}()
+}
+
+// Notify counts the event for the reports, evaluates the alert rules of the
+// project and queues the event for the subscribed users and a new or
+// regressed one for the matching notifiers, a notification is dropped with
+// MaxPending ones waiting
+func (d *Dispatcher) Notify(status *parser.ProcessStatus) error {
+	if d.reports != nil {
+		if err := d.reports.count(status); err != nil {
+			log.Printf("Report counters of project %s failed: %v", status.Project, err)
+		}
+	}
+
+	routes, err := d.alerts.evaluate(status)
+	if err != nil {
+		log.Printf("Alert rules of project %s failed: %v", status.Project, err)
+	}
+
+	if d.mail != nil {
+		to, err := d.mail.recipients(status)
+		if err != nil {
+			log.Printf("Email recipients of project %s failed: %v", status.Project, err)
+		} else if len(to) > 0 {
+			routes = append(routes, route{name: "mail", notifier: &mailing{n: d.mail, to: to}})
+		}
+	}
+
+	if status.IsNew || status.IsRegression {
+		for _, r := range d.routes {
+			if r.match(status) {
+				routes = append(routes, r)
+			}
+		}
+	}
+
+	for _, r := range routes {
+		select {
+		case d.jobs <- job{route: r, status: status}:
+		default:
+			log.Printf("Notification %s dropped: %v", r.name, ErrBusy)
+		}
+	}
+	return err
+}
+
+// Rules returns the alert rules of the projects
+func (d *Dispatcher) Rules() []RuleStatus {
+	var list []RuleStatus
+	for _, rules := range d.alerts.rules {
+		for _, r := range rules {
+			list = append(list, r.Status())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// Test sends a sample event to the webhooks of the project right away
+func (d *Dispatcher) Test(projectId string) error {
+	status := &parser.ProcessStatus{
+		Project:  projectId,
+		Message:  "Test notification from Proof",
+		Severity: "info",
+		Seen:     1,
+		IsNew:    true,
+		Frames: []parser.Frame{
+			{AbsPath: "/srv/app/main.py", Function: "main", LineNo: 12, Context: "    run()"},
+			{AbsPath: "/srv/app/run.py", Function: "run", LineNo: 3, Context: "    raise ValueError('test')"},
+		},
+	}
+
+	n := 0
+	var failed []string
+	for _, r := range d.routes {
+		if r.projectId != projectId {
+			continue
+		}
+		n++
+		if err := r.notifier.Notify(status); err != nil {
+			failed = append(failed, r.name+": "+err.Error())
+			continue
+		}
+		log.Printf("Notification %s sent", r.name)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("project %s has no notifications", projectId)
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// message is the content of the chat formats
+type message struct {
+	title      string
+	link       string
+	color      string
+	stacktrace string
+	fields     [][2]string
+	time       time.Time
+}
+
+func newMessage(status *parser.ProcessStatus, siteUrl string) message {
+	event := "Event"
+	if status.IsNew {
+		event = "New event"
+	} else if status.IsRegression {
+		event = "Regression"
+	}
+
+	m := message{
+		title:      fmt.Sprintf("[%s] %s: %s", status.Project, event, summary(status.Message)),
+		link:       fmt.Sprintf("%s/details/%d", strings.TrimRight(siteUrl, "/"), status.GroupId),
+		color:      color(status.Severity),
+		stacktrace: stacktrace(status.Frames),
+		time:       status.LastSeen,
+	}
+	if m.time.IsZero() {
+		m.time = time.Now()
+	}
+
+	m.fields = append(m.fields, [2]string{"Level", status.Severity})
+	if status.Site != "" {
+		m.fields = append(m.fields, [2]string{"Site", status.Site})
+	}
+	if status.ServerName != "" {
+		m.fields = append(m.fields, [2]string{"Server", status.ServerName})
+	}
+	m.fields = append(m.fields, [2]string{"Seen", fmt.Sprintf("%d", status.Seen)})
+	return m
+}
+
+// summary returns the first line of the message, a long one is cut
+func summary(message string) string {
+	if i := strings.Index(message, "\n"); i != -1 {
+		message = message[:i]
+	}
+	if len(message) > 180 {
+		message = message[:180] + "..."
+	}
+	return strings.ToValidUTF8(message, "")
+}
+
+// color returns the colour of the level sent by the client, an unknown one
+// is shown as an error
+func color(level string) string {
+	switch strings.ToLower(level) {
+	case "fatal", "critical":
+		return "#b71c1c"
+	case "warning", "warn":
+		return "#fb8c00"
+	case "info":
+		return "#1e88e5"
+	case "debug":
+		return "#9e9e9e"
+	}
+	return "#e53935"
+}
+
+// stacktrace returns the innermost frames, the last one is the innermost
+func stacktrace(frames []parser.Frame) string {
+	if len(frames) > StackFrames {
+		frames = frames[len(frames)-StackFrames:]
+	}
+
+	var b strings.Builder
+	for _, frame := range frames {
+		fmt.Fprintf(&b, "File \"%s\", line %d, in %s\n", frame.AbsPath, int64(frame.LineNo), frame.Function)
+		if line := strings.TrimSpace(frame.Context); line != "" {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// post sends the message as JSON to the webhook
+func post(client *http.Client, u string, payload interface{}) error {
+	j, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(u, "application/json", bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// host returns the host of the webhook, the rest of the url is a secret
+func host(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "?"
+	}
+	return parsed.Host
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
+)
+
+// hook is a chat incoming webhook passing the posted bodies to the test
+func hook(t *testing.T) (*httptest.Server, chan []byte) {
+	bodies := make(chan []byte, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("content type %s", r.Header.Get("Content-Type"))
+		}
+		bodies <- body
+	}))
+	t.Cleanup(s.Close)
+	return s, bodies
+}
+
+func received(t *testing.T, bodies chan []byte, v interface{}) {
+	t.Helper()
+
+	select {
+	case body := <-bodies:
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing is posted")
+	}
+}
+
+// testStatus is a new event of a client sending the exception type as the
+// level
+func testStatus() *parser.ProcessStatus {
+	return &parser.ProcessStatus{
+		GroupId:    7,
+		Project:    "1",
+		Message:    "Connection refused\nat dial",
+		Level:      "ConnectionError",
+		Severity:   "warning",
+		ServerName: "web-1",
+		Seen:       3,
+		LastSeen:   time.Unix(1700000000, 0),
+		IsNew:      true,
+		Frames:     []parser.Frame{{AbsPath: "/srv/app/db.py", Function: "connect", LineNo: 42, Context: "  sock.connect()"}},
+	}
+}
+
+func notifier(t *testing.T, n config.Notify) Notifier {
+	t.Helper()
+
+	notifier, err := NewWebhook(n, "http://localhost:2017/", http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifier
+}
+
+func TestSlack(t *testing.T) {
+	for _, kind := range []string{Slack, Mattermost} {
+		s, bodies := hook(t)
+		if err := notifier(t, config.Notify{Type: kind, Url: s.URL, Channel: "#alerts"}).Notify(testStatus()); err != nil {
+			t.Fatal(err)
+		}
+
+		var msg slackMessage
+		received(t, bodies, &msg)
+		if msg.Channel != "#alerts" || (msg.Username == "Proof") != (kind == Mattermost) || len(msg.Attachments) != 1 {
+			t.Fatalf("%s message: %+v", kind, msg)
+		}
+		a := msg.Attachments[0]
+		if a.Title != "[1] New event: Connection refused" || a.TitleLink != "http://localhost:2017/details/7" || a.Color != "#fb8c00" || a.Ts != 1700000000 {
+			t.Errorf("%s attachment: %+v", kind, a)
+		}
+		want := []slackField{{"Level", "warning", true}, {"Server", "web-1", true}, {"Seen", "3", true}}
+		if len(a.Fields) != len(want) {
+			t.Fatalf("%s fields: %+v", kind, a.Fields)
+		}
+		for i, f := range want {
+			if a.Fields[i] != f {
+				t.Errorf("%s field %d: %+v", kind, i, a.Fields[i])
+			}
+		}
+		if a.Text != "```\nFile \"/srv/app/db.py\", line 42, in connect\n    sock.connect()\n```" {
+			t.Errorf("%s stack trace: %q", kind, a.Text)
+		}
+	}
+}
+
+func TestDiscord(t *testing.T) {
+	s, bodies := hook(t)
+	if err := notifier(t, config.Notify{Type: Discord, Url: s.URL}).Notify(testStatus()); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg discordMessage
+	received(t, bodies, &msg)
+	if msg.Username != "Proof" || len(msg.Embeds) != 1 {
+		t.Fatalf("message: %+v", msg)
+	}
+	e := msg.Embeds[0]
+	if e.Title != "[1] New event: Connection refused" || e.Url != "http://localhost:2017/details/7" || e.Color != 0xfb8c00 {
+		t.Errorf("embed: %+v", e)
+	}
+	if e.Timestamp != "2023-11-14T22:13:20Z" {
+		t.Errorf("timestamp %s", e.Timestamp)
+	}
+	if len(e.Fields) != 3 || e.Fields[0] != (discordField{"Level", "warning", true}) {
+		t.Errorf("fields: %+v", e.Fields)
+	}
+}
+
+func TestTeams(t *testing.T) {
+	s, bodies := hook(t)
+	if err := notifier(t, config.Notify{Type: Teams, Url: s.URL}).Notify(testStatus()); err != nil {
+		t.Fatal(err)
+	}
+
+	var card teamsCard
+	received(t, bodies, &card)
+	if card.Type != "MessageCard" || card.ThemeColor != "fb8c00" || card.Title != "[1] New event: Connection refused" || card.Summary != card.Title {
+		t.Fatalf("card: %+v", card)
+	}
+	if len(card.Sections) != 1 || len(card.Sections[0].Facts) != 3 || card.Sections[0].Facts[0] != (teamsFact{"Level", "warning"}) {
+		t.Errorf("sections: %+v", card.Sections)
+	}
+	if len(card.PotentialAction) != 1 || card.PotentialAction[0].Targets[0].Uri != "http://localhost:2017/details/7" {
+		t.Errorf("actions: %+v", card.PotentialAction)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer s.Close()
+
+	err := notifier(t, config.Notify{Type: Slack, Url: s.URL}).Notify(testStatus())
+	if err == nil || err.Error() != "webhook responded 403 Forbidden: invalid_token" {
+		t.Fatalf("error: %v", err)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	s, bodies := hook(t)
+	settings := &config.Config{Project: []config.Project{{
+		Id:     "1",
+		Notify: []config.Notify{{Type: Slack, Url: s.URL, Levels: []string{"Error", "fatal"}}},
+	}}}
+
+	repo := storage.NewMemory()
+	hooks, err := webhook.NewSender(nil, repo, "http://localhost:2017")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDispatcher(settings, "http://localhost:2017", nil, nil, repo, hooks, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.Start(ctx)
+
+	// the exception type is not the level, a warning is left out
+	warning := testStatus()
+	if err := d.Notify(warning); err != nil {
+		t.Fatal(err)
+	}
+	failure := testStatus()
+	failure.Severity = "error"
+	failure.Message = "Database is down"
+	if err := d.Notify(failure); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg slackMessage
+	received(t, bodies, &msg)
+	if a := msg.Attachments[0]; a.Title != "[1] New event: Database is down" || a.Color != "#e53935" {
+		t.Fatalf("notified: %+v", a)
+	}
+	select {
+	case body := <-bodies:
+		t.Fatalf("notified: %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
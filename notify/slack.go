@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"net/http"
+
+	"github.com/scr34m/proof/parser"
+)
+
+// slack posts message attachments, Mattermost understands the same format
+type slack struct {
+	url        string
+	channel    string
+	siteUrl    string
+	client     *http.Client
+	mattermost bool
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Fallback  string       `json:"fallback"`
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link"`
+	Text      string       `json:"text,omitempty"`
+	Fields    []slackField `json:"fields"`
+	Footer    string       `json:"footer"`
+	Ts        int64        `json:"ts"`
+}
+
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+func (n *slack) Notify(status *parser.ProcessStatus) error {
+	return n.send(newMessage(status, n.siteUrl))
+}
+
+func (n *slack) send(m message) error {
+	a := slackAttachment{
+		Fallback:  m.title + " " + m.link,
+		Color:     m.color,
+		Title:     m.title,
+		TitleLink: m.link,
+		Footer:    "Proof",
+		Ts:        m.time.Unix(),
+	}
+	if m.stacktrace != "" {
+		a.Text = "```\n" + m.stacktrace + "\n```"
+	}
+	for _, f := range m.fields {
+		a.Fields = append(a.Fields, slackField{Title: f[0], Value: f[1], Short: true})
+	}
+
+	msg := slackMessage{Channel: n.channel, Attachments: []slackAttachment{a}}
+	if n.mattermost {
+		msg.Username = "Proof"
+	}
+	return post(n.client, n.url, msg)
+}
@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/storage"
+)
+
+var ErrToken = errors.New("invalid unsubscribe link")
+
+// Modes are the email preferences a user may choose
+var Modes = []string{storage.SubscribeAll, storage.SubscribeNew, storage.SubscribeRegression, storage.SubscribeNone}
+
+// wants tells if the preference asks for the event, without one the new and
+// regressed events are emailed
+func wants(mode string, status *parser.ProcessStatus) bool {
+	switch mode {
+	case storage.SubscribeAll:
+		return true
+	case storage.SubscribeNew:
+		return status.IsNew
+	case storage.SubscribeRegression:
+		return status.IsRegression
+	case storage.SubscribeNone:
+		return false
+	}
+	return status.IsNew || status.IsRegression
+}
+
+// mailNotifier emails the enabled users subscribed to the event of the
+// project, the preference of a user for the issue overrides the one for the
+// project. In the silent projects the users get the emails they asked for
+// only, the alert rules email the others. With a window the events wait for
+// the digest of the recipient
+type mailNotifier struct {
+	mailer  *mail.Mailer
+	users   storage.UserStore
+	repo    storage.Storage
+	secret  string
+	siteUrl string
+	silent  []string
+	window  time.Duration
+}
+
+// recipients returns the users who want the email of the event
+func (n *mailNotifier) recipients(status *parser.ProcessStatus) ([]string, error) {
+	subs, err := n.repo.Subscriptions().List(status.Project, status.GroupId)
+	if err != nil {
+		return nil, err
+	}
+
+	project := make(map[string]string)
+	issue := make(map[string]string)
+	for _, s := range subs {
+		if s.GroupId == 0 {
+			project[s.Email] = s.Mode
+		} else {
+			issue[s.Email] = s.Mode
+		}
+	}
+
+	var to []string
+	for _, email := range n.users.Recipients() {
+		mode, ok := issue[email]
+		if !ok {
+			mode, ok = project[email]
+		}
+		if !ok && contains(n.silent, status.Project) {
+			continue
+		}
+		if wants(mode, status) {
+			to = append(to, email)
+		}
+	}
+	return to, nil
+}
+
+// unsubscribe returns the link of stopping the emails of the project
+func (n *mailNotifier) unsubscribe(email string, projectId string) string {
+	v := url.Values{}
+	v.Set("email", email)
+	v.Set("project", projectId)
+	v.Set("token", Token(n.secret, email, projectId))
+	return strings.TrimRight(n.siteUrl, "/") + "/unsubscribe?" + v.Encode()
+}
+
+// mailing emails the event to each recipient with their own unsubscribe
+// link, or adds it to their digests
+type mailing struct {
+	n  *mailNotifier
+	to []string
+}
+
+func (m *mailing) Notify(status *parser.ProcessStatus) error {
+	if m.n.window > 0 {
+		return m.collect(status)
+	}
+
+	var failed []string
+	for _, email := range m.to {
+		if err := m.send(email, status); err != nil {
+			failed = append(failed, email+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (m *mailing) send(email string, status *parser.ProcessStatus) (err error) {
+	// the mailer panics on failure
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	m.n.mailer.Event(email, m.n.unsubscribe(email, status.Project), status)
+	return nil
+}
+
+// Token returns the signature of the unsubscribe link of the user and the
+// project
+func Token(secret string, email string, projectId string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(email + "\x00" + projectId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Unsubscribe stops the emails of the project for the user of the link, the
+// link of an unknown user is invalid
+func (d *Dispatcher) Unsubscribe(email string, projectId string, token string) error {
+	if d.secret == "" || !hmac.Equal([]byte(token), []byte(Token(d.secret, email, projectId))) {
+		return ErrToken
+	}
+	if d.mail == nil || !contains(d.mail.users.Recipients(), email) {
+		return ErrToken
+	}
+	return d.repo.Subscriptions().Set(storage.Subscription{Email: email, ProjectId: projectId, Mode: storage.SubscribeNone})
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/scr34m/proof/parser"
+)
+
+// teams posts a message card to an incoming webhook connector
+type teams struct {
+	url     string
+	siteUrl string
+	client  *http.Client
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type teamsSection struct {
+	Facts []teamsFact `json:"facts"`
+	Text  string      `json:"text,omitempty"`
+}
+
+type teamsTarget struct {
+	Os  string `json:"os"`
+	Uri string `json:"uri"`
+}
+
+type teamsAction struct {
+	Type    string        `json:"@type"`
+	Name    string        `json:"name"`
+	Targets []teamsTarget `json:"targets"`
+}
+
+type teamsCard struct {
+	Type            string         `json:"@type"`
+	Context         string         `json:"@context"`
+	ThemeColor      string         `json:"themeColor"`
+	Summary         string         `json:"summary"`
+	Title           string         `json:"title"`
+	Sections        []teamsSection `json:"sections"`
+	PotentialAction []teamsAction  `json:"potentialAction"`
+}
+
+func (n *teams) Notify(status *parser.ProcessStatus) error {
+	return n.send(newMessage(status, n.siteUrl))
+}
+
+func (n *teams) send(m message) error {
+	s := teamsSection{}
+	for _, f := range m.fields {
+		s.Facts = append(s.Facts, teamsFact{Name: f[0], Value: f[1]})
+	}
+	if m.stacktrace != "" {
+		s.Text = "<pre>" + html.EscapeString(m.stacktrace) + "</pre>"
+	}
+
+	card := teamsCard{
+		Type:       "MessageCard",
+		Context:    "http://schema.org/extensions",
+		ThemeColor: strings.TrimPrefix(m.color, "#"),
+		Summary:    m.title,
+		Title:      m.title,
+		Sections:   []teamsSection{s},
+		PotentialAction: []teamsAction{
+			{Type: "OpenUri", Name: "Details", Targets: []teamsTarget{{Os: "default", Uri: m.link}}},
+		},
+	}
+	return post(n.client, n.url, card)
+}
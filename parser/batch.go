@@ -0,0 +1,176 @@
+package parser
+
+import (
+	"sort"
+	"time"
+
+	"github.com/scr34m/proof/storage"
+)
+
+// batchEvent is a loaded event of the batch
+type batchEvent struct {
+	sentry   *Sentry
+	group    storage.Group
+	lastSeen time.Time
+	frames   []Frame
+}
+
+// batchGroup collects the events of the batch with the same project and
+// checksum
+type batchGroup struct {
+	key    string
+	id     int64
+	events []int
+	// lastSeen is the latest time of the events
+	lastSeen time.Time
+}
+
+// ProcessBatch stores the loaded events in one transaction. The counters of
+// a group are updated once for its events, the events and the payloads are
+// inserted with multi-row statements. The statuses are in the order of the
+// batch, the sampler counts the events once they are committed
+func ProcessBatch(repo storage.Storage, batch []*Sentry) ([]*ProcessStatus, error) {
+	events := make([]batchEvent, len(batch))
+	groups := make(map[string]*batchGroup)
+	for i, s := range batch {
+		var url string
+		if s.protocol == "7" {
+			url = s.Packet.InterfaceHttp7.Url
+		} else {
+			url = s.Packet.InterfaceHttp.Url
+		}
+
+		e := batchEvent{
+			sentry:   s,
+			lastSeen: s.GetLastSeen(),
+			frames:   s.GetFrames(),
+			group: storage.Group{
+				Logger:     s.Packet.Logger,
+				Level:      s.Packet.Level,
+				Message:    s.Packet.Message,
+				Checksum:   s.GetChecksum(),
+				ProjectId:  s.Packet.Project,
+				ServerName: s.Packet.ServerName,
+				Url:        url,
+				Site:       s.Packet.Site,
+				Platform:   s.Packet.Platform,
+			},
+		}
+		events[i] = e
+
+		key := e.group.ProjectId + "\x00" + e.group.Checksum
+		g, ok := groups[key]
+		if !ok {
+			g = &batchGroup{key: key}
+			groups[key] = g
+		}
+		g.events = append(g.events, i)
+		if e.lastSeen.After(g.lastSeen) {
+			g.lastSeen = e.lastSeen
+		}
+	}
+
+	// the same order in every worker, the locked group rows can not deadlock
+	var ordered []*batchGroup
+	for _, g := range groups {
+		ordered = append(ordered, g)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].key < ordered[j].key })
+
+	statuses := make([]*ProcessStatus, len(batch))
+	err := repo.Atomic(func(repo storage.Storage) error {
+		var stored []storage.Event
+		var payloads []storage.Payload
+		var received []time.Time
+		hashes := make(map[string]bool)
+
+		for _, g := range ordered {
+			first := events[g.events[0]]
+			group := first.group
+
+			// the group row stays locked until the events and their payloads are
+			// stored
+			new, err := repo.Groups().Upsert(&group, first.lastSeen)
+			if err != nil {
+				return err
+			}
+			g.id = group.Id
+
+			count, skipped := 0, 0
+			for n, i := range g.events {
+				e := events[i]
+				s := e.sentry
+
+				var regression bool
+				var sampled string
+				if n != 0 || !new {
+					// the first event reopens a resolved group, an ignored one
+					// stays ignored
+					regression = n == 0 && group.Status == storage.GroupResolved
+
+					// the first event and regressions are always stored
+					if s.Sampler != nil && !regression {
+						sampled = s.Sampler.Keep(group.Id, &s.Packet, n)
+					}
+					count++
+				}
+
+				// the counter as the events before left it
+				seen := group.Seen + int64(count)
+				ps := &ProcessStatus{GroupId: group.Id, Project: e.group.ProjectId, Platform: e.group.Platform, Url: e.group.Url, Seen: seen, LastSeen: e.lastSeen, Message: s.Packet.Message, ServerName: s.Packet.ServerName, Site: s.Packet.Site, Level: s.Packet.Level, Severity: s.Packet.GetSeverity(), Environment: s.Packet.Environment, Release: s.Packet.Release, Tags: s.Packet.GetTags(), User: s.Packet.GetUser(), Frames: e.frames}
+				statuses[i] = ps
+
+				if sampled != "" {
+					ps.Sampled = true
+					skipped++
+					if err := repo.Outcomes().Add(s.Packet.Project, sampled); err != nil {
+						return err
+					}
+					continue
+				}
+
+				ps.IsNew = n == 0 && new
+				ps.IsRegression = regression
+
+				stored = append(stored, storage.Event{DataId: s.hash, GroupId: group.Id, Message: s.Packet.Message, Checksum: e.group.Checksum})
+				if !hashes[s.hash] {
+					hashes[s.hash] = true
+					payloads = append(payloads, storage.Payload{Id: s.hash, Data: s.payload, Protocol: s.protocol})
+					received = append(received, e.lastSeen)
+				}
+			}
+
+			// the latest event describes the group
+			if count > 0 {
+				last := events[g.events[len(g.events)-1]].group
+				last.Id = group.Id
+				err = repo.Groups().Touch(last, g.lastSeen, count, skipped)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(stored) > 0 {
+			if err := repo.Events().CreateMany(stored); err != nil {
+				return err
+			}
+		}
+		if len(payloads) > 0 {
+			return repo.Payloads().StoreMany(payloads, received)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the spike protection counts the committed events only
+	for _, g := range ordered {
+		first := events[g.events[0]]
+		if first.sentry.Sampler != nil {
+			first.sentry.Sampler.Count(g.id, first.group.ProjectId, len(g.events))
+		}
+	}
+	return statuses, nil
+}
@@ -0,0 +1,335 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/shared"
+	"github.com/scr34m/proof/storage"
+)
+
+// dropSampler drops every event it is asked about
+type dropSampler struct {
+	calls   int
+	pending []int
+	counted map[int64]int
+}
+
+func (d *dropSampler) Keep(groupId int64, p *Packet, pending int) string {
+	d.calls++
+	d.pending = append(d.pending, pending)
+	return "sampled"
+}
+
+func (d *dropSampler) Count(groupId int64, projectId string, n int) {
+	if d.counted == nil {
+		d.counted = make(map[int64]int)
+	}
+	d.counted[groupId] += n
+}
+
+// failedCommit fails the transactions after they are done
+type failedCommit struct {
+	storage.Storage
+}
+
+func (f failedCommit) Atomic(fn func(storage.Storage) error) error {
+	if err := f.Storage.Atomic(fn); err != nil {
+		return err
+	}
+	return errors.New("commit failed")
+}
+
+// event loads a protocol 7 envelope of the project, the exception type is
+// the group of the event
+func event(t *testing.T, projectId string, kind string, message string, timestamp float64, sampler Sampler) *Sentry {
+	t.Helper()
+
+	item, err := json.Marshal(M{
+		"platform":  "go",
+		"level":     "error",
+		"timestamp": timestamp,
+		"exception": M{"values": A{M{"type": kind, "value": message}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := "{}\n{\"type\":\"event\"}\n" + string(item) + "\n"
+
+	s := &Sentry{Sampler: sampler}
+	if err := s.Load(shared.QueuePacket{Body: []byte(body), Protocol: "7", ProjectId: projectId}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func process(t *testing.T, repo storage.Storage, batch ...*Sentry) []*ProcessStatus {
+	t.Helper()
+
+	statuses, err := ProcessBatch(repo, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(batch) {
+		t.Fatalf("%d statuses of %d events", len(statuses), len(batch))
+	}
+	return statuses
+}
+
+func group(t *testing.T, repo storage.Storage, id int64) storage.Group {
+	t.Helper()
+
+	g, err := repo.Groups().Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// events returns the stored event ids of the group, newest first
+func events(t *testing.T, repo storage.Storage, groupId int64) []int64 {
+	t.Helper()
+
+	e, err := repo.Events().Latest(groupId)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int64{e.Id}
+	for {
+		id, err := repo.Events().Older(groupId, ids[len(ids)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id == 0 {
+			return ids
+		}
+		ids = append(ids, id)
+	}
+}
+
+func TestProcessBatchNewGroup(t *testing.T) {
+	repo := storage.NewMemory()
+
+	batch := []*Sentry{
+		event(t, "1", "Error", "a", 1000, nil),
+		event(t, "1", "Error", "b", 1002, nil),
+		event(t, "1", "Error", "a", 1000, nil),
+	}
+	statuses := process(t, repo, batch...)
+
+	id := statuses[0].GroupId
+	for i, s := range statuses {
+		if s.GroupId != id {
+			t.Fatalf("status %d of group %d instead of %d", i, s.GroupId, id)
+		}
+		if s.Seen != int64(i+1) {
+			t.Errorf("status %d seen %d", i, s.Seen)
+		}
+		if s.IsNew != (i == 0) || s.IsRegression || s.Sampled {
+			t.Errorf("status %d: %+v", i, s)
+		}
+	}
+	if statuses[1].Message != "b" || statuses[1].Project != "1" || statuses[1].Severity != "error" {
+		t.Errorf("status of the second event: %+v", statuses[1])
+	}
+
+	g := group(t, repo, id)
+	if g.Seen != 3 || g.Sampled != 0 || g.Status != 0 {
+		t.Errorf("group counters: %+v", g)
+	}
+	if g.Message != "a" {
+		t.Errorf("group described by the message %q instead of the latest event", g.Message)
+	}
+	if last := time.Unix(1002, 0).Format("2006-01-02 15:04:05"); g.LastSeen != last {
+		t.Errorf("group last seen %s instead of %s", g.LastSeen, last)
+	}
+
+	if n := len(events(t, repo, id)); n != 3 {
+		t.Errorf("%d stored events", n)
+	}
+	// the same payload is stored once
+	if batch[0].hash != batch[2].hash || batch[0].hash == batch[1].hash {
+		t.Fatal("payload hashes")
+	}
+	for _, s := range batch[:2] {
+		if _, err := repo.Payloads().Get(s.hash); err != nil {
+			t.Errorf("payload: %v", err)
+		}
+	}
+}
+
+func TestProcessBatchSampled(t *testing.T) {
+	repo := storage.NewMemory()
+	sampler := &dropSampler{}
+
+	statuses := process(t, repo,
+		event(t, "1", "Error", "a", 1000, sampler),
+		event(t, "1", "Error", "b", 1001, sampler),
+		event(t, "1", "Error", "c", 1002, sampler),
+	)
+
+	// the first event of a new group is always stored, but counted
+	if sampler.calls != 2 || !reflect.DeepEqual(sampler.pending, []int{1, 2}) {
+		t.Errorf("sampler asked %d times, after %v events", sampler.calls, sampler.pending)
+	}
+	if sampler.counted[statuses[0].GroupId] != 3 {
+		t.Errorf("sampler counted %v", sampler.counted)
+	}
+	if !statuses[0].IsNew || statuses[0].Sampled {
+		t.Errorf("status of the first event: %+v", statuses[0])
+	}
+	for i, s := range statuses[1:] {
+		if !s.Sampled || s.IsNew || s.IsRegression || s.Seen != int64(i+2) {
+			t.Errorf("status %d: %+v", i+1, s)
+		}
+	}
+
+	id := statuses[0].GroupId
+	g := group(t, repo, id)
+	if g.Seen != 3 || g.Sampled != 2 {
+		t.Errorf("group counters: %+v", g)
+	}
+	if n := len(events(t, repo, id)); n != 1 {
+		t.Errorf("%d stored events", n)
+	}
+
+	totals, err := repo.Outcomes().Totals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals["1"] != 2 {
+		t.Errorf("outcomes: %v", totals)
+	}
+}
+
+func TestProcessBatchRegression(t *testing.T) {
+	repo := storage.NewMemory()
+
+	statuses := process(t, repo, event(t, "1", "Error", "a", 1000, nil))
+	id := statuses[0].GroupId
+	if err := repo.Groups().SetStatus(id, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	sampler := &dropSampler{}
+	statuses = process(t, repo,
+		event(t, "1", "Error", "b", 1001, sampler),
+		event(t, "1", "Error", "c", 1002, sampler),
+	)
+
+	// regressions are always stored, the events after it are sampled
+	if s := statuses[0]; !s.IsRegression || s.IsNew || s.Sampled || s.Seen != 2 {
+		t.Errorf("status of the regression: %+v", s)
+	}
+	if s := statuses[1]; s.IsRegression || !s.Sampled || s.Seen != 3 {
+		t.Errorf("status after the regression: %+v", s)
+	}
+	if sampler.calls != 1 || sampler.counted[id] != 2 {
+		t.Errorf("sampler asked %d times, counted %v", sampler.calls, sampler.counted)
+	}
+
+	g := group(t, repo, id)
+	if g.Status != 0 || g.Seen != 3 || g.Sampled != 1 {
+		t.Errorf("reopened group: %+v", g)
+	}
+
+	// the group is open again
+	statuses = process(t, repo, event(t, "1", "Error", "d", 1003, nil))
+	if s := statuses[0]; s.IsRegression || s.IsNew || s.Seen != 4 {
+		t.Errorf("status of an open group: %+v", s)
+	}
+	if n := len(events(t, repo, id)); n != 3 {
+		t.Errorf("%d stored events", n)
+	}
+}
+
+func TestProcessBatchGroups(t *testing.T) {
+	repo := storage.NewMemory()
+
+	existing := process(t, repo, event(t, "1", "TypeError", "x", 1000, nil))[0].GroupId
+
+	statuses := process(t, repo,
+		event(t, "1", "Error", "a", 1001, nil),
+		event(t, "1", "TypeError", "b", 1002, nil),
+		event(t, "2", "Error", "c", 1003, nil),
+		event(t, "1", "Error", "d", 1004, nil),
+		event(t, "1", "TypeError", "e", 1005, nil),
+	)
+
+	// the statuses are in the order of the batch
+	for i, message := range []string{"a", "b", "c", "d", "e"} {
+		if statuses[i].Message != message {
+			t.Fatalf("status %d of the message %q", i, statuses[i].Message)
+		}
+	}
+
+	plain, other := statuses[0].GroupId, statuses[2].GroupId
+	if statuses[1].GroupId != existing || statuses[4].GroupId != existing || statuses[3].GroupId != plain {
+		t.Fatalf("groups of the events: %+v", statuses)
+	}
+	if plain == existing || other == plain || other == existing {
+		t.Fatalf("the projects and types share a group: %d %d %d", existing, plain, other)
+	}
+
+	want := []struct {
+		isNew bool
+		seen  int64
+	}{{true, 1}, {false, 2}, {true, 1}, {false, 2}, {false, 3}}
+	for i, w := range want {
+		if statuses[i].IsNew != w.isNew || statuses[i].Seen != w.seen {
+			t.Errorf("status %d: new %v seen %d", i, statuses[i].IsNew, statuses[i].Seen)
+		}
+	}
+
+	for id, seen := range map[int64]int64{existing: 3, plain: 2, other: 1} {
+		if g := group(t, repo, id); g.Seen != seen {
+			t.Errorf("group %d seen %d instead of %d", id, g.Seen, seen)
+		}
+	}
+}
+
+func TestProcessBatchFailedCommit(t *testing.T) {
+	repo := storage.NewMemory()
+	sampler := &dropSampler{}
+
+	id := process(t, repo, event(t, "1", "Error", "a", 1000, sampler))[0].GroupId
+	if sampler.counted[id] != 1 {
+		t.Fatalf("sampler counted %v", sampler.counted)
+	}
+
+	batch := []*Sentry{event(t, "1", "Error", "b", 1001, sampler), event(t, "1", "Error", "c", 1002, sampler)}
+	if _, err := ProcessBatch(failedCommit{repo}, batch); err == nil {
+		t.Fatal("the failed commit is not reported")
+	}
+
+	// the events are stored again, the sampler counts them once
+	process(t, repo, batch...)
+	if sampler.counted[id] != 3 {
+		t.Errorf("sampler counted %v", sampler.counted)
+	}
+}
+
+func TestProcessBatchIgnored(t *testing.T) {
+	repo := storage.NewMemory()
+
+	id := process(t, repo, event(t, "1", "Error", "a", 1000, nil))[0].GroupId
+	if err := repo.Groups().SetStatus(id, storage.GroupIgnored); err != nil {
+		t.Fatal(err)
+	}
+
+	// counted, but an ignored group is not reopened
+	statuses := process(t, repo, event(t, "1", "Error", "b", 1001, nil))
+	if s := statuses[0]; s.IsRegression || s.IsNew || s.Seen != 2 {
+		t.Errorf("status of an ignored group: %+v", s)
+	}
+	if g := group(t, repo, id); g.Status != storage.GroupIgnored || g.Seen != 2 {
+		t.Errorf("ignored group: %+v", g)
+	}
+}
@@ -11,7 +11,26 @@ import (
  * https://github.com/getsentry/sentry/blob/6.4.4/src/sentry/data/samples/python.json
  */
 
+// DefaultFingerprint is the fingerprint entry of the computed checksum
+const DefaultFingerprint = "{{ default }}"
+
+// GetChecksum returns the grouping checksum, the fingerprint of a security
+// report replaces the computed one
 func (s *Sentry) GetChecksum() string {
+	if s.Packet.Platform == SecurityPlatform && len(s.Packet.Fingerprint) > 0 {
+		parts := make([]string, len(s.Packet.Fingerprint))
+		for i, part := range s.Packet.Fingerprint {
+			if part == DefaultFingerprint {
+				part = s.computedChecksum()
+			}
+			parts[i] = part
+		}
+		return GetMD5Hash(strings.Join(parts, "\n"))
+	}
+	return s.computedChecksum()
+}
+
+func (s *Sentry) computedChecksum() string {
 	if s.protocol == "7" {
 		return getChecksum7(s.Packet)
 	} else {
@@ -39,8 +58,12 @@ func getChecksum6(packet Packet) string {
 }
 
 func getChecksum7(packet Packet) string {
-	content := getContentStacktrace(packet.InterfaceException7.Values[0].Stacktrace.Frames)
-	content += packet.InterfaceException7.Values[0].Type
+	e, ok := packet.exception()
+	if !ok {
+		return GetMD5Hash(packet.Message)
+	}
+	content := getContentStacktrace(e.Stacktrace.Frames)
+	content += e.Type
 	return GetMD5Hash(content)
 }
 
@@ -64,7 +87,9 @@ func getContentFrame(sf StackFrame) string {
 	} else if sf.Function != "" {
 		output += sf.Function
 	} else if sf.LineNo > 0 {
-		output += fmt.Sprintf("%s", sf.LineNo)
+		// the output of the former %s verb, the checksums of the stored
+		// groups depend on it
+		output += fmt.Sprintf("%%!s(float64=%v)", sf.LineNo)
 	}
 	return output
 }
@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+/**
+ * https://develop.sentry.dev/sdk/envelopes/
+ */
+
+var (
+	ErrInvalidDsn = errors.New("invalid dsn in envelope header")
+	ErrEnvelope   = errors.New("malformed envelope")
+	ErrNoEvent    = errors.New("no event in envelope")
+)
+
+type EnvelopeHeader struct {
+	EventId string `json:"event_id"`
+	Dsn     string `json:"dsn"`
+}
+
+type envelopeItemHeader struct {
+	Type   string `json:"type"`
+	Length *int   `json:"length"`
+}
+
+// EnvelopeItem is an item of a plain envelope with its header line
+type EnvelopeItem struct {
+	Type    string
+	Header  []byte
+	Payload []byte
+}
+
+// GetEnvelopeDsn returns the public key and the project id from the dsn of
+// the envelope header
+func GetEnvelopeDsn(envelope []byte) (key string, projectId string, err error) {
+	p, err := ReadEnvelope(envelope)
+	if err != nil {
+		return "", "", err
+	}
+
+	line := string(p)
+	if i := strings.Index(line, "\n"); i != -1 {
+		line = line[:i]
+	}
+
+	var header EnvelopeHeader
+	err = json.Unmarshal([]byte(line), &header)
+	if err != nil {
+		return "", "", err
+	}
+
+	dsn, err := url.Parse(header.Dsn)
+	if err != nil || dsn.User == nil {
+		return "", "", ErrInvalidDsn
+	}
+
+	key = dsn.User.Username()
+	projectId = path.Base(dsn.Path)
+	if key == "" || projectId == "" || projectId == "/" || projectId == "." {
+		return "", "", ErrInvalidDsn
+	}
+	return key, projectId, nil
+}
+
+// HasEnvelopeEvent reports whether the envelope carries an event item, other
+// items like sessions or client reports are not stored
+func HasEnvelopeEvent(envelope []byte) bool {
+	p, err := ReadEnvelope(envelope)
+	if err != nil {
+		return false
+	}
+	_, err = EnvelopeEvent(p)
+	return err == nil
+}
+
+// EnvelopeEvent returns the payload of the event item of the plain envelope,
+// whatever position it has
+func EnvelopeEvent(p []byte) ([]byte, error) {
+	_, items, err := SplitEnvelope(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.Type == "event" {
+			return item.Payload, nil
+		}
+	}
+	return nil, ErrNoEvent
+}
+
+// SplitEnvelope returns the header line and the items of the plain envelope.
+// A payload is read by the length of its item header when it is set, up to
+// the end of the line otherwise
+func SplitEnvelope(p []byte) ([]byte, []EnvelopeItem, error) {
+	header, rest := nextLine(p)
+
+	var items []EnvelopeItem
+	for len(rest) > 0 {
+		var line []byte
+		line, rest = nextLine(rest)
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var h envelopeItemHeader
+		if err := json.Unmarshal(line, &h); err != nil {
+			return nil, nil, ErrEnvelope
+		}
+		item := EnvelopeItem{Type: h.Type, Header: line}
+		if h.Length != nil {
+			n := *h.Length
+			if n < 0 || n > len(rest) {
+				return nil, nil, ErrEnvelope
+			}
+			item.Payload, rest = rest[:n], rest[n:]
+			if len(rest) > 0 && rest[0] == '\n' {
+				rest = rest[1:]
+			}
+		} else {
+			item.Payload, rest = nextLine(rest)
+		}
+		items = append(items, item)
+	}
+	return header, items, nil
+}
+
+// JoinEnvelope returns the plain envelope of the header line and the items
+func JoinEnvelope(header []byte, items []EnvelopeItem) []byte {
+	var b bytes.Buffer
+	b.Write(header)
+	b.WriteByte('\n')
+	for _, item := range items {
+		b.Write(item.Header)
+		b.WriteByte('\n')
+		b.Write(item.Payload)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+func nextLine(p []byte) ([]byte, []byte) {
+	i := bytes.IndexByte(p, '\n')
+	if i == -1 {
+		return p, nil
+	}
+	return p[:i], p[i+1:]
+}
@@ -5,21 +5,26 @@ import (
 )
 
 func (s *Sentry) GetFrames() []Frame {
-	if s.protocol == "7" {
-		return GetFramesRaw(s.Packet.InterfaceException7.Values[0].Stacktrace.Frames)
-	} else {
-		return GetFramesRaw(s.Packet.InterfaceStacktrace.Frames)
-	}
+	return s.Packet.GetFrames(s.protocol)
 }
 
 func (p *Packet) GetFrames(protocol string) []Frame {
 	if protocol == "7" {
-		return GetFramesRaw(p.InterfaceException7.Values[0].Stacktrace.Frames)
+		e, _ := p.exception()
+		return GetFramesRaw(e.Stacktrace.Frames)
 	} else {
 		return GetFramesRaw(p.InterfaceStacktrace.Frames)
 	}
 }
 
+func (p *Packet) GetRequest(protocol string) Request {
+	if protocol == "7" {
+		return p.InterfaceHttp7
+	} else {
+		return p.InterfaceHttp
+	}
+}
+
 func GetFramesRaw(sframes []StackFrame) []Frame {
 	var frames []Frame
 	for _, f := range sframes {
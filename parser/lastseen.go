@@ -4,14 +4,17 @@ import (
 	"time"
 )
 
+// GetLastSeen returns the time of the event, the receiving time when the
+// client sent none
 func (s *Sentry) GetLastSeen() time.Time {
-	var lastSeen time.Time
-	if s.protocol == "7" {
-		sec := int64(s.Packet.Timestamp.(float64))
-		nsec := int64((s.Packet.Timestamp.(float64) - float64(sec)) * 1e9)
+	switch t := s.Packet.Timestamp.(type) {
+	case float64:
+		sec := int64(t)
+		nsec := int64((t - float64(sec)) * 1e9)
 		return time.Unix(sec, nsec)
-	} else {
-		lastSeen, _ = time.Parse(time.RFC3339, s.Packet.Timestamp.(string))
+	case string:
+		lastSeen, _ := time.Parse(time.RFC3339, t)
 		return lastSeen
 	}
+	return time.Now()
 }
@@ -0,0 +1,237 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/**
+ * https://www.w3.org/TR/CSP3/#deprecated-serialize-violation
+ * https://www.w3.org/TR/reporting-1/#media-type-registration
+ * https://www.w3.org/TR/network-error-logging/#generate-a-network-error-report
+ * https://datatracker.ietf.org/doc/html/draft-ietf-httpbis-expect-ct-08#section-3.1
+ */
+
+const SecurityPlatform = "security"
+
+var ErrUnknownReport = errors.New("unknown security report")
+
+type cspReport struct {
+	DocumentUri        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	BlockedUri         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         I      `json:"line-number"`
+	ColumnNumber       I      `json:"column-number"`
+	StatusCode         I      `json:"status-code"`
+	ScriptSample       string `json:"script-sample"`
+}
+
+type expectCTReport struct {
+	DateTime                string `json:"date-time"`
+	Hostname                string `json:"hostname"`
+	Port                    I      `json:"port"`
+	EffectiveExpirationDate string `json:"effective-expiration-date"`
+}
+
+type reportingReport struct {
+	Type      string `json:"type"`
+	Age       int64  `json:"age"`
+	Url       string `json:"url"`
+	UserAgent string `json:"user_agent"`
+	Body      M      `json:"body"`
+}
+
+type securityEvent struct {
+	Timestamp   float64   `json:"timestamp"`
+	Platform    string    `json:"platform"`
+	Fingerprint []string  `json:"fingerprint"`
+	Request     Request   `json:"request"`
+	Exception   Exception `json:"exception"`
+	Contexts    M         `json:"contexts"`
+}
+
+// ParseSecurity converts a browser security report body to gzipped protocol 7
+// envelopes, one for each report, so they can travel the normal ingest path
+func ParseSecurity(contentType string, body []byte, userAgent string) ([][]byte, error) {
+	if i := strings.Index(contentType, ";"); i != -1 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(strings.ToLower(contentType))
+
+	var events []securityEvent
+	now := time.Now()
+
+	switch contentType {
+	case "application/reports+json":
+		var reports []reportingReport
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+		for _, r := range reports {
+			e, err := reportingEvent(r, now)
+			if err != nil {
+				continue
+			}
+			events = append(events, e)
+		}
+	case "application/expect-ct-report+json":
+		var r struct {
+			Report expectCTReport `json:"expect-ct-report"`
+		}
+		if err := json.Unmarshal(body, &r); err != nil {
+			return nil, err
+		}
+		events = append(events, expectCTEvent(r.Report, now, userAgent))
+	default:
+		// application/csp-report, some browsers send application/json
+		var r struct {
+			Report cspReport `json:"csp-report"`
+		}
+		if err := json.Unmarshal(body, &r); err != nil {
+			return nil, err
+		}
+		events = append(events, cspEvent(r.Report, now, userAgent))
+	}
+
+	if len(events) == 0 {
+		return nil, ErrUnknownReport
+	}
+
+	var envelopes [][]byte
+	for _, e := range events {
+		envelope, err := securityEnvelope(e)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, nil
+}
+
+func reportingEvent(r reportingReport, now time.Time) (securityEvent, error) {
+	ts := now.Add(-time.Duration(r.Age) * time.Millisecond)
+
+	switch r.Type {
+	case "csp-violation":
+		c := cspReport{
+			DocumentUri:        stringValue(r.Body, "documentURL"),
+			Referrer:           stringValue(r.Body, "referrer"),
+			EffectiveDirective: stringValue(r.Body, "effectiveDirective"),
+			OriginalPolicy:     stringValue(r.Body, "originalPolicy"),
+			Disposition:        stringValue(r.Body, "disposition"),
+			BlockedUri:         stringValue(r.Body, "blockedURL"),
+			SourceFile:         stringValue(r.Body, "sourceFile"),
+			LineNumber:         r.Body["lineNumber"],
+			ColumnNumber:       r.Body["columnNumber"],
+			StatusCode:         r.Body["statusCode"],
+			ScriptSample:       stringValue(r.Body, "sample"),
+		}
+		if c.DocumentUri == "" {
+			c.DocumentUri = r.Url
+		}
+		return cspEvent(c, ts, r.UserAgent), nil
+	case "network-error":
+		kind := stringValue(r.Body, "type")
+		host := uriHost(r.Url)
+		return securityEvent{
+			Timestamp:   unixFloat(ts),
+			Platform:    SecurityPlatform,
+			Fingerprint: []string{"nel", kind, host},
+			Request:     securityRequest(r.Url, stringValue(r.Body, "referrer"), r.UserAgent),
+			Exception:   securityException("nel", "Network error '"+kind+"' on '"+host+"'"),
+			Contexts:    M{"nel": r.Body},
+		}, nil
+	}
+	return securityEvent{}, ErrUnknownReport
+}
+
+func cspEvent(r cspReport, ts time.Time, userAgent string) securityEvent {
+	directive := r.EffectiveDirective
+	if directive == "" {
+		directive = strings.SplitN(r.ViolatedDirective, " ", 2)[0]
+	}
+	host := uriHost(r.BlockedUri)
+
+	return securityEvent{
+		Timestamp:   unixFloat(ts),
+		Platform:    SecurityPlatform,
+		Fingerprint: []string{"csp", directive, host},
+		Request:     securityRequest(r.DocumentUri, r.Referrer, userAgent),
+		Exception:   securityException("csp", "Blocked '"+directive+"' from '"+host+"'"),
+		Contexts:    M{"csp": r},
+	}
+}
+
+func expectCTEvent(r expectCTReport, ts time.Time, userAgent string) securityEvent {
+	return securityEvent{
+		Timestamp:   unixFloat(ts),
+		Platform:    SecurityPlatform,
+		Fingerprint: []string{"expect-ct", r.Hostname},
+		Request:     securityRequest("https://"+r.Hostname, "", userAgent),
+		Exception:   securityException("expect-ct", "Expect-CT failed for '"+r.Hostname+"'"),
+		Contexts:    M{"expect-ct": r},
+	}
+}
+
+func securityRequest(uri string, referrer string, userAgent string) Request {
+	headers := M{}
+	if userAgent != "" {
+		headers["User-Agent"] = userAgent
+	}
+	if referrer != "" {
+		headers["Referer"] = referrer
+	}
+	return Request{Url: uri, Headers: headers}
+}
+
+func securityException(kind string, message string) Exception {
+	return Exception{Values: []Value{{Type: kind, Value: message}}}
+}
+
+func securityEnvelope(e securityEvent) ([]byte, error) {
+	item, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	z := gzip.NewWriter(&b)
+	z.Write([]byte("{}\n{\"type\":\"event\"}\n"))
+	z.Write(item)
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// uriHost returns the host of the uri, or the uri itself for keywords like
+// inline, eval or data
+func uriHost(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		if i := strings.Index(uri, ":"); i != -1 {
+			return uri[:i]
+		}
+		return uri
+	}
+	return u.Host
+}
+
+func stringValue(m M, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+func unixFloat(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
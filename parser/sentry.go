@@ -5,17 +5,16 @@ import (
 	"compress/gzip"
 	"compress/zlib"
 	"crypto/md5"
-	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"html/template"
 	"io/ioutil"
-	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/scr34m/proof/shared"
+	"github.com/scr34m/proof/storage"
 )
 
 type Parser interface {
@@ -23,15 +22,38 @@ type Parser interface {
 	Process() (ProcessStatus, error)
 }
 
+// Sampler returns the reason when the payload of an event should not be
+// stored, the group counters are updated anyway. Keep only decides, the
+// events are counted once they are committed, so a transaction tried again
+// does not count them twice
+type Sampler interface {
+	// Keep decides on the event after pending others of its group in the
+	// same batch
+	Keep(groupId int64, p *Packet, pending int) string
+	// Count adds the stored events of the group
+	Count(groupId int64, projectId string, n int)
+}
+
 type ProcessStatus struct {
 	GroupId      int64
+	Project      string
+	Platform     string
+	Url          string
+	Seen         int64
+	LastSeen     time.Time
 	Message      string
 	Site         string
 	ServerName   string
 	Level        string
+	Severity     string
+	Environment  string
+	Release      string
+	Tags         map[string]string
+	User         string
 	Frames       []Frame
 	IsNew        bool
 	IsRegression bool
+	Sampled      bool
 }
 
 type Frame struct {
@@ -47,7 +69,8 @@ type Frame struct {
 
 type Sentry struct {
 	Parser
-	Database  *sql.DB
+	Storage   storage.Storage
+	Sampler   Sampler
 	Packet    Packet
 	hash      string
 	payload   string
@@ -99,6 +122,7 @@ type StackFrame struct {
 type Packet struct {
 	ServerName          string     `json:"server_name"`                  // 4, 7
 	Environment         string     `json:"environment"`                  // 7
+	Release             string     `json:"release"`                      // 4, 7
 	Project             string     `json:"project"`                      // 4
 	Site                string     `json:"site"`                         // 4
 	Logger              string     `json:"logger"`                       // 4
@@ -113,7 +137,12 @@ type Packet struct {
 	InterfaceHttp7      Request    `json:"request"`                      // 7
 	InterfaceException7 Exception  `json:"exception"`                    // 7
 	Contexts            M          `json:"contexts"`                     // 7
+	Tags                I          `json:"tags"`                         // 4, 7
+	Fingerprint         []string   `json:"fingerprint"`                  // 7
 	Timestamp           I          `json:"timestamp"`                    // 4: string, 7: float
+	// Severity is the level sent by the client, Level is the exception type
+	// with protocol 7
+	Severity string `json:"-"`
 }
 
 func (s *Sentry) Load(qpacket shared.QueuePacket) error {
@@ -129,113 +158,11 @@ func (s *Sentry) Load(qpacket shared.QueuePacket) error {
 }
 
 func (s *Sentry) Process() (*ProcessStatus, error) {
-	checksum := s.GetChecksum()
-	lastSeen := s.GetLastSeen()
-	frames := s.GetFrames()
-
-	var url string
-	if s.protocol == "7" {
-		url = s.Packet.InterfaceHttp7.Url
-	} else {
-		url = s.Packet.InterfaceHttp.Url
-	}
-
-	stmt, err := s.Database.Prepare("SELECT id, status FROM `group` WHERE checksum = ? AND project_id = ?")
+	statuses, err := ProcessBatch(s.Storage, []*Sentry{s})
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
-
-	var groupId int64
-	var status int64
-	var new bool
-	var regression bool
-
-	err = stmt.QueryRow(checksum, s.Packet.Project).Scan(&groupId, &status)
-	if err == nil {
-		new = false
-
-		if status != 0 {
-			regression = true
-		}
-
-		stmt, err := s.Database.Prepare("UPDATE `group` SET last_seen = ?, seen = seen + 1, status = 0, logger = ?, `level` = ?, message = ?, project_id = ?, `server_name` = ?, url = ?, site = ?, platform = ? WHERE id = ?")
-		if err != nil {
-			return nil, err
-		}
-		defer stmt.Close()
-
-		_, err = stmt.Exec(lastSeen, s.Packet.Logger, s.Packet.Level, s.Packet.Message, s.Packet.Project, s.Packet.ServerName, url, s.Packet.Site, s.Packet.Platform, groupId)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		seen := 1
-		new = true
-		regression = false
-
-		stmt, err := s.Database.Prepare("INSERT INTO `group` (logger, `level`, message, checksum, seen, last_seen, first_seen, project_id, `server_name`, url, site, platform, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)")
-		if err != nil {
-			return nil, err
-		}
-		defer stmt.Close()
-
-		res, err := stmt.Exec(s.Packet.Logger, s.Packet.Level, s.Packet.Message, checksum, seen, lastSeen, lastSeen, s.Packet.Project,
-			s.Packet.ServerName, url, s.Packet.Site, s.Packet.Platform)
-		if err != nil {
-			return nil, err
-		}
-
-		groupId, err = res.LastInsertId()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	stmt, err = s.Database.Prepare("INSERT INTO event (data_id, group_id, message, checksum) VALUES (?, ?, ?, ?)")
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = stmt.Exec(s.hash, groupId, s.Packet.Message, checksum)
-	if err != nil {
-		return nil, err
-	}
-
-	err = s.storeData(lastSeen)
-	if err != nil {
-		return nil, err
-	}
-
-	ps := &ProcessStatus{GroupId: groupId, Message: s.Packet.Message, ServerName: s.Packet.ServerName, Site: s.Packet.Site, Level: s.Packet.Level, IsNew: new, IsRegression: regression, Frames: frames}
-	return ps, nil
-}
-
-func (s *Sentry) storeData(lastSeen time.Time) error {
-	stmt, err := s.Database.Prepare("SELECT id FROM `data` WHERE id = ?")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
-	var h string
-
-	err = stmt.QueryRow(s.hash).Scan(&h)
-	if err == nil {
-		return nil
-	}
-
-	stmt, err = s.Database.Prepare("INSERT INTO data (id, data, timestamp, protocol) VALUES (?, ?, ?, ?)")
-	if err != nil {
-		return err
-	}
-
-	_, err = stmt.Exec(s.hash, s.payload, lastSeen, s.protocol)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return statuses[0], nil
 }
 
 func Decode(payload string, protocol string, projectId string, v *Packet) error {
@@ -244,8 +171,9 @@ func Decode(payload string, protocol string, projectId string, v *Packet) error
 
 	if protocol == "7" {
 		p, err = readGzip(payload)
-		_, _, body := getParts(string(p))
-		p = []byte(body)
+		if err == nil {
+			p, err = EnvelopeEvent(p)
+		}
 	} else {
 		p, err = readZlib(payload)
 	}
@@ -259,9 +187,13 @@ func Decode(payload string, protocol string, projectId string, v *Packet) error
 		return err
 	}
 
+	v.Severity = v.Level
 	if protocol == "7" {
-		v.Message = v.InterfaceException7.Values[0].Value
-		v.Level = v.InterfaceException7.Values[0].Type
+		// a message event has no exception, its level stays
+		if e, ok := v.exception(); ok {
+			v.Message = e.Value
+			v.Level = e.Type
+		}
 		v.Logger = v.Platform
 		v.Project = projectId
 	}
@@ -269,9 +201,28 @@ func Decode(payload string, protocol string, projectId string, v *Packet) error
 	return nil
 }
 
+// exception returns the first exception of a protocol 7 event, false for a
+// message event
+func (p *Packet) exception() (Value, bool) {
+	if len(p.InterfaceException7.Values) == 0 {
+		return Value{}, false
+	}
+	return p.InterfaceException7.Values[0], true
+}
+
 func readGzip(payload string) ([]byte, error) {
 	c, _ := base64.StdEncoding.DecodeString(payload)
-	b := bytes.NewBufferString(string(c))
+	return ReadEnvelope(c)
+}
+
+// ReadEnvelope returns the plain envelope, browser SDKs and tunnels send it
+// uncompressed
+func ReadEnvelope(c []byte) ([]byte, error) {
+	if len(c) < 2 || c[0] != 0x1f || c[1] != 0x8b {
+		return c, nil
+	}
+
+	b := bytes.NewBuffer(c)
 
 	z, err := gzip.NewReader(b)
 	if err != nil {
@@ -295,11 +246,6 @@ func readZlib(payload string) ([]byte, error) {
 	return ioutil.ReadAll(z)
 }
 
-func getParts(payload string) (envelope string, header string, body string) {
-	lines := strings.Split(strings.TrimSpace(payload), "\n")
-	return lines[0], lines[1], lines[2]
-}
-
 func GetMD5Hash(text string) string {
 	hasher := md5.New()
 	hasher.Write([]byte(text))
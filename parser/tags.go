@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GetSeverity returns the level sent by the client in lower case, error when
+// it is missing
+func (p *Packet) GetSeverity() string {
+	if p.Severity == "" {
+		return "error"
+	}
+	return strings.ToLower(p.Severity)
+}
+
+// GetTags returns the tags, they are an object or a list of key and value
+// pairs
+func (p *Packet) GetTags() map[string]string {
+	tags := make(map[string]string)
+	switch t := p.Tags.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			tags[k] = fmt.Sprint(v)
+		}
+	case []interface{}:
+		for _, pair := range t {
+			if kv, ok := pair.([]interface{}); ok && len(kv) == 2 {
+				tags[fmt.Sprint(kv[0])] = fmt.Sprint(kv[1])
+			}
+		}
+	}
+	return tags
+}
+
+// GetUser returns the id, email, username or IP address of the user, the
+// first one set
+func (p *Packet) GetUser() string {
+	user := p.User
+	if user == nil {
+		user = p.InterfaceUser
+	}
+	for _, key := range []string{"id", "email", "username", "ip_address"} {
+		if v, ok := user[key]; ok && v != nil && fmt.Sprint(v) != "" {
+			return key + ":" + fmt.Sprint(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,391 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/scr34m/proof/database"
+	"github.com/scr34m/proof/shared"
+)
+
+const (
+	// DiskPollInterval of waiting for the packets pushed by an other process
+	DiskPollInterval = 250 * time.Millisecond
+	// DiskNoticeAge after the published notices are removed
+	DiskNoticeAge = time.Minute
+)
+
+const diskSchema = `
+CREATE TABLE IF NOT EXISTS queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	packet TEXT NOT NULL,
+	consumer TEXT NOT NULL DEFAULT '',
+	due INTEGER NOT NULL DEFAULT 0,
+	dead INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	failed INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS queue_waiting ON queue (dead, consumer, due, id);
+CREATE TABLE IF NOT EXISTS worker (
+	name TEXT PRIMARY KEY,
+	heartbeat TEXT NOT NULL,
+	expires INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS notice (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	notice TEXT NOT NULL,
+	created INTEGER NOT NULL
+);
+`
+
+// diskQueue is a queue in an SQLite file for a frontend and workers on the
+// same host. A taken packet has the consumer set, a retry has a due time and
+// a dead letter the dead flag. The notices are kept for a while in their own
+// table for the subscribers to poll
+type diskQueue struct {
+	db     *database.DB
+	notify chan struct{}
+}
+
+func NewDisk(file string) (Queue, error) {
+	db, err := database.Open(database.SQLite, "file:"+file+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(diskSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &diskQueue{db: db, notify: make(chan struct{}, 1)}, nil
+}
+
+func (q *diskQueue) Push(ctx context.Context, packet shared.QueuePacket) error {
+	j, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.db.Exec("INSERT INTO queue (packet) VALUES (?)", string(j))
+	if err != nil {
+		return err
+	}
+
+	// wake a worker of this process without waiting for the poll
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *diskQueue) Pop(ctx context.Context, consumer string, timeout time.Duration) (*Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		var id int64
+		var raw string
+		err := q.db.QueryRow("UPDATE queue SET consumer = ? WHERE id = (SELECT id FROM queue WHERE dead = 0 AND consumer = '' AND due <= ? ORDER BY id LIMIT 1) RETURNING id, packet", consumer, time.Now().Unix()).Scan(&id, &raw)
+		if err == nil {
+			m := &Message{raw: raw, consumer: consumer, ref: strconv.FormatInt(id, 10)}
+			if err := decode(raw, m); err != nil {
+				if derr := q.bury(m, err); derr != nil {
+					return nil, derr
+				}
+				return nil, err
+			}
+			return m, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return nil, nil
+		case <-q.notify:
+		case <-time.After(DiskPollInterval):
+		}
+	}
+}
+
+func (q *diskQueue) Ack(ctx context.Context, m *Message) error {
+	_, err := q.db.Exec("DELETE FROM queue WHERE id = ?", m.ref)
+	return err
+}
+
+func (q *diskQueue) Fail(ctx context.Context, m *Message, cause error) (bool, error) {
+	m.Packet.Attempts++
+	if m.Packet.Attempts >= MaxAttempts {
+		return true, q.bury(m, cause)
+	}
+
+	j, err := json.Marshal(m.Packet)
+	if err != nil {
+		return false, err
+	}
+
+	next := time.Now().Add(backoff(m.Packet.Attempts))
+	_, err = q.db.Exec("UPDATE queue SET packet = ?, consumer = '', due = ?, error = ? WHERE id = ?", string(j), next.Unix(), cause.Error(), m.ref)
+	return false, err
+}
+
+// bury flags the packet dead, a malformed one is kept as it was received
+func (q *diskQueue) bury(m *Message, cause error) error {
+	raw := m.raw
+	if m.Packet.Attempts > 0 {
+		j, err := json.Marshal(m.Packet)
+		if err != nil {
+			return err
+		}
+		raw = string(j)
+	}
+
+	_, err := q.db.Exec("UPDATE queue SET packet = ?, consumer = '', dead = 1, error = ?, failed = ? WHERE id = ?", raw, cause.Error(), time.Now().Unix(), m.ref)
+	return err
+}
+
+// Requeue releases the packet, it keeps its place in the queue
+func (q *diskQueue) Requeue(ctx context.Context, m *Message) error {
+	_, err := q.db.Exec("UPDATE queue SET consumer = '' WHERE id = ?", m.ref)
+	return err
+}
+
+func (q *diskQueue) Recover(ctx context.Context, stale func(consumer string) bool) (int, error) {
+	rows, err := q.db.Query("SELECT DISTINCT consumer FROM queue WHERE consumer <> ''")
+	if err != nil {
+		return 0, err
+	}
+	var consumers []string
+	for rows.Next() {
+		var consumer string
+		if err := rows.Scan(&consumer); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if stale(consumer) {
+			consumers = append(consumers, consumer)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, consumer := range consumers {
+		res, err := q.db.Exec("UPDATE queue SET consumer = '' WHERE consumer = ?", consumer)
+		if err != nil {
+			return n, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+		n += int(affected)
+	}
+	return n, nil
+}
+
+// Due has nothing to do, the retries are taken when their time comes
+func (q *diskQueue) Due(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (q *diskQueue) Depth(ctx context.Context) (int64, int64, int64, error) {
+	var waiting, retrying, dead sql.NullInt64
+	err := q.db.QueryRow(`SELECT
+		SUM(CASE WHEN dead = 0 AND consumer = '' AND due <= ? THEN 1 ELSE 0 END),
+		SUM(CASE WHEN dead = 0 AND consumer = '' AND due > ? THEN 1 ELSE 0 END),
+		SUM(dead)
+		FROM queue`, time.Now().Unix(), time.Now().Unix()).Scan(&waiting, &retrying, &dead)
+	return waiting.Int64, retrying.Int64, dead.Int64, err
+}
+
+func (q *diskQueue) Dead(ctx context.Context, limit int) ([]DeadLetter, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := q.db.Query("SELECT id, packet, error, failed FROM queue WHERE dead = 1 ORDER BY failed DESC, id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []DeadLetter
+	for rows.Next() {
+		var id, failed int64
+		var raw string
+		d := DeadLetter{}
+		if err := rows.Scan(&id, &raw, &d.Error, &failed); err != nil {
+			return nil, err
+		}
+		d.Id = strconv.FormatInt(id, 10)
+		d.Failed = time.Unix(failed, 0)
+		if err := json.Unmarshal([]byte(raw), &d.Packet); err != nil {
+			d.Raw = raw
+		}
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
+
+func (q *diskQueue) Replay(ctx context.Context, id string) error {
+	var raw string
+	err := q.db.QueryRow("SELECT packet FROM queue WHERE id = ? AND dead = 1", id).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	d := DeadLetter{}
+	if err := json.Unmarshal([]byte(raw), &d.Packet); err != nil {
+		d.Raw = raw
+	}
+	raw, err = replayed(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.db.Exec("UPDATE queue SET packet = ?, dead = 0, due = 0, error = '', failed = 0 WHERE id = ?", raw, id)
+	return err
+}
+
+func (q *diskQueue) Discard(ctx context.Context, id string) error {
+	res, err := q.db.Exec("DELETE FROM queue WHERE id = ? AND dead = 1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (q *diskQueue) Publish(ctx context.Context, n Notice) error {
+	j, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = q.db.Exec("INSERT INTO notice (notice, created) VALUES (?, ?)", string(j), now.Unix())
+	if err != nil {
+		return err
+	}
+	_, err = q.db.Exec("DELETE FROM notice WHERE created < ?", now.Add(-DiskNoticeAge).Unix())
+	return err
+}
+
+// Subscribe polls for the notices published after it was called
+func (q *diskQueue) Subscribe(ctx context.Context) (<-chan Notice, error) {
+	var last int64
+	err := q.db.QueryRow("SELECT IFNULL(MAX(id), 0) FROM notice").Scan(&last)
+	if err != nil {
+		return nil, err
+	}
+
+	notices := make(chan Notice, 100)
+	go func() {
+		defer close(notices)
+
+		ticker := time.NewTicker(DiskPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			rows, err := q.db.Query("SELECT id, notice FROM notice WHERE id > ? ORDER BY id", last)
+			if err != nil {
+				continue
+			}
+			var batch []Notice
+			for rows.Next() {
+				var raw string
+				if err := rows.Scan(&last, &raw); err != nil {
+					break
+				}
+				n := Notice{}
+				if err := json.Unmarshal([]byte(raw), &n); err == nil {
+					batch = append(batch, n)
+				}
+			}
+			rows.Close()
+
+			for _, n := range batch {
+				select {
+				case notices <- n:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return notices, nil
+}
+
+func (q *diskQueue) Oldest(ctx context.Context) (time.Time, error) {
+	var raw string
+	err := q.db.QueryRow("SELECT packet FROM queue WHERE dead = 0 AND consumer = '' AND due <= ? ORDER BY id LIMIT 1", time.Now().Unix()).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	m := &Message{}
+	if decode(raw, m) != nil {
+		return time.Time{}, nil
+	}
+	return queued(m.Packet), nil
+}
+
+func (q *diskQueue) Beat(ctx context.Context, h Heartbeat, ttl time.Duration) error {
+	j, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.Exec("INSERT OR REPLACE INTO worker (name, heartbeat, expires) VALUES (?, ?, ?)", h.Name, string(j), time.Now().Add(ttl).Unix())
+	return err
+}
+
+func (q *diskQueue) Workers(ctx context.Context) ([]Heartbeat, error) {
+	_, err := q.db.Exec("DELETE FROM worker WHERE expires < ?", time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.db.Query("SELECT heartbeat FROM worker ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workers []Heartbeat
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		h := Heartbeat{}
+		if err := json.Unmarshal([]byte(raw), &h); err == nil {
+			workers = append(workers, h)
+		}
+	}
+	return workers, rows.Err()
+}
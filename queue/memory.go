@@ -0,0 +1,295 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scr34m/proof/shared"
+)
+
+type memoryEntry struct {
+	packet   shared.QueuePacket
+	ref      string
+	consumer string
+	due      time.Time
+}
+
+// memoryQueue keeps the packets in the process, it is lost on exit and meant
+// for tests
+type memoryQueue struct {
+	mu      sync.Mutex
+	seq     int64
+	waiting []*memoryEntry
+	taken   map[string]*memoryEntry
+	retries []*memoryEntry
+	dead    []DeadLetter
+	notify  chan struct{}
+	// subscribers of the notices
+	subscribers map[chan Notice]struct{}
+	workers     map[string]memoryBeat
+}
+
+type memoryBeat struct {
+	heartbeat Heartbeat
+	expires   time.Time
+}
+
+func NewMemory() Queue {
+	return &memoryQueue{
+		taken:       make(map[string]*memoryEntry),
+		notify:      make(chan struct{}, 1),
+		subscribers: make(map[chan Notice]struct{}),
+		workers:     make(map[string]memoryBeat),
+	}
+}
+
+func (q *memoryQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *memoryQueue) Push(ctx context.Context, packet shared.QueuePacket) error {
+	q.mu.Lock()
+	q.waiting = append(q.waiting, &memoryEntry{packet: packet})
+	q.mu.Unlock()
+
+	q.wake()
+	return nil
+}
+
+func (q *memoryQueue) Pop(ctx context.Context, consumer string, timeout time.Duration) (*Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		q.mu.Lock()
+		if len(q.waiting) > 0 {
+			e := q.waiting[0]
+			q.waiting = q.waiting[1:]
+
+			q.seq++
+			e.ref = strconv.FormatInt(q.seq, 10)
+			e.consumer = consumer
+			q.taken[e.ref] = e
+			more := len(q.waiting) > 0
+			q.mu.Unlock()
+
+			// pass the wake up on to the next waiting consumer
+			if more {
+				q.wake()
+			}
+			return &Message{Packet: e.packet, consumer: consumer, ref: e.ref}, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return nil, nil
+		case <-q.notify:
+		}
+	}
+}
+
+func (q *memoryQueue) Ack(ctx context.Context, m *Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.taken, m.ref)
+	return nil
+}
+
+func (q *memoryQueue) Fail(ctx context.Context, m *Message, cause error) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.taken, m.ref)
+
+	m.Packet.Attempts++
+	if m.Packet.Attempts >= MaxAttempts {
+		q.seq++
+		d := DeadLetter{Id: strconv.FormatInt(q.seq, 10), Packet: m.Packet, Error: cause.Error(), Failed: time.Now()}
+		q.dead = append([]DeadLetter{d}, q.dead...)
+		return true, nil
+	}
+
+	q.retries = append(q.retries, &memoryEntry{packet: m.Packet, due: time.Now().Add(backoff(m.Packet.Attempts))})
+	return false, nil
+}
+
+func (q *memoryQueue) Requeue(ctx context.Context, m *Message) error {
+	q.mu.Lock()
+	if e, ok := q.taken[m.ref]; ok {
+		delete(q.taken, m.ref)
+		q.waiting = append([]*memoryEntry{e}, q.waiting...)
+	}
+	q.mu.Unlock()
+
+	q.wake()
+	return nil
+}
+
+func (q *memoryQueue) Recover(ctx context.Context, stale func(consumer string) bool) (int, error) {
+	q.mu.Lock()
+	n := 0
+	for ref, e := range q.taken {
+		if stale(e.consumer) {
+			delete(q.taken, ref)
+			q.waiting = append([]*memoryEntry{e}, q.waiting...)
+			n++
+		}
+	}
+	q.mu.Unlock()
+
+	if n > 0 {
+		q.wake()
+	}
+	return n, nil
+}
+
+func (q *memoryQueue) Due(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	now := time.Now()
+	var later []*memoryEntry
+	n := 0
+	for _, e := range q.retries {
+		if e.due.After(now) {
+			later = append(later, e)
+			continue
+		}
+		q.waiting = append(q.waiting, e)
+		n++
+	}
+	q.retries = later
+	q.mu.Unlock()
+
+	if n > 0 {
+		q.wake()
+	}
+	return n, nil
+}
+
+func (q *memoryQueue) Depth(ctx context.Context) (int64, int64, int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return int64(len(q.waiting)), int64(len(q.retries)), int64(len(q.dead)), nil
+}
+
+func (q *memoryQueue) Dead(ctx context.Context, limit int) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := q.dead
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return append([]DeadLetter(nil), letters...), nil
+}
+
+// remove takes the dead letter out of the list
+func (q *memoryQueue) remove(id string) (DeadLetter, bool) {
+	for i, d := range q.dead {
+		if d.Id == id {
+			q.dead = append(q.dead[:i], q.dead[i+1:]...)
+			return d, true
+		}
+	}
+	return DeadLetter{}, false
+}
+
+func (q *memoryQueue) Replay(ctx context.Context, id string) error {
+	q.mu.Lock()
+	d, ok := q.remove(id)
+	if ok {
+		d.Packet.Attempts = 0
+		q.waiting = append(q.waiting, &memoryEntry{packet: d.Packet})
+	}
+	q.mu.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+	q.wake()
+	return nil
+}
+
+func (q *memoryQueue) Discard(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.remove(id); !ok {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Publish drops the notice for a subscriber falling behind
+func (q *memoryQueue) Publish(ctx context.Context, n Notice) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for ch := range q.subscribers {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *memoryQueue) Subscribe(ctx context.Context) (<-chan Notice, error) {
+	ch := make(chan Notice, 100)
+	q.mu.Lock()
+	q.subscribers[ch] = struct{}{}
+	q.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		q.mu.Lock()
+		delete(q.subscribers, ch)
+		close(ch)
+		q.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+func (q *memoryQueue) Oldest(ctx context.Context) (time.Time, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiting) == 0 {
+		return time.Time{}, nil
+	}
+	return queued(q.waiting[0].packet), nil
+}
+
+func (q *memoryQueue) Beat(ctx context.Context, h Heartbeat, ttl time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.workers[h.Name] = memoryBeat{heartbeat: h, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (q *memoryQueue) Workers(ctx context.Context) ([]Heartbeat, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var workers []Heartbeat
+	for name, b := range q.workers {
+		if b.expires.Before(time.Now()) {
+			delete(q.workers, name)
+			continue
+		}
+		workers = append(workers, b.heartbeat)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Name < workers[j].Name })
+	return workers, nil
+}
@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/scr34m/proof/shared"
+)
+
+const (
+	Redis  = "redis"
+	Stream = "stream"
+	Disk   = "disk"
+)
+
+const (
+	// MaxAttempts of a packet before it is moved to the dead letters
+	MaxAttempts = 5
+	RetryDelay  = 10 * time.Second
+	// MaxRetryDelay caps the doubling delay of the retries
+	MaxRetryDelay = 10 * time.Minute
+)
+
+var (
+	ErrNotFound  = errors.New("dead letter not found")
+	ErrMalformed = errors.New("malformed packet")
+)
+
+// Queue carries the packets from the frontend to the workers at least once.
+// A packet taken by a consumer is kept until it is acknowledged, failed or
+// requeued, see NewRedis, NewStream, NewDisk and NewMemory
+type Queue interface {
+	Push(ctx context.Context, packet shared.QueuePacket) error
+	// Pop waits up to timeout for a packet, it returns nil without one. With
+	// a zero timeout it does not wait. A malformed packet is moved to the dead
+	// letters right away and ErrMalformed is returned
+	Pop(ctx context.Context, consumer string, timeout time.Duration) (*Message, error)
+	// Ack removes the processed packet
+	Ack(ctx context.Context, m *Message) error
+	// Fail schedules the packet for a retry with a doubling delay, or moves it
+	// to the dead letters after MaxAttempts. It returns whether the packet is
+	// dead
+	Fail(ctx context.Context, m *Message, cause error) (bool, error)
+	// Requeue gives the packet back unprocessed, it is taken next
+	Requeue(ctx context.Context, m *Message) error
+	// Recover queues again the packets taken by the consumers stale returns
+	// true for, they were left by a process that did not finish them
+	Recover(ctx context.Context, stale func(consumer string) bool) (int, error)
+	// Due queues the retries whose delay is over
+	Due(ctx context.Context) (int, error)
+	// Depth returns the number of waiting, retrying and dead packets
+	Depth(ctx context.Context) (int64, int64, int64, error)
+	// Oldest returns when the oldest waiting packet was received, zero
+	// without one. The stream backend counts the taken packets too
+	Oldest(ctx context.Context) (time.Time, error)
+	// Dead returns the latest limit dead letters, all of them with 0
+	Dead(ctx context.Context, limit int) ([]DeadLetter, error)
+	// Replay queues the dead letter again with a fresh attempt counter
+	Replay(ctx context.Context, id string) error
+	// Discard deletes the dead letter
+	Discard(ctx context.Context, id string) error
+	// Publish tells the subscribed frontends about a processed event, it is
+	// lost without a subscriber
+	Publish(ctx context.Context, n Notice) error
+	// Subscribe delivers the published notices until the context is done
+	Subscribe(ctx context.Context) (<-chan Notice, error)
+	// Beat registers the worker process for ttl, it is renewed by the next beat
+	Beat(ctx context.Context, h Heartbeat, ttl time.Duration) error
+	// Workers returns the registered worker processes
+	Workers(ctx context.Context) ([]Heartbeat, error)
+}
+
+// Message is a packet taken by a consumer
+type Message struct {
+	Packet   shared.QueuePacket
+	raw      string
+	consumer string
+	// ref identifies the packet in the backend
+	ref string
+}
+
+// DeadLetter is a packet given up on
+type DeadLetter struct {
+	Id     string             `json:"-"`
+	Packet shared.QueuePacket `json:"packet"`
+	// Raw is the packet as it was received when it can not be read
+	Raw    string    `json:"raw,omitempty"`
+	Error  string    `json:"error"`
+	Failed time.Time `json:"failed"`
+	raw    string
+}
+
+// Notice tells about a processed event, the fields are the ones of the
+// events list
+type Notice struct {
+	GroupId    int64  `json:"group_id"`
+	Project    string `json:"project"`
+	Level      string `json:"level"`
+	Message    string `json:"message"`
+	Url        string `json:"url"`
+	ServerName string `json:"server_name"`
+	Site       string `json:"site"`
+	Platform   string `json:"platform"`
+	Seen       int64  `json:"seen"`
+	LastSeen   string `json:"last_seen"`
+	New        bool   `json:"new"`
+	Regression bool   `json:"regression"`
+	Sampled    bool   `json:"sampled"`
+}
+
+// Heartbeat of a worker process
+type Heartbeat struct {
+	Name      string    `json:"name"`
+	Host      string    `json:"host"`
+	Pid       int       `json:"pid"`
+	Workers   int       `json:"workers"`
+	Busy      int       `json:"busy"`
+	Started   time.Time `json:"started"`
+	Seen      time.Time `json:"seen"`
+	Processed int64     `json:"processed"`
+	Failed    int64     `json:"failed"`
+	Dead      int64     `json:"dead"`
+	// Rate of the processed events per second in the last minute
+	Rate     float64   `json:"rate"`
+	Failures []Failure `json:"failures"`
+}
+
+// Failure is a processing error with the start of the packet
+type Failure struct {
+	Time      time.Time `json:"time"`
+	Worker    string    `json:"worker,omitempty"`
+	Error     string    `json:"error"`
+	ProjectId string    `json:"project_id"`
+	Protocol  string    `json:"protocol"`
+	Preview   string    `json:"preview"`
+}
+
+// queued returns the time the packet was received, zero for the packets of
+// older frontends
+func queued(packet shared.QueuePacket) time.Time {
+	if packet.Queued == 0 {
+		return time.Time{}
+	}
+	return time.Unix(packet.Queued, 0)
+}
+
+// backoff returns the delay before the next attempt
+func backoff(attempts int) time.Duration {
+	delay := RetryDelay << uint(attempts-1)
+	if delay > MaxRetryDelay || delay <= 0 {
+		delay = MaxRetryDelay
+	}
+	return delay
+}
+
+// decode reads the taken packet, the error wraps ErrMalformed
+func decode(raw string, m *Message) error {
+	err := json.Unmarshal([]byte(raw), &m.Packet)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return nil
+}
+
+// replayed returns the packet of the dead letter to queue again
+func replayed(d DeadLetter) (string, error) {
+	if d.Raw != "" {
+		return d.Raw, nil
+	}
+	d.Packet.Attempts = 0
+	j, err := json.Marshal(d.Packet)
+	return string(j), err
+}
+
+func letterId(raw string) string {
+	hasher := md5.New()
+	hasher.Write([]byte(raw))
+	return hex.EncodeToString(hasher.Sum(nil))[:12]
+}
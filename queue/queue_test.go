@@ -0,0 +1,354 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/scr34m/proof/shared"
+)
+
+// backends runs the test against every queue, elapse makes the retries due
+func backends(t *testing.T, test func(t *testing.T, q Queue, elapse func())) {
+	t.Run("memory", func(t *testing.T) {
+		q := NewMemory()
+		test(t, q, func() {
+			m := q.(*memoryQueue)
+			m.mu.Lock()
+			for _, e := range m.retries {
+				e.due = time.Time{}
+			}
+			m.mu.Unlock()
+		})
+	})
+
+	t.Run("disk", func(t *testing.T) {
+		q, err := NewDisk(filepath.Join(t.TempDir(), "queue.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.(*diskQueue).db.Close()
+
+		test(t, q, func() {
+			if _, err := q.(*diskQueue).db.Exec("UPDATE queue SET due = 0"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+
+	for name, open := range map[string]func(*redis.Client, string) Queue{"redis": NewRedis, "stream": NewStream} {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			server, client := newMiniredis(t)
+			test(t, open(client, "proof_events"), func() {
+				members, err := server.ZMembers("proof_events:retry")
+				if err != nil && len(members) > 0 {
+					t.Fatal(err)
+				}
+				for _, member := range members {
+					server.ZAdd("proof_events:retry", 0, member)
+				}
+			})
+		})
+	}
+}
+
+func push(t *testing.T, q Queue, bodies ...string) {
+	t.Helper()
+	for _, body := range bodies {
+		if err := q.Push(context.Background(), shared.QueuePacket{Body: []byte(body), Protocol: "7", ProjectId: "1", Queued: 1700000000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// pop takes the next packet, an empty body without one
+func pop(t *testing.T, q Queue, consumer string) (*Message, string) {
+	t.Helper()
+
+	m, err := q.Pop(context.Background(), consumer, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil {
+		return nil, ""
+	}
+	return m, string(m.Packet.Body)
+}
+
+func depth(t *testing.T, q Queue, waiting int64, retrying int64, dead int64) {
+	t.Helper()
+
+	w, r, d, err := q.Depth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != waiting || r != retrying || d != dead {
+		t.Fatalf("depth %d waiting, %d retrying, %d dead", w, r, d)
+	}
+}
+
+func TestPushPop(t *testing.T) {
+	backends(t, func(t *testing.T, q Queue, elapse func()) {
+		ctx := context.Background()
+
+		if m, _ := pop(t, q, "web-1:0"); m != nil {
+			t.Fatalf("popped from an empty queue: %+v", m.Packet)
+		}
+		if oldest, err := q.Oldest(ctx); err != nil || !oldest.IsZero() {
+			t.Fatalf("oldest of an empty queue %v: %v", oldest, err)
+		}
+
+		push(t, q, "a", "b", "c")
+		depth(t, q, 3, 0, 0)
+		if oldest, err := q.Oldest(ctx); err != nil || !oldest.Equal(time.Unix(1700000000, 0)) {
+			t.Fatalf("oldest %v: %v", oldest, err)
+		}
+
+		for _, want := range []string{"a", "b", "c"} {
+			m, body := pop(t, q, "web-1:0")
+			if body != want || m.Packet.ProjectId != "1" || m.Packet.Protocol != "7" {
+				t.Fatalf("popped %q instead of %q", body, want)
+			}
+			if err := q.Ack(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		depth(t, q, 0, 0, 0)
+		if m, _ := pop(t, q, "web-1:0"); m != nil {
+			t.Fatalf("popped an acknowledged packet: %+v", m.Packet)
+		}
+	})
+}
+
+func TestPopTimeout(t *testing.T) {
+	backends(t, func(t *testing.T, q Queue, elapse func()) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			push(t, q, "a")
+		}()
+
+		m, err := q.Pop(context.Background(), "web-1:0", 5*time.Second)
+		if err != nil || m == nil || string(m.Packet.Body) != "a" {
+			t.Fatalf("waited for %+v: %v", m, err)
+		}
+	})
+}
+
+func TestRequeue(t *testing.T) {
+	backends(t, func(t *testing.T, q Queue, elapse func()) {
+		ctx := context.Background()
+
+		push(t, q, "a")
+		m, _ := pop(t, q, "web-1:0")
+		if err := q.Requeue(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		depth(t, q, 1, 0, 0)
+
+		m, body := pop(t, q, "web-1:1")
+		if body != "a" || m.Packet.Attempts != 0 {
+			t.Fatalf("requeued packet %q: %+v", body, m.Packet)
+		}
+		if err := q.Ack(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		depth(t, q, 0, 0, 0)
+	})
+}
+
+func TestRecover(t *testing.T) {
+	backends(t, func(t *testing.T, q Queue, elapse func()) {
+		ctx := context.Background()
+
+		push(t, q, "a", "b")
+		pop(t, q, "gone:0")
+		pop(t, q, "live:0")
+
+		n, err := q.Recover(ctx, func(consumer string) bool { return consumer == "gone:0" })
+		if err != nil || n != 1 {
+			t.Fatalf("recovered %d: %v", n, err)
+		}
+
+		m, body := pop(t, q, "live:1")
+		if body != "a" {
+			t.Fatalf("recovered %q", body)
+		}
+		if err := q.Ack(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		if m, _ := pop(t, q, "live:1"); m != nil {
+			t.Fatalf("the packet of a live consumer recovered: %+v", m.Packet)
+		}
+
+		// nothing is left of the gone consumer
+		if n, err := q.Recover(ctx, func(consumer string) bool { return consumer == "gone:0" }); err != nil || n != 0 {
+			t.Fatalf("recovered %d again: %v", n, err)
+		}
+	})
+}
+
+func TestFail(t *testing.T) {
+	backends(t, func(t *testing.T, q Queue, elapse func()) {
+		ctx := context.Background()
+		cause := errors.New("database is locked")
+
+		push(t, q, "a")
+		m, _ := pop(t, q, "web-1:0")
+		for attempt := 1; attempt < MaxAttempts; attempt++ {
+			dead, err := q.Fail(ctx, m, cause)
+			if err != nil || dead {
+				t.Fatalf("attempt %d dead %v: %v", attempt, dead, err)
+			}
+			depth(t, q, 0, 1, 0)
+
+			// the delay is not over yet
+			if _, err := q.Due(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if m, _ := pop(t, q, "web-1:0"); m != nil {
+				t.Fatalf("retry %d taken before its time", attempt)
+			}
+
+			elapse()
+			if _, err := q.Due(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var body string
+			m, body = pop(t, q, "web-1:0")
+			if body != "a" || m.Packet.Attempts != attempt {
+				t.Fatalf("retry %q after %d attempts: %+v", body, attempt, m)
+			}
+		}
+
+		dead, err := q.Fail(ctx, m, cause)
+		if err != nil || !dead {
+			t.Fatalf("last attempt dead %v: %v", dead, err)
+		}
+		depth(t, q, 0, 0, 1)
+
+		letters, err := q.Dead(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(letters) != 1 || string(letters[0].Packet.Body) != "a" || letters[0].Packet.Attempts != MaxAttempts || letters[0].Error != cause.Error() || letters[0].Failed.IsZero() {
+			t.Fatalf("dead letters: %+v", letters)
+		}
+	})
+}
+
+func TestDead(t *testing.T) {
+	backends(t, func(t *testing.T, q Queue, elapse func()) {
+		ctx := context.Background()
+
+		push(t, q, "a", "b")
+		for i := 0; i < 2; i++ {
+			m, _ := pop(t, q, "web-1:0")
+			m.Packet.Attempts = MaxAttempts - 1
+			if dead, err := q.Fail(ctx, m, errors.New("invalid")); err != nil || !dead {
+				t.Fatalf("dead %v: %v", dead, err)
+			}
+		}
+
+		letters, err := q.Dead(ctx, 0)
+		if err != nil || len(letters) != 2 {
+			t.Fatalf("dead letters %+v: %v", letters, err)
+		}
+		if limited, err := q.Dead(ctx, 1); err != nil || len(limited) != 1 {
+			t.Fatalf("limited dead letters %+v: %v", limited, err)
+		}
+
+		// the letters of a and b in any order
+		replay, discard := letters[0], letters[1]
+		if err := q.Replay(ctx, replay.Id); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Discard(ctx, discard.Id); err != nil {
+			t.Fatal(err)
+		}
+		depth(t, q, 1, 0, 0)
+
+		m, body := pop(t, q, "web-1:0")
+		if body != string(replay.Packet.Body) || m.Packet.Attempts != 0 {
+			t.Fatalf("replayed %q: %+v", body, m.Packet)
+		}
+		if err := q.Ack(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, id := range []string{replay.Id, discard.Id} {
+			if err := q.Replay(ctx, id); err != ErrNotFound {
+				t.Errorf("replay of a gone letter: %v", err)
+			}
+			if err := q.Discard(ctx, id); err != ErrNotFound {
+				t.Errorf("discard of a gone letter: %v", err)
+			}
+		}
+		depth(t, q, 0, 0, 0)
+	})
+}
+
+func TestNotices(t *testing.T) {
+	backends(t, func(t *testing.T, q Queue, elapse func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		notices, err := q.Subscribe(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the subscription of Redis is set up in the background
+		sent := Notice{GroupId: 1, Project: "1", Message: "a", New: true}
+		deadline := time.After(5 * time.Second)
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			if err := q.Publish(ctx, sent); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case n := <-notices:
+				if n != sent {
+					t.Fatalf("notice %+v", n)
+				}
+				return
+			case <-tick.C:
+			case <-deadline:
+				t.Fatal("no notice")
+			}
+		}
+	})
+}
+
+func TestWorkers(t *testing.T) {
+	backends(t, func(t *testing.T, q Queue, elapse func()) {
+		ctx := context.Background()
+
+		for _, name := range []string{"web-2", "web-1"} {
+			if err := q.Beat(ctx, Heartbeat{Name: name, Workers: 4}, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// renewed
+		if err := q.Beat(ctx, Heartbeat{Name: "web-1", Workers: 2}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		workers, err := q.Workers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make(map[string]int)
+		for _, h := range workers {
+			names[h.Name] = h.Workers
+		}
+		if len(workers) != 2 || names["web-1"] != 2 || names["web-2"] != 4 {
+			t.Fatalf("workers: %+v", workers)
+		}
+	})
+}
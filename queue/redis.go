@@ -0,0 +1,389 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/scr34m/proof/shared"
+)
+
+// due moves the retries whose time has come back to the list, in one step so
+// more workers can run it at the same time. The members are the packets
+// after a unique id and a newline, the ones of older versions start with the
+// packet right away
+var due = redis.NewScript(`
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, item in ipairs(items) do
+	redis.call('ZREM', KEYS[1], item)
+	local i = string.find(item, '\n', 1, true)
+	if i and string.sub(item, 1, 1) ~= '{' then
+		item = string.sub(item, i + 1)
+	end
+	redis.call('RPUSH', KEYS[2], item)
+end
+return #items
+`)
+
+// redisBase keeps the retries of the Redis backends in the key:retry sorted
+// set and the dead letters in the key:dead list
+type redisBase struct {
+	redis *redis.Client
+	key   string
+}
+
+func (q *redisBase) retryKey() string {
+	return q.key + ":retry"
+}
+
+func (q *redisBase) deadKey() string {
+	return q.key + ":dead"
+}
+
+// retry returns the retry of the failed packet and its due time, the member
+// is unique so the same packets failing together are all retried
+func (q *redisBase) retry(m *Message) (*redis.Z, error) {
+	j, err := json.Marshal(m.Packet)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	next := time.Now().Add(backoff(m.Packet.Attempts))
+	return &redis.Z{Score: float64(next.Unix()), Member: hex.EncodeToString(id) + "\n" + string(j)}, nil
+}
+
+func (q *redisBase) letter(d DeadLetter) ([]byte, error) {
+	d.Failed = time.Now()
+	return json.Marshal(d)
+}
+
+func (q *redisBase) Dead(ctx context.Context, limit int) ([]DeadLetter, error) {
+	list, err := q.redis.LRange(ctx, q.deadKey(), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var letters []DeadLetter
+	for _, raw := range list {
+		d := DeadLetter{Id: letterId(raw), raw: raw}
+		// keep the unreadable ones visible so they can be discarded
+		if err := json.Unmarshal([]byte(raw), &d); err != nil {
+			d.Error = "unreadable dead letter: " + err.Error()
+		}
+		letters = append(letters, d)
+	}
+	return letters, nil
+}
+
+func (q *redisBase) find(ctx context.Context, id string) (DeadLetter, error) {
+	letters, err := q.Dead(ctx, 0)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	for _, d := range letters {
+		if d.Id == id {
+			return d, nil
+		}
+	}
+	return DeadLetter{}, ErrNotFound
+}
+
+func (q *redisBase) noticeKey() string {
+	return q.key + ":notices"
+}
+
+func (q *redisBase) Publish(ctx context.Context, n Notice) error {
+	j, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return q.redis.Publish(ctx, q.noticeKey(), j).Err()
+}
+
+// Subscribe reconnects when the connection is lost, the notices published
+// meanwhile are missed
+func (q *redisBase) Subscribe(ctx context.Context) (<-chan Notice, error) {
+	sub := q.redis.Subscribe(ctx, q.noticeKey())
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	notices := make(chan Notice, 100)
+	go func() {
+		defer close(notices)
+		defer sub.Close()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				n := Notice{}
+				if err := json.Unmarshal([]byte(msg.Payload), &n); err != nil {
+					continue
+				}
+				select {
+				case notices <- n:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return notices, nil
+}
+
+// scan returns the keys matching the pattern, unlike KEYS it does not block
+// the server on a large keyspace. SCAN may return a key more times
+func (q *redisBase) scan(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	iter := q.redis.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		if key := iter.Val(); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, iter.Err()
+}
+
+func (q *redisBase) workerKey(name string) string {
+	return q.key + ":workers:" + name
+}
+
+func (q *redisBase) Beat(ctx context.Context, h Heartbeat, ttl time.Duration) error {
+	j, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return q.redis.Set(ctx, q.workerKey(h.Name), j, ttl).Err()
+}
+
+func (q *redisBase) Workers(ctx context.Context) ([]Heartbeat, error) {
+	keys, err := q.scan(ctx, q.workerKey("*"))
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	values, err := q.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var workers []Heartbeat
+	for _, v := range values {
+		// expired meanwhile
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		h := Heartbeat{}
+		if err := json.Unmarshal([]byte(raw), &h); err == nil {
+			workers = append(workers, h)
+		}
+	}
+	return workers, nil
+}
+
+func (q *redisBase) Discard(ctx context.Context, id string) error {
+	d, err := q.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	return q.redis.LRem(ctx, q.deadKey(), 1, d.raw).Err()
+}
+
+// redisList is a queue on Redis lists. The packets are pushed to the left of
+// key and taken from the right to the key:processing:<consumer> list
+type redisList struct {
+	redisBase
+}
+
+func NewRedis(redis *redis.Client, key string) Queue {
+	return &redisList{redisBase{redis: redis, key: key}}
+}
+
+func (q *redisList) processing(consumer string) string {
+	return q.key + ":processing:" + consumer
+}
+
+func (q *redisList) Push(ctx context.Context, packet shared.QueuePacket) error {
+	j, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+	return q.redis.LPush(ctx, q.key, j).Err()
+}
+
+func (q *redisList) Pop(ctx context.Context, consumer string, timeout time.Duration) (*Message, error) {
+	var raw string
+	var err error
+	// a zero timeout blocks for ever
+	if timeout > 0 {
+		raw, err = q.redis.BRPopLPush(ctx, q.key, q.processing(consumer), timeout).Result()
+	} else {
+		raw, err = q.redis.RPopLPush(ctx, q.key, q.processing(consumer)).Result()
+	}
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{raw: raw, consumer: consumer}
+
+	// older frontends pushed a 0 after every packet
+	if raw == "0" {
+		return nil, q.Ack(context.Background(), m)
+	}
+
+	if err := decode(raw, m); err != nil {
+		if derr := q.bury(context.Background(), m, DeadLetter{Raw: raw, Error: err.Error()}); derr != nil {
+			return nil, derr
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+func (q *redisList) Ack(ctx context.Context, m *Message) error {
+	return q.redis.LRem(ctx, q.processing(m.consumer), 1, m.raw).Err()
+}
+
+func (q *redisList) Fail(ctx context.Context, m *Message, cause error) (bool, error) {
+	m.Packet.Attempts++
+	if m.Packet.Attempts >= MaxAttempts {
+		return true, q.bury(ctx, m, DeadLetter{Packet: m.Packet, Error: cause.Error()})
+	}
+
+	z, err := q.retry(m)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, q.processing(m.consumer), 1, m.raw)
+		pipe.ZAdd(ctx, q.retryKey(), z)
+		return nil
+	})
+	return false, err
+}
+
+func (q *redisList) bury(ctx context.Context, m *Message, d DeadLetter) error {
+	j, err := q.letter(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, q.processing(m.consumer), 1, m.raw)
+		pipe.LPush(ctx, q.deadKey(), j)
+		return nil
+	})
+	return err
+}
+
+func (q *redisList) Requeue(ctx context.Context, m *Message) error {
+	_, err := q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, q.processing(m.consumer), 1, m.raw)
+		pipe.RPush(ctx, q.key, m.raw)
+		return nil
+	})
+	return err
+}
+
+func (q *redisList) Recover(ctx context.Context, stale func(consumer string) bool) (int, error) {
+	keys, err := q.scan(ctx, q.processing("*"))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, key := range keys {
+		if !stale(strings.TrimPrefix(key, q.processing(""))) {
+			continue
+		}
+		for {
+			err := q.redis.RPopLPush(ctx, key, q.key).Err()
+			if err == redis.Nil {
+				break
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (q *redisList) Due(ctx context.Context) (int, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	return due.Run(ctx, q.redis, []string{q.retryKey(), q.key}, now).Int()
+}
+
+func (q *redisList) Depth(ctx context.Context) (int64, int64, int64, error) {
+	waiting, err := q.redis.LLen(ctx, q.key).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	retrying, err := q.redis.ZCard(ctx, q.retryKey()).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	dead, err := q.redis.LLen(ctx, q.deadKey()).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return waiting, retrying, dead, nil
+}
+
+func (q *redisList) Replay(ctx context.Context, id string) error {
+	d, err := q.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	raw, err := replayed(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, q.deadKey(), 1, d.raw)
+		pipe.RPush(ctx, q.key, raw)
+		return nil
+	})
+	return err
+}
+
+func (q *redisList) Oldest(ctx context.Context) (time.Time, error) {
+	raw, err := q.redis.LIndex(ctx, q.key, -1).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	m := &Message{}
+	if decode(raw, m) != nil {
+		return time.Time{}, nil
+	}
+	return queued(m.Packet), nil
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/scr34m/proof/shared"
+)
+
+// newMiniredis returns a client of an in-process Redis server
+func newMiniredis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestRedisRetry(t *testing.T) {
+	for name, open := range map[string]func(*redis.Client, string) Queue{"redis": NewRedis, "stream": NewStream} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			server, client := newMiniredis(t)
+			q := open(client, "proof_events")
+
+			// the same packet sent twice fails twice
+			packet := shared.QueuePacket{Body: []byte(`{"message":"a"}`), Protocol: "7", ProjectId: "1"}
+			for i := 0; i < 2; i++ {
+				if err := q.Push(ctx, packet); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				m, err := q.Pop(ctx, "web-1:0", 0)
+				if err != nil || m == nil {
+					t.Fatalf("pop %d: %v", i, err)
+				}
+				if dead, err := q.Fail(ctx, m, errors.New("database is locked")); err != nil || dead {
+					t.Fatalf("fail %d: %v %v", i, dead, err)
+				}
+			}
+
+			if _, retrying, _, err := q.Depth(ctx); err != nil || retrying != 2 {
+				t.Fatalf("%d retrying: %v", retrying, err)
+			}
+
+			// the delay is over
+			members, err := server.ZMembers("proof_events:retry")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, member := range members {
+				server.ZAdd("proof_events:retry", 0, member)
+			}
+			// a retry queued by an older version
+			server.ZAdd("proof_events:retry", 0, `{"body":"eyJtZXNzYWdlIjoiYSJ9","protocol":"7","project_id":"1","attempts":1}`)
+
+			if n, err := q.Due(ctx); err != nil || n != 3 {
+				t.Fatalf("%d due: %v", n, err)
+			}
+			for i := 0; i < 3; i++ {
+				m, err := q.Pop(ctx, "web-1:0", 0)
+				if err != nil || m == nil {
+					t.Fatalf("pop of the retry %d: %v", i, err)
+				}
+				if string(m.Packet.Body) != string(packet.Body) || m.Packet.ProjectId != "1" || m.Packet.Attempts != 1 {
+					t.Fatalf("retried packet: %+v", m.Packet)
+				}
+				if err := q.Ack(ctx, m); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if waiting, retrying, dead, err := q.Depth(ctx); err != nil || waiting+retrying+dead != 0 {
+				t.Fatalf("left %d %d %d: %v", waiting, retrying, dead, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/scr34m/proof/shared"
+)
+
+// StreamGroup is the consumer group of the workers
+const StreamGroup = "proof"
+
+// dueStream is due for the stream backend
+var dueStream = redis.NewScript(`
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, item in ipairs(items) do
+	redis.call('ZREM', KEYS[1], item)
+	local i = string.find(item, '\n', 1, true)
+	if i and string.sub(item, 1, 1) ~= '{' then
+		item = string.sub(item, i + 1)
+	end
+	redis.call('XADD', KEYS[2], '*', 'packet', item)
+end
+return #items
+`)
+
+// redisStream is a queue on a Redis stream read by the StreamGroup consumer
+// group, the processed entries are acknowledged and deleted
+type redisStream struct {
+	redisBase
+	mu    sync.Mutex
+	ready bool
+}
+
+func NewStream(redis *redis.Client, key string) Queue {
+	return &redisStream{redisBase: redisBase{redis: redis, key: key}}
+}
+
+// group creates the consumer group on the first use, the entries added
+// before are read too
+func (q *redisStream) group(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.ready {
+		return nil
+	}
+	err := q.redis.XGroupCreateMkStream(ctx, q.key, StreamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	q.ready = true
+	return nil
+}
+
+func (q *redisStream) Push(ctx context.Context, packet shared.QueuePacket) error {
+	j, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+	return q.redis.XAdd(ctx, &redis.XAddArgs{Stream: q.key, Values: []interface{}{"packet", j}}).Err()
+}
+
+func (q *redisStream) Pop(ctx context.Context, consumer string, timeout time.Duration) (*Message, error) {
+	if err := q.group(ctx); err != nil {
+		return nil, err
+	}
+
+	// a zero block waits for ever, a negative one is left out
+	block := timeout
+	if block <= 0 {
+		block = -1
+	}
+
+	streams, err := q.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    StreamGroup,
+		Consumer: consumer,
+		Streams:  []string{q.key, ">"},
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 || len(streams[0].Messages) == 0 {
+		return nil, nil
+	}
+
+	entry := streams[0].Messages[0]
+	raw, _ := entry.Values["packet"].(string)
+	m := &Message{raw: raw, consumer: consumer, ref: entry.ID}
+
+	if err := decode(raw, m); err != nil {
+		if derr := q.bury(context.Background(), m, DeadLetter{Raw: raw, Error: err.Error()}); derr != nil {
+			return nil, derr
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// done acknowledges and deletes the entry in the transaction
+func (q *redisStream) done(ctx context.Context, pipe redis.Pipeliner, id string) {
+	pipe.XAck(ctx, q.key, StreamGroup, id)
+	pipe.XDel(ctx, q.key, id)
+}
+
+func (q *redisStream) Ack(ctx context.Context, m *Message) error {
+	_, err := q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.done(ctx, pipe, m.ref)
+		return nil
+	})
+	return err
+}
+
+func (q *redisStream) Fail(ctx context.Context, m *Message, cause error) (bool, error) {
+	m.Packet.Attempts++
+	if m.Packet.Attempts >= MaxAttempts {
+		return true, q.bury(ctx, m, DeadLetter{Packet: m.Packet, Error: cause.Error()})
+	}
+
+	z, err := q.retry(m)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.done(ctx, pipe, m.ref)
+		pipe.ZAdd(ctx, q.retryKey(), z)
+		return nil
+	})
+	return false, err
+}
+
+func (q *redisStream) bury(ctx context.Context, m *Message, d DeadLetter) error {
+	j, err := q.letter(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.done(ctx, pipe, m.ref)
+		pipe.LPush(ctx, q.deadKey(), j)
+		return nil
+	})
+	return err
+}
+
+// Requeue adds the packet to the end of the stream, there is no way to put it
+// to the front
+func (q *redisStream) Requeue(ctx context.Context, m *Message) error {
+	_, err := q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.done(ctx, pipe, m.ref)
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: q.key, Values: []interface{}{"packet", m.raw}})
+		return nil
+	})
+	return err
+}
+
+// Recover requeues the pending entries of the consumers and removes the
+// consumers from the group
+func (q *redisStream) Recover(ctx context.Context, stale func(consumer string) bool) (int, error) {
+	if err := q.group(ctx); err != nil {
+		return 0, err
+	}
+
+	// the consumers with pending entries
+	summary, err := q.redis.XPending(ctx, q.key, StreamGroup).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for name := range summary.Consumers {
+		if !stale(name) {
+			continue
+		}
+
+		for {
+			pending, err := q.redis.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream:   q.key,
+				Group:    StreamGroup,
+				Start:    "-",
+				End:      "+",
+				Count:    100,
+				Consumer: name,
+			}).Result()
+			// some servers answer nil instead of an empty list
+			if err != nil && err != redis.Nil {
+				return n, err
+			}
+			if len(pending) == 0 {
+				break
+			}
+
+			for _, p := range pending {
+				entries, err := q.redis.XRangeN(ctx, q.key, p.ID, p.ID, 1).Result()
+				if err != nil {
+					return n, err
+				}
+
+				m := &Message{consumer: name, ref: p.ID}
+				if len(entries) == 0 {
+					// deleted already, only the acknowledgement is missing
+					if err := q.Ack(ctx, m); err != nil {
+						return n, err
+					}
+					continue
+				}
+
+				m.raw, _ = entries[0].Values["packet"].(string)
+				if err := q.Requeue(ctx, m); err != nil {
+					return n, err
+				}
+				n++
+			}
+		}
+
+		if err := q.redis.XGroupDelConsumer(ctx, q.key, StreamGroup, name).Err(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (q *redisStream) Due(ctx context.Context) (int, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	return dueStream.Run(ctx, q.redis, []string{q.retryKey(), q.key}, now).Int()
+}
+
+// Depth counts the entries not taken yet as waiting
+func (q *redisStream) Depth(ctx context.Context) (int64, int64, int64, error) {
+	if err := q.group(ctx); err != nil {
+		return 0, 0, 0, err
+	}
+
+	length, err := q.redis.XLen(ctx, q.key).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	pending, err := q.redis.XPending(ctx, q.key, StreamGroup).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	retrying, err := q.redis.ZCard(ctx, q.retryKey()).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	dead, err := q.redis.LLen(ctx, q.deadKey()).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return length - pending.Count, retrying, dead, nil
+}
+
+func (q *redisStream) Oldest(ctx context.Context) (time.Time, error) {
+	entries, err := q.redis.XRangeN(ctx, q.key, "-", "+", 1).Result()
+	if err != nil || len(entries) == 0 {
+		return time.Time{}, err
+	}
+
+	raw, _ := entries[0].Values["packet"].(string)
+	m := &Message{}
+	if decode(raw, m) == nil && m.Packet.Queued != 0 {
+		return queued(m.Packet), nil
+	}
+
+	// the entry id starts with the time it was added in milliseconds
+	ms, _ := strconv.ParseInt(strings.SplitN(entries[0].ID, "-", 2)[0], 10, 64)
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+func (q *redisStream) Replay(ctx context.Context, id string) error {
+	d, err := q.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	raw, err := replayed(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, q.deadKey(), 1, d.raw)
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: q.key, Values: []interface{}{"packet", raw}})
+		return nil
+	})
+	return err
+}
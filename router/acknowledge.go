@@ -1,37 +1,51 @@
 package router
 
 import (
-	"net/http"
-	"github.com/alexedwards/stack"
-	"database/sql"
 	"encoding/json"
+	"net/http"
+	"strconv"
 	"strings"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
 )
 
+// Acknowledge resolves (1), ignores (2) or reopens (0) the group, an ignored
+// one is not reopened by its new events
 func Acknowledge(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 
 	parts := strings.Split(r.URL.Path, "/")
 
 	var status int
-	if parts[3] == "1" {
-		status = 1
-	} else {
-		status = 0
+	switch parts[3] {
+	case "1":
+		status = storage.GroupResolved
+	case "2":
+		status = storage.GroupIgnored
+	default:
+		status = storage.GroupOpen
 	}
 
-	db := ctx.Get("db").(*sql.DB)
-
-	stmt, err := db.Prepare("UPDATE `group` SET status = ? WHERE id = ?")
+	id, err := strconv.ParseInt(parts[2], 10, 64)
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(status, parts[2])
+	repo := ctx.Get("repo").(storage.Storage)
+
+	err = repo.Groups().SetStatus(id, status)
 	if err != nil {
 		panic(err)
 	}
 
+	switch status {
+	case storage.GroupResolved:
+		ctx.Get("webhooks").(*webhook.Sender).Fire(webhook.Resolved, id)
+	case storage.GroupIgnored:
+		ctx.Get("webhooks").(*webhook.Sender).Fire(webhook.Ignored, id)
+	}
+
 	type data struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
@@ -0,0 +1,43 @@
+package router
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/notify"
+	"github.com/scr34m/proof/storage"
+)
+
+// EvaluationLimit is the number of evaluations on the page
+const EvaluationLimit = 100
+
+func Alerts(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	notifier := ctx.Get("notifier").(*notify.Dispatcher)
+	repo := ctx.Get("repo").(storage.Storage)
+
+	history, err := repo.Alerts().History(EvaluationLimit)
+	if err != nil {
+		panic(err)
+	}
+
+	data := struct {
+		Menu     string
+		MenuLink string
+		Version  string
+
+		Rules   []notify.RuleStatus
+		History []storage.Evaluation
+	}{
+		Menu:     "alerts",
+		MenuLink: "/alerts",
+		Version:  config.VERSION,
+		Rules:    notifier.Rules(),
+		History:  history,
+	}
+
+	templates := template.Must(template.ParseFiles("tpl/layout.html", "tpl/alerts.html"))
+	templates.Execute(w, data)
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
+)
+
+// validUser tells if the email address is of an enabled user
+func validUser(ctx *stack.Context, email string) bool {
+	for _, e := range ctx.Get("users").(storage.UserStore).Recipients() {
+		if e == email {
+			return true
+		}
+	}
+	return false
+}
+
+// Assign sets the user working on the group, one of the enabled users or
+// nobody
+func Assign(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(r.URL.Path, "/")
+
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		panic(err)
+	}
+
+	type data struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	d := data{}
+	d.Error = false
+	d.Message = "ok"
+
+	assignee := r.FormValue("assignee")
+
+	repo := ctx.Get("repo").(storage.Storage)
+
+	if sessionEmail(ctx, r) == "" {
+		d.Error = true
+		d.Message = "assigning needs the auth mode"
+	} else if assignee != "" && !validUser(ctx, assignee) {
+		d.Error = true
+		d.Message = "unknown user " + assignee
+	} else {
+		if _, err := repo.Groups().Get(id); err != nil {
+			panic(err)
+		}
+		if err := repo.Groups().Assign(id, assignee); err != nil {
+			panic(err)
+		}
+		if assignee != "" {
+			ctx.Get("webhooks").(*webhook.Sender).Fire(webhook.Assigned, id)
+		}
+	}
+
+	j, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
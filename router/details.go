@@ -1,7 +1,6 @@
 package router
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -14,13 +13,14 @@ import (
 	"github.com/alexedwards/stack"
 	"github.com/scr34m/proof/config"
 	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/storage"
 )
 
 func Details(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 
 	parts := strings.Split(r.URL.Path, "/")
 
-	db := ctx.Get("db").(*sql.DB)
+	repo := ctx.Get("repo").(storage.Storage)
 
 	type request struct {
 		Name      string
@@ -36,6 +36,8 @@ func Details(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 		Menu       string
 		MenuLink   string
 		Seen       int64
+		Sampled    int64
+		Stored     int64
 		Time       string
 		Url        string
 		Message    string
@@ -51,6 +53,13 @@ func Details(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 		User       map[string]string
 		Contexts   map[string]string
 		Version    string
+		Email      string
+		// Subscribed is the email preference of the logged in user for the
+		// issue
+		Subscribed string
+		Assignee   string
+		// Users are the enabled users the issue can be assigned to
+		Users []string
 	}
 
 	d := data{}
@@ -59,50 +68,77 @@ func Details(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 	d.GroupId = parts[2]
 	d.Version = config.VERSION
 
-	// Read the latest event from the group
-	var params []interface{}
-
-	query := "SELECT d.data, d.protocol, e.message, g.url, e.id, g.level, g.logger, g.server_name, g.platform, g.site, g.seen, g.last_seen FROM `group` g LEFT JOIN event e ON g.id = e.group_id LEFT JOIN `data` d ON e.data_id = d.id WHERE g.id = ? ORDER BY e.id DESC LIMIT 1"
-	params = append(params, d.GroupId)
-
-	if len(parts) == 4 {
-		d.CurrentId = parts[3]
-		params = append(params, d.CurrentId)
-		query = "SELECT d.data, d.protocol, e.message, g.url, e.id, g.level, g.logger, g.server_name, g.platform, g.site, g.seen, d.timestamp FROM `group` g LEFT JOIN event e ON g.id = e.group_id LEFT JOIN `data` d ON e.data_id = d.id WHERE g.id = ? AND e.id = ?"
-	}
-
-	stmt, err := db.Prepare(query)
+	groupId, err := strconv.ParseInt(d.GroupId, 10, 64)
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(params...).Scan(&d.Data, &d.Protocol, &d.Message, &d.Url, &d.CurrentId, &d.Level, &d.Logger, &d.ServerName, &d.Platform, &d.Site, &d.Seen, &d.Time)
+	g, err := repo.Groups().Get(groupId)
 	if err != nil {
 		panic(err)
 	}
 
-	// Older event from the group
-	stmt, err = db.Prepare("SELECT e.id FROM `group` g LEFT JOIN event e ON g.id = e.group_id WHERE g.id = ? AND e.id < ? ORDER BY e.id DESC LIMIT 1")
+	d.Email = sessionEmail(ctx, r)
+	if d.Email != "" {
+		s, err := repo.Subscriptions().Get(d.Email, g.ProjectId, g.Id)
+		if err != nil && err != storage.ErrNotFound {
+			panic(err)
+		}
+		d.Subscribed = s.Mode
+		d.Assignee = g.Assignee
+		d.Users = ctx.Get("users").(storage.UserStore).Recipients()
+	}
+
+	// Read the latest event from the group or the requested one
+	var e storage.Event
+	if len(parts) == 4 {
+		eventId, err := strconv.ParseInt(parts[3], 10, 64)
+		if err != nil {
+			panic(err)
+		}
+		e, err = repo.Events().Get(groupId, eventId)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		e, err = repo.Events().Latest(groupId)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	payload, err := repo.Payloads().Get(e.DataId)
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(d.GroupId, d.CurrentId).Scan(&d.OlderId)
-	if err != nil && err != sql.ErrNoRows {
-		panic(err)
+	d.CurrentId = strconv.FormatInt(e.Id, 10)
+	d.Data = payload.Data
+	d.Protocol = payload.Protocol
+	d.Message = e.Message
+	d.Url = g.Url
+	d.Level = g.Level
+	d.Logger = g.Logger
+	d.ServerName = g.ServerName
+	d.Platform = g.Platform
+	d.Site = g.Site
+	d.Seen = g.Seen
+	d.Sampled = g.Sampled
+	d.Stored = d.Seen - d.Sampled
+	if len(parts) == 4 {
+		d.Time = payload.Timestamp
+	} else {
+		d.Time = g.LastSeen
 	}
 
-	// Newer event from the group
-	stmt, err = db.Prepare("SELECT e.id FROM `group` g LEFT JOIN event e ON g.id = e.group_id WHERE g.id = ? AND e.id > ? ORDER BY e.id ASC LIMIT 1")
+	// Older and newer event from the group
+	d.OlderId, err = repo.Events().Older(groupId, e.Id)
 	if err != nil {
 		panic(err)
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(d.GroupId, d.CurrentId).Scan(&d.NewerId)
-	if err != nil && err != sql.ErrNoRows {
+	d.NewerId, err = repo.Events().Newer(groupId, e.Id)
+	if err != nil {
 		panic(err)
 	}
 
@@ -0,0 +1,36 @@
+package router
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/forward"
+)
+
+func Forward(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	forwarder := ctx.Get("forwarder").(*forward.Forwarder)
+
+	data := struct {
+		Menu     string
+		MenuLink string
+		Version  string
+
+		Enabled bool
+		Targets []forward.TargetStatus
+	}{
+		Menu:     "forward",
+		MenuLink: "/forward",
+		Version:  config.VERSION,
+	}
+
+	if forwarder != nil {
+		data.Enabled = true
+		data.Targets = forwarder.Status()
+	}
+
+	templates := template.Must(template.ParseFiles("tpl/layout.html", "tpl/forward.html"))
+	templates.Execute(w, data)
+}
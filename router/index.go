@@ -1,7 +1,6 @@
 package router
 
 import (
-	"database/sql"
 	"html/template"
 	"net/http"
 	"strings"
@@ -9,20 +8,23 @@ import (
 
 	"github.com/alexedwards/stack"
 	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/storage"
 )
 
 func Index(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 
-	db := ctx.Get("db").(*sql.DB)
+	repo := ctx.Get("repo").(storage.Storage)
 
-	rows, err := db.Query("SELECT id, seen, url, message, last_seen, site, server_name, project_id FROM `group` WHERE status = 0 ORDER BY last_seen DESC")
+	groups, err := repo.Groups().List(0)
 	if err != nil {
 		panic(err)
 	}
 
 	type event struct {
-		Id                int
-		Seen              int
+		Id                int64
+		Seen              int64
+		Sampled           int64
 		Url               string
 		Message           string
 		UrlOrMessageShort string
@@ -31,15 +33,24 @@ func Index(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 		ServerName        string
 		SiteOrServerName  string
 		Project           string
+		Platform          string
+		Type              string
 	}
 
 	var events []event
 
-	for rows.Next() {
-		event := event{}
-		err = rows.Scan(&event.Id, &event.Seen, &event.Url, &event.Message, &event.LastSeen, &event.Site, &event.ServerName, &event.Project)
-		if err != nil {
-			panic(err)
+	for _, g := range groups {
+		event := event{
+			Id:         g.Id,
+			Seen:       g.Seen,
+			Sampled:    g.Sampled,
+			Url:        g.Url,
+			Message:    g.Message,
+			LastSeen:   g.LastSeen,
+			Site:       g.Site,
+			ServerName: g.ServerName,
+			Project:    g.ProjectId,
+			Platform:   g.Platform,
 		}
 
 		if event.Url != "" {
@@ -59,6 +70,12 @@ func Index(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 			event.SiteOrServerName = event.ServerName
 		}
 
+		if event.Platform == parser.SecurityPlatform {
+			event.Type = "security"
+		} else {
+			event.Type = "error"
+		}
+
 		events = append(events, event)
 	}
 
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/live"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/queue"
+)
+
+// LivePing keeps the idle connections open through the proxies
+const LivePing = 30 * time.Second
+
+// NewNotice returns the notice of the processed event for the live updates
+func NewNotice(status *parser.ProcessStatus) queue.Notice {
+	return queue.Notice{
+		GroupId:    status.GroupId,
+		Project:    status.Project,
+		Level:      status.Level,
+		Message:    status.Message,
+		Url:        status.Url,
+		ServerName: status.ServerName,
+		Site:       status.Site,
+		Platform:   status.Platform,
+		Seen:       status.Seen,
+		LastSeen:   status.LastSeen.Format("2006-01-02 15:04:05"),
+		New:        status.IsNew,
+		Regression: status.IsRegression,
+		Sampled:    status.Sampled,
+	}
+}
+
+// Live streams the notices of the processed events as Server-Sent Events
+func Live(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+	hub := ctx.Get("live").(*live.Hub)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	notices, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ping := time.NewTicker(LivePing)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case n := <-notices:
+			j, err := json.Marshal(n)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Fprintf(w, "event: notice\ndata: %s\n\n", j)
+		}
+		flusher.Flush()
+	}
+}
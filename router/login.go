@@ -7,12 +7,13 @@ import (
 	"github.com/alexedwards/stack"
 	"github.com/gorilla/sessions"
 	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/storage"
 )
 
 func Login(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == "POST" {
-		auth := ctx.Get("auth").(*config.AuthConfig)
+		users := ctx.Get("users").(storage.UserStore)
 		store := ctx.Get("store").(*sessions.CookieStore)
 
 		err := r.ParseForm()
@@ -24,19 +25,17 @@ func Login(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 		email := r.FormValue("email")
 		password := r.FormValue("password")
 
-		for idx, user := range auth.User {
-			if user.Enabled && email == user.Email && password == user.Password {
-				session, _ := store.Get(r, config.SESSION_NAME)
-				session.Values[config.COOKIE_KEY_AUTH] = idx
-				err := session.Save(r, w)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-
-				http.Redirect(w, r, "/", http.StatusFound)
+		if idx, ok := users.Authenticate(email, password); ok {
+			session, _ := store.Get(r, config.SESSION_NAME)
+			session.Values[config.COOKIE_KEY_AUTH] = idx
+			err := session.Save(r, w)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
 		}
 
 		http.Redirect(w, r, "/login?error=true", http.StatusFound)
@@ -2,19 +2,27 @@ package router
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/alexedwards/stack"
-	"github.com/go-redis/redis/v8"
 	"github.com/nbari/violetear"
-	"github.com/scr34m/proof/config"
-	"github.com/scr34m/proof/mail"
+	"github.com/scr34m/proof/filter"
+	"github.com/scr34m/proof/forward"
+	"github.com/scr34m/proof/live"
+	"github.com/scr34m/proof/meter"
 	"github.com/scr34m/proof/notification"
+	"github.com/scr34m/proof/notify"
 	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/queue"
+	"github.com/scr34m/proof/sample"
+	"github.com/scr34m/proof/scrub"
 	"github.com/scr34m/proof/shared"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
 )
 
 func Parser(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
@@ -37,15 +45,55 @@ func Parser(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 		ProjectId: projectId,
 	}
 
+	dispatch(ctx, queuePacket)
+}
+
+// Security receives browser security reports (CSP, Expect-CT, NEL) and
+// feeds them as protocol 7 events to the normal ingest path
+func Security(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+	projectId := violetear.GetParam("num", r, 0)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	envelopes, err := parser.ParseSecurity(r.Header.Get("Content-Type"), body, r.UserAgent())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, envelope := range envelopes {
+		dispatch(ctx, shared.QueuePacket{
+			Body:      envelope,
+			Protocol:  "7",
+			ProjectId: projectId,
+		})
+	}
+}
+
+func dispatch(ctx *stack.Context, queuePacket shared.QueuePacket) {
 	if ctx.Get("queue").(bool) {
 		enqueue(ctx, queuePacket)
 		return
 	}
 
-	status, err := ProcessBody(ctx.Get("db").(*sql.DB), ctx.Get("auth").(*config.AuthConfig), ctx.Get("mailer").(*mail.Mailer), queuePacket)
+	m := ctx.Get("meter").(*meter.Meter)
+	m.In()
+
+	status, err := ProcessBody(ctx.Get("repo").(storage.Storage), ctx.Get("notifier").(notify.Notifier), ctx.Get("webhooks").(*webhook.Sender), ctx.Get("forwarder").(*forward.Forwarder), ctx.Get("scrubber").(*scrub.Scrubber), ctx.Get("filter").(*filter.Filter), ctx.Get("sampler").(*sample.Sampler), queuePacket)
 	if err != nil {
+		m.Fail(err, queuePacket)
 		panic(err)
 	}
+	m.Out()
+
+	if status == nil {
+		return
+	}
+
+	ctx.Get("live").(*live.Hub).Broadcast(NewNotice(status))
 
 	notif := ctx.Get("notif").(*notification.Notification)
 	if notif != nil && (status.IsNew || status.IsRegression) {
@@ -55,41 +103,149 @@ func Parser(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 
 func enqueue(ctx *stack.Context, queuePacket shared.QueuePacket) {
 	c := ctx.Get("ctx").(context.Context)
-	redis := ctx.Get("redis").(*redis.Client)
-	redisKey := ctx.Get("redisKey").(string)
+	events := ctx.Get("events").(queue.Queue)
 
-	queuePacketJson, err := json.Marshal(queuePacket)
+	queuePacket.Queued = time.Now().Unix()
+	err := events.Push(c, queuePacket)
 	if err != nil {
 		panic(err)
 	}
+	ctx.Get("meter").(*meter.Meter).In()
+}
 
-	err = redis.LPush(c, redisKey, queuePacketJson, 0).Err()
-	if err != nil {
-		panic(err)
+func ProcessBody(repo storage.Storage, notifier notify.Notifier, hooks *webhook.Sender, forwarder *forward.Forwarder, scrubber *scrub.Scrubber, filters *filter.Filter, sampler *sample.Sampler, queuePacket shared.QueuePacket) (*parser.ProcessStatus, error) {
+	statuses, errs := ProcessBatch(repo, notifier, hooks, forwarder, scrubber, filters, sampler, []shared.QueuePacket{queuePacket})
+	return statuses[0], errs[0]
+}
+
+// ProcessBatch stores the packets in one transaction, the status and the
+// error of a packet are at its index. The status is nil for a filtered
+// packet. When the transaction fails the packets are stored one by one to
+// find the failing ones. The stored packets are forwarded and announced once
+// after their commit, a failing notification does not fail them
+func ProcessBatch(repo storage.Storage, notifier notify.Notifier, hooks *webhook.Sender, forwarder *forward.Forwarder, scrubber *scrub.Scrubber, filters *filter.Filter, sampler *sample.Sampler, queuePackets []shared.QueuePacket) ([]*parser.ProcessStatus, []error) {
+	statuses := make([]*parser.ProcessStatus, len(queuePackets))
+	errs := make([]error, len(queuePackets))
+
+	var batch []*parser.Sentry
+	var index []int
+	// the scrubbed packets of the batch are forwarded once they are stored
+	var packets []shared.QueuePacket
+	for i, queuePacket := range queuePackets {
+		s, scrubbed, err := load(repo, scrubber, filters, sampler, queuePacket)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if s != nil {
+			batch = append(batch, s)
+			index = append(index, i)
+			packets = append(packets, scrubbed)
+		}
 	}
+
+	if len(batch) > 0 {
+		stored, err := store(repo, batch)
+		if err == nil {
+			for n, i := range index {
+				statuses[i] = stored[n]
+			}
+		} else if len(batch) == 1 {
+			errs[index[0]] = err
+		} else {
+			log.Printf("Storing a batch of %d events failed, storing them one by one: %v", len(batch), err)
+			for n, i := range index {
+				stored, err := store(repo, batch[n:n+1])
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				statuses[i] = stored[0]
+			}
+		}
+	}
+
+	for n, i := range index {
+		if statuses[i] != nil {
+			announce(statuses[i], batch[n], packets[n], notifier, hooks, forwarder)
+		}
+	}
+
+	return statuses, errs
 }
 
-func ProcessBody(db *sql.DB, auth *config.AuthConfig, mailer *mail.Mailer, queuePacket shared.QueuePacket) (*parser.ProcessStatus, error) {
-	s := parser.Sentry{Database: db}
-	err := s.Load(queuePacket)
-	if err != nil {
-		return nil, err
+// store runs the transaction of the events, a panic rolls it back and fails
+// them
+func store(repo storage.Storage, batch []*parser.Sentry) (statuses []*parser.ProcessStatus, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return parser.ProcessBatch(repo, batch)
+}
+
+// announce forwards the stored event, notifies about it and fires its
+// webhooks. A failure is only logged, the event is stored already
+func announce(status *parser.ProcessStatus, s *parser.Sentry, packet shared.QueuePacket, notifier notify.Notifier, hooks *webhook.Sender, forwarder *forward.Forwarder) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Announcing the event of group %d failed: %v", status.GroupId, r)
+		}
+	}()
+
+	if forwarder != nil {
+		forwarder.Forward(packet, s.Packet.Project, s.Packet.GetSeverity(), s.Packet.Environment)
+	}
+	if notifier != nil {
+		if err := notifier.Notify(status); err != nil {
+			log.Printf("Notifying about the event of group %d failed: %v", status.GroupId, err)
+		}
 	}
+	if hooks != nil {
+		if status.IsNew {
+			hooks.Fire(webhook.Created, status.GroupId)
+		} else if status.IsRegression {
+			hooks.Fire(webhook.Regressed, status.GroupId)
+		}
+		hooks.Fire(webhook.Event, status.GroupId)
+	}
+}
 
-	status, err := s.Process()
+// load returns the event of the packet and the scrubbed packet, nil when it
+// is filtered out
+func load(repo storage.Storage, scrubber *scrub.Scrubber, filters *filter.Filter, sampler *sample.Sampler, queuePacket shared.QueuePacket) (s *parser.Sentry, scrubbed shared.QueuePacket, err error) {
+	// a malformed packet must not fail the others of the batch
+	defer func() {
+		if r := recover(); r != nil {
+			s, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	if scrubber != nil {
+		queuePacket, err = scrubber.Packet(queuePacket)
+		if err != nil {
+			return nil, queuePacket, err
+		}
+	}
+
+	s = &parser.Sentry{Storage: repo}
+	if sampler != nil {
+		s.Sampler = sampler
+	}
+	err = s.Load(queuePacket)
 	if err != nil {
-		return nil, err
+		return nil, queuePacket, err
 	}
 
-	if mailer != nil && (status.IsNew || status.IsRegression) {
-		var recipients []string
-		for _, user := range auth.User {
-			if user.Enabled {
-				recipients = append(recipients, user.Email)
-			}
+	if filters != nil {
+		reason := filters.Check(&s.Packet, queuePacket.Protocol)
+		if reason != "" {
+			log.Printf("Event filtered: project %s, %s", s.Packet.Project, reason)
+			return nil, queuePacket, repo.Outcomes().Add(s.Packet.Project, reason)
 		}
-		mailer.Event(recipients, status)
 	}
 
-	return status, nil
+	return s, queuePacket, nil
 }
@@ -0,0 +1,133 @@
+package router
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/scr34m/proof/database"
+	"github.com/scr34m/proof/migrate"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/shared"
+	"github.com/scr34m/proof/storage"
+)
+
+// countingNotifier counts the notifications, it panics on the first with
+// fail
+type countingNotifier struct {
+	fail  bool
+	calls int
+}
+
+func (c *countingNotifier) Notify(status *parser.ProcessStatus) error {
+	c.calls++
+	if c.fail && c.calls == 1 {
+		panic("webhook down")
+	}
+	return nil
+}
+
+// failedBatch rolls back its first transaction once it is done
+type failedBatch struct {
+	storage.Storage
+	failed bool
+}
+
+func (f *failedBatch) Atomic(fn func(storage.Storage) error) error {
+	return f.Storage.Atomic(func(repo storage.Storage) error {
+		if err := fn(repo); err != nil || f.failed {
+			return err
+		}
+		f.failed = true
+		return errors.New("deadlock detected")
+	})
+}
+
+func sqlite(t *testing.T) storage.Storage {
+	t.Helper()
+
+	db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "proof.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrate.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	return storage.NewSQL(db, nil)
+}
+
+func packets(messages ...string) []shared.QueuePacket {
+	var list []shared.QueuePacket
+	for _, message := range messages {
+		body := "{}\n{\"type\":\"event\"}\n{\"level\":\"error\",\"timestamp\":1700000000,\"exception\":{\"values\":[{\"type\":\"Error\",\"value\":\"" + message + "\"}]}}\n"
+		list = append(list, shared.QueuePacket{Body: []byte(body), Protocol: "7", ProjectId: "1"})
+	}
+	return list
+}
+
+// seen returns the counter of the only group
+func seen(t *testing.T, repo storage.Storage) int64 {
+	t.Helper()
+
+	groups, err := repo.Groups().List(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("groups: %+v", groups)
+	}
+	return groups[0].Seen
+}
+
+func TestProcessBatchRolledBack(t *testing.T) {
+	repo := &failedBatch{Storage: sqlite(t)}
+	notifier := &countingNotifier{}
+
+	statuses, errs := ProcessBatch(repo, notifier, nil, nil, nil, nil, nil, packets("a", "b"))
+	for i := range statuses {
+		if errs[i] != nil || statuses[i] == nil {
+			t.Fatalf("event %d: %v", i, errs[i])
+		}
+	}
+	if !repo.failed {
+		t.Fatal("the batch was not rolled back")
+	}
+
+	// stored one by one after the rollback, announced once
+	if n := seen(t, repo); n != 2 {
+		t.Errorf("group seen %d times", n)
+	}
+	if notifier.calls != 2 {
+		t.Errorf("%d notifications", notifier.calls)
+	}
+	if !statuses[0].IsNew || statuses[1].IsNew {
+		t.Errorf("statuses: %+v %+v", statuses[0], statuses[1])
+	}
+}
+
+func TestProcessBatchNotifyPanic(t *testing.T) {
+	repo := sqlite(t)
+	notifier := &countingNotifier{fail: true}
+
+	_, errs := ProcessBatch(repo, notifier, nil, nil, nil, nil, nil, packets("a", "b"))
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("event %d failed: %v", i, err)
+		}
+	}
+
+	// the next event is notified, the stored ones are not stored again
+	if notifier.calls != 2 {
+		t.Errorf("%d notifications", notifier.calls)
+	}
+	if n := seen(t, repo); n != 2 {
+		t.Errorf("group seen %d times", n)
+	}
+}
@@ -0,0 +1,147 @@
+package router
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/notify"
+	"github.com/scr34m/proof/storage"
+)
+
+func Projects(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	repo := ctx.Get("repo").(storage.Storage)
+	settings := ctx.Get("settings").(*config.Config)
+
+	type project struct {
+		Id       string
+		Name     string
+		Groups   int
+		Seen     int
+		Filtered int
+	}
+
+	projects := make(map[string]*project)
+	for _, p := range settings.Project {
+		projects[p.Id] = &project{Id: p.Id, Name: p.Name}
+	}
+
+	counts, err := repo.Groups().Projects()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range counts {
+		p := project{Id: c.ProjectId, Groups: c.Groups, Seen: c.Seen}
+		if s, ok := projects[p.Id]; ok {
+			p.Name = s.Name
+		}
+		projects[p.Id] = &p
+	}
+
+	totals, err := repo.Outcomes().Totals()
+	if err != nil {
+		panic(err)
+	}
+
+	for id, filtered := range totals {
+		if _, ok := projects[id]; !ok {
+			projects[id] = &project{Id: id}
+		}
+		projects[id].Filtered = filtered
+	}
+
+	var list []project
+	for _, p := range projects {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+
+	data := struct {
+		Menu     string
+		MenuLink string
+		Version  string
+
+		Projects []project
+	}{
+		Menu:     "projects",
+		MenuLink: "/projects",
+		Version:  config.VERSION,
+		Projects: list,
+	}
+	templates := template.Must(template.ParseFiles("tpl/layout.html", "tpl/projects.html"))
+	templates.Execute(w, data)
+}
+
+func Project(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(r.URL.Path, "/")
+
+	repo := ctx.Get("repo").(storage.Storage)
+	settings := ctx.Get("settings").(*config.Config)
+
+	type outcome struct {
+		Reason   string
+		Today    int
+		Month    int
+		LastSeen string
+	}
+
+	today := time.Now().Format("2006-01-02")
+	month := time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+
+	summary, err := repo.Outcomes().Summary(parts[2], today, month)
+	if err != nil {
+		panic(err)
+	}
+
+	var outcomes []outcome
+	for _, o := range summary {
+		outcomes = append(outcomes, outcome{Reason: o.Reason, Today: o.Today, Month: o.Period, LastSeen: o.LastDay})
+	}
+
+	// the email preference of the logged in user
+	email := sessionEmail(ctx, r)
+	var subscribed string
+	if email != "" {
+		s, err := repo.Subscriptions().Get(email, parts[2], 0)
+		if err != nil && err != storage.ErrNotFound {
+			panic(err)
+		}
+		subscribed = s.Mode
+	}
+
+	data := struct {
+		Menu     string
+		MenuLink string
+		Version  string
+
+		Project    config.Project
+		Outcomes   []outcome
+		Email      string
+		Subscribed string
+		Modes      []string
+		Default    string
+	}{
+		Menu:       "project",
+		MenuLink:   "/project/" + parts[2],
+		Version:    config.VERSION,
+		Project:    settings.GetProject(parts[2]),
+		Outcomes:   outcomes,
+		Email:      email,
+		Subscribed: subscribed,
+		Modes:      notify.Modes,
+		Default:    "new issues and regressions",
+	}
+	// the alert rules email the users without a preference
+	if len(data.Project.Alert) > 0 {
+		data.Default = "alert rules only"
+	}
+	templates := template.Must(template.ParseFiles("tpl/layout.html", "tpl/project.html"))
+	templates.Execute(w, data)
+}
@@ -0,0 +1,202 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/meter"
+	"github.com/scr34m/proof/queue"
+	"github.com/scr34m/proof/spool"
+)
+
+const (
+	// DeadLetterLimit is the number of dead letters shown
+	DeadLetterLimit = 100
+	// FailureLimit is the number of processing failures shown
+	FailureLimit = 20
+)
+
+// Pipeline is the state of the ingest, the rates are events per second in
+// the last minute
+type Pipeline struct {
+	Enabled  bool              `json:"queue"`
+	Waiting  int64             `json:"waiting"`
+	Retrying int64             `json:"retrying"`
+	Dead     int64             `json:"dead"`
+	Oldest   string            `json:"oldest"`
+	Age      int64             `json:"age"`
+	In       float64           `json:"in"`
+	Out      float64           `json:"out"`
+	Workers  []queue.Heartbeat `json:"workers"`
+	Failures []queue.Failure   `json:"failures"`
+	Spool    spool.Status      `json:"spool"`
+	Error    string            `json:"error"`
+}
+
+// pipeline returns the state of this process in normal mode, or of the
+// queue and the workers in frontend mode
+func pipeline(ctx *stack.Context) Pipeline {
+	p := Pipeline{}
+
+	m := ctx.Get("meter").(*meter.Meter)
+	p.In, p.Out = m.Rates()
+	p.Failures = m.Failures()
+
+	if s, _ := ctx.Get("spool").(*spool.Spool); s != nil {
+		p.Spool = s.Status()
+	}
+
+	events, _ := ctx.Get("events").(queue.Queue)
+	if events == nil {
+		return p
+	}
+
+	c := ctx.Get("ctx").(context.Context)
+	p.Enabled = true
+
+	var err error
+	var oldest time.Time
+	p.Waiting, p.Retrying, p.Dead, err = events.Depth(c)
+	if err == nil {
+		oldest, err = events.Oldest(c)
+	}
+	if err == nil {
+		p.Workers, err = events.Workers(c)
+	}
+	// the spool is still worth seeing while the queue is down
+	if err != nil {
+		p.Error = err.Error()
+	}
+
+	if !oldest.IsZero() {
+		p.Oldest = oldest.Format("2006-01-02 15:04:05")
+		p.Age = int64(time.Since(oldest).Seconds())
+	}
+
+	// processed by the workers
+	p.Out = 0
+	p.Failures = nil
+	for _, h := range p.Workers {
+		p.Out += h.Rate
+		for _, f := range h.Failures {
+			f.Worker = h.Name
+			p.Failures = append(p.Failures, f)
+		}
+	}
+	p.Out = math.Round(p.Out*100) / 100
+
+	sort.Slice(p.Failures, func(i, j int) bool { return p.Failures[i].Time.After(p.Failures[j].Time) })
+	if len(p.Failures) > FailureLimit {
+		p.Failures = p.Failures[:FailureLimit]
+	}
+	return p
+}
+
+func Queue(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	data := struct {
+		Menu     string
+		MenuLink string
+		Version  string
+
+		Pipeline
+		Letters []queue.DeadLetter
+	}{
+		Menu:     "queue",
+		MenuLink: "/queue",
+		Version:  config.VERSION,
+		Pipeline: pipeline(ctx),
+	}
+
+	if data.Enabled && data.Error == "" {
+		var err error
+		data.Letters, err = ctx.Get("events").(queue.Queue).Dead(ctx.Get("ctx").(context.Context), DeadLetterLimit)
+		if err != nil {
+			data.Error = err.Error()
+		}
+	}
+
+	templates := template.Must(template.ParseFiles("tpl/layout.html", "tpl/queue.html"))
+	templates.Execute(w, data)
+}
+
+func QueueReplay(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+	deadLetter(ctx, w, r, (queue.Queue).Replay)
+}
+
+func QueueDiscard(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+	deadLetter(ctx, w, r, (queue.Queue).Discard)
+}
+
+func deadLetter(ctx *stack.Context, w http.ResponseWriter, r *http.Request, action func(queue.Queue, context.Context, string) error) {
+
+	parts := strings.Split(r.URL.Path, "/")
+
+	events, _ := ctx.Get("events").(queue.Queue)
+	if events == nil {
+		http.Error(w, "Queue mode is not enabled", http.StatusNotFound)
+		return
+	}
+
+	type data struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	d := data{}
+	d.Error = false
+	d.Message = "ok"
+
+	err := action(events, ctx.Get("ctx").(context.Context), parts[3])
+	if err == queue.ErrNotFound {
+		d.Error = true
+		d.Message = err.Error()
+	} else if err != nil {
+		panic(err)
+	}
+
+	j, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// QueueSpool reports the events waiting in the spool of the frontend
+func QueueSpool(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	d := spool.Status{}
+	if s, _ := ctx.Get("spool").(*spool.Spool); s != nil {
+		d = s.Status()
+	}
+
+	j, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// QueueStats reports the state of the ingest pipeline
+func QueueStats(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	j, err := json.Marshal(pipeline(ctx))
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
@@ -1,27 +1,19 @@
 package router
 
 import (
-	"net/http"
-	"github.com/alexedwards/stack"
-	"database/sql"
 	"encoding/json"
+	"net/http"
 	"strings"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/storage"
 )
 
 func Status(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 
 	parts := strings.Split(r.URL.Path, "/")
 
-	db := ctx.Get("db").(*sql.DB)
-
-	stmt, err := db.Prepare("SELECT MAX(last_seen) AS last, COUNT(*) AS c FROM `group` WHERE status = 0 AND last_seen > ?")
-	if err != nil {
-		panic(err)
-	}
-	defer stmt.Close()
-
-	var last string
-	var c int
+	repo := ctx.Get("repo").(storage.Storage)
 
 	type data struct {
 		Error bool   `json:"error"`
@@ -32,13 +24,12 @@ func Status(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
 	d := data{}
 	d.Error = false
 
-	err = stmt.QueryRow(parts[2]).Scan(&last, &c)
+	last, c, err := repo.Groups().Changed(parts[2])
 	if err != nil {
-		// XXX Scan error on column index 0
-	} else {
-		d.Time = last
-		d.Count = c
+		panic(err)
 	}
+	d.Time = last
+	d.Count = c
 
 	j, err := json.Marshal(d)
 	if err != nil {
@@ -0,0 +1,150 @@
+package router
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alexedwards/stack"
+	"github.com/gorilla/sessions"
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/notify"
+	"github.com/scr34m/proof/storage"
+)
+
+// sessionEmail returns the email address of the logged in user, empty
+// without the auth mode
+func sessionEmail(ctx *stack.Context, r *http.Request) string {
+	if ctx.Get("auth").(*config.AuthConfig) == nil {
+		return ""
+	}
+
+	store := ctx.Get("store").(*sessions.CookieStore)
+	session, _ := store.Get(r, config.SESSION_NAME)
+	id, ok := session.Values[config.COOKIE_KEY_AUTH].(int)
+	if !ok {
+		return ""
+	}
+
+	email, _ := ctx.Get("users").(storage.UserStore).Email(id)
+	return email
+}
+
+// validMode tells if the mode is an email preference, empty clears it
+func validMode(mode string) bool {
+	if mode == "" {
+		return true
+	}
+	for _, m := range notify.Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe sets the email preference of the user for the issue, watching
+// is every event and muting is none
+func Subscribe(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(r.URL.Path, "/")
+
+	groupId, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		panic(err)
+	}
+
+	repo := ctx.Get("repo").(storage.Storage)
+
+	g, err := repo.Groups().Get(groupId)
+	if err != nil {
+		panic(err)
+	}
+
+	subscribe(ctx, w, r, g.ProjectId, g.Id)
+}
+
+// ProjectSubscribe sets the email preference of the user for the project
+func ProjectSubscribe(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(r.URL.Path, "/")
+
+	subscribe(ctx, w, r, parts[3], 0)
+}
+
+func subscribe(ctx *stack.Context, w http.ResponseWriter, r *http.Request, projectId string, groupId int64) {
+
+	type data struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	d := data{}
+	d.Error = false
+	d.Message = "ok"
+
+	email := sessionEmail(ctx, r)
+	mode := r.FormValue("mode")
+
+	repo := ctx.Get("repo").(storage.Storage)
+
+	if email == "" {
+		d.Error = true
+		d.Message = "email notifications need the auth mode"
+	} else if !validMode(mode) {
+		d.Error = true
+		d.Message = "unknown mode " + mode
+	} else if mode == "" {
+		if err := repo.Subscriptions().Delete(email, projectId, groupId); err != nil {
+			panic(err)
+		}
+	} else {
+		err := repo.Subscriptions().Set(storage.Subscription{Email: email, ProjectId: projectId, GroupId: groupId, Mode: mode})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	j, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// Unsubscribe stops the emails of a project by the link of an email without
+// logging in, a GET asks for confirmation so a link preview can not
+// unsubscribe. The mail clients POST the same url for one click unsubscribe
+func Unsubscribe(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	q := r.URL.Query()
+
+	data := struct {
+		Email   string
+		Project string
+		Done    bool
+		Error   string
+	}{
+		Email:   q.Get("email"),
+		Project: q.Get("project"),
+	}
+
+	if r.Method == "POST" {
+		err := ctx.Get("notifier").(*notify.Dispatcher).Unsubscribe(data.Email, data.Project, q.Get("token"))
+		if err == notify.ErrToken {
+			w.WriteHeader(http.StatusForbidden)
+			data.Error = err.Error()
+		} else if err != nil {
+			panic(err)
+		} else {
+			data.Done = true
+		}
+	}
+
+	templates := template.Must(template.ParseFiles("tpl/unsubscribe.html"))
+	templates.Execute(w, data)
+}
@@ -0,0 +1,44 @@
+package router
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/shared"
+)
+
+// Tunnel accepts envelopes forwarded by the application backend for the SDK
+// tunnel option, authentication is done with the dsn of the envelope header
+func Tunnel(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	key, projectId, err := parser.GetEnvelopeDsn(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	auth := ctx.Get("auth").(*config.AuthConfig)
+	if auth != nil && !auth.AllowKey(key, projectId) {
+		log.Printf("[%s] %q %v\n", r.Method, r.URL.String(), "Authentication error")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !parser.HasEnvelopeEvent(body) {
+		return
+	}
+
+	dispatch(ctx, shared.QueuePacket{
+		Body:      body,
+		Protocol:  "7",
+		ProjectId: projectId,
+	})
+}
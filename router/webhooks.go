@@ -0,0 +1,81 @@
+package router
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alexedwards/stack"
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/storage"
+	"github.com/scr34m/proof/webhook"
+)
+
+// DeliveryLimit is the number of deliveries on the page
+const DeliveryLimit = 100
+
+func Webhooks(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	hooks := ctx.Get("webhooks").(*webhook.Sender)
+	repo := ctx.Get("repo").(storage.Storage)
+
+	deliveries, err := repo.Deliveries().List(DeliveryLimit)
+	if err != nil {
+		panic(err)
+	}
+
+	data := struct {
+		Menu     string
+		MenuLink string
+		Version  string
+
+		Webhooks   []webhook.Status
+		Deliveries []storage.Delivery
+	}{
+		Menu:       "webhooks",
+		MenuLink:   "/webhooks",
+		Version:    config.VERSION,
+		Webhooks:   hooks.Webhooks(),
+		Deliveries: deliveries,
+	}
+
+	templates := template.Must(template.ParseFiles("tpl/layout.html", "tpl/webhooks.html"))
+	templates.Execute(w, data)
+}
+
+func WebhookRedeliver(ctx *stack.Context, w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(r.URL.Path, "/")
+
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		panic(err)
+	}
+
+	type data struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	d := data{}
+	d.Error = false
+	d.Message = "ok"
+
+	err = ctx.Get("webhooks").(*webhook.Sender).Redeliver(id)
+	if err == storage.ErrNotFound {
+		d.Error = true
+		d.Message = "delivery is pending or gone"
+	} else if err != nil {
+		panic(err)
+	}
+
+	j, err := json.Marshal(d)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
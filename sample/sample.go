@@ -0,0 +1,185 @@
+package sample
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/filter"
+	"github.com/scr34m/proof/parser"
+)
+
+const (
+	ReasonSampled = "sampled"
+	ReasonSpike   = "spike-protection"
+
+	DefaultSpikeThreshold = 1000
+	DefaultSpikeRate      = 0.1
+)
+
+type rule struct {
+	config.SampleRule
+	environment *regexp.Regexp
+	release     *regexp.Regexp
+}
+
+type rules struct {
+	list           []rule
+	spikeThreshold int
+	spikeRate      float64
+}
+
+// window counts the events of a group in the current minute
+type window struct {
+	minute int64
+	count  int
+	spike  bool
+}
+
+type Sampler struct {
+	projects map[string]*rules
+	fallback *rules
+
+	mu      sync.Mutex
+	windows map[int64]*window
+	rand    *rand.Rand
+}
+
+func NewSampler(settings *config.Config) (*Sampler, error) {
+	s := &Sampler{
+		projects: make(map[string]*rules),
+		fallback: &rules{spikeThreshold: DefaultSpikeThreshold, spikeRate: DefaultSpikeRate},
+		windows:  make(map[int64]*window),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, p := range settings.Project {
+		r := &rules{spikeThreshold: p.Sampling.SpikeThreshold, spikeRate: DefaultSpikeRate}
+		if r.spikeThreshold == 0 {
+			r.spikeThreshold = DefaultSpikeThreshold
+		}
+		if p.Sampling.SpikeRate != nil {
+			r.spikeRate = *p.Sampling.SpikeRate
+			if r.spikeRate < 0 || r.spikeRate > 1 {
+				return nil, fmt.Errorf("project %s: spike rate %v out of range", p.Id, r.spikeRate)
+			}
+		}
+
+		for _, sr := range p.Sampling.Rules {
+			if sr.Rate < 0 || sr.Rate > 1 {
+				return nil, fmt.Errorf("project %s: sample rate %v out of range", p.Id, sr.Rate)
+			}
+			ru := rule{SampleRule: sr}
+			var err error
+			if sr.Environment != "" {
+				if ru.environment, err = filter.Glob(sr.Environment); err != nil {
+					return nil, fmt.Errorf("project %s: %v", p.Id, err)
+				}
+			}
+			if sr.Release != "" {
+				if ru.release, err = filter.Glob(sr.Release); err != nil {
+					return nil, fmt.Errorf("project %s: %v", p.Id, err)
+				}
+			}
+			r.list = append(r.list, ru)
+		}
+		s.projects[p.Id] = r
+	}
+
+	return s, nil
+}
+
+// Keep returns the reason when the payload of the event should not be
+// stored, the pending events of the batch count for the spike protection
+func (s *Sampler) Keep(groupId int64, p *parser.Packet, pending int) string {
+	r := s.rules(p.Project)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.spikeThreshold > 0 {
+		w := s.window(groupId, r.spikeThreshold)
+		if w.spike || w.count+pending+1 > r.spikeThreshold {
+			if s.rand.Float64() >= r.spikeRate {
+				return ReasonSpike
+			}
+			return ""
+		}
+	}
+
+	for _, ru := range r.list {
+		if ru.Level != "" && !strings.EqualFold(ru.Level, p.GetSeverity()) {
+			continue
+		}
+		if ru.environment != nil && !ru.environment.MatchString(p.Environment) {
+			continue
+		}
+		if ru.release != nil && !ru.release.MatchString(p.Release) {
+			continue
+		}
+		if s.rand.Float64() >= ru.Rate {
+			return ReasonSampled
+		}
+		return ""
+	}
+
+	return ""
+}
+
+// Count adds the stored events of the group to its minute, the spike
+// protection starts over the threshold
+func (s *Sampler) Count(groupId int64, projectId string, n int) {
+	r := s.rules(projectId)
+	if r.spikeThreshold <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.window(groupId, r.spikeThreshold)
+	w.count += n
+	if w.count > r.spikeThreshold && !w.spike {
+		log.Printf("Spike protection started for group %d", groupId)
+		w.spike = true
+	}
+}
+
+func (s *Sampler) rules(projectId string) *rules {
+	if r, ok := s.projects[projectId]; ok {
+		return r
+	}
+	return s.fallback
+}
+
+// window returns the counter of the group in the current minute
+func (s *Sampler) window(groupId int64, threshold int) *window {
+	minute := time.Now().Unix() / 60
+
+	w, ok := s.windows[groupId]
+	if !ok {
+		if len(s.windows) > 10000 {
+			for id, old := range s.windows {
+				if old.minute < minute {
+					delete(s.windows, id)
+				}
+			}
+		}
+		w = &window{minute: minute}
+		s.windows[groupId] = w
+	}
+	if w.minute != minute {
+		if w.spike && w.count <= threshold {
+			log.Printf("Spike protection ended for group %d", groupId)
+			w.spike = false
+		}
+		w.minute = minute
+		w.count = 0
+	}
+	return w
+}
@@ -0,0 +1,41 @@
+package sample
+
+import (
+	"testing"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/parser"
+)
+
+func TestSpike(t *testing.T) {
+	none := 0.0
+	s, err := NewSampler(&config.Config{Project: []config.Project{{Id: "1", Sampling: config.Sampling{SpikeThreshold: 3, SpikeRate: &none}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &parser.Packet{Project: "1"}
+
+	// asking does not count
+	for i := 0; i < 10; i++ {
+		if reason := s.Keep(1, p, 0); reason != "" {
+			t.Fatalf("sampled before any event: %s", reason)
+		}
+	}
+
+	// the pending events of the batch do
+	if reason := s.Keep(1, p, 2); reason != "" {
+		t.Fatalf("third event sampled: %s", reason)
+	}
+	if reason := s.Keep(1, p, 3); reason != ReasonSpike {
+		t.Fatalf("fourth event kept: %q", reason)
+	}
+
+	s.Count(1, "1", 3)
+	if reason := s.Keep(1, p, 0); reason != ReasonSpike {
+		t.Fatalf("event after three stored kept: %q", reason)
+	}
+	// every group has its own window
+	if reason := s.Keep(2, p, 0); reason != "" {
+		t.Fatalf("event of another group sampled: %s", reason)
+	}
+}
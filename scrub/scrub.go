@@ -0,0 +1,399 @@
+package scrub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/parser"
+	"github.com/scr34m/proof/shared"
+)
+
+/**
+ * https://docs.sentry.io/product/data-management-settings/scrubbing/server-side-scrubbing/
+ */
+
+const (
+	Filtered = "[Filtered]"
+
+	ActionMask   = "mask"
+	ActionHash   = "hash"
+	ActionRemove = "remove"
+)
+
+// Keys matched as case insensitive substrings by the built-in rules
+var sensitiveKeys = []string{
+	"password", "passwd", "secret", "api_key", "apikey", "access_token", "auth",
+	"credentials", "mysql_pwd", "privatekey", "private_key", "token", "cookie",
+}
+
+var creditCardPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,15}\d\b`)
+
+var ipPattern = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b|\b(?:[0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}\b`)
+
+type rule struct {
+	key     string
+	pattern *regexp.Regexp
+	path    []string
+	action  string
+}
+
+type rules struct {
+	defaults bool
+	ip       bool
+	custom   []rule
+}
+
+type Scrubber struct {
+	projects map[string]*rules
+	fallback *rules
+}
+
+func NewScrubber(settings *config.Config) (*Scrubber, error) {
+	s := &Scrubber{
+		projects: make(map[string]*rules),
+		fallback: &rules{defaults: true},
+	}
+
+	for _, p := range settings.Project {
+		r := &rules{defaults: !p.Scrub.Disabled, ip: p.Scrub.Ip}
+		for _, cr := range p.Scrub.Rules {
+			ru := rule{key: strings.ToLower(cr.Key), action: cr.Action}
+			if ru.action == "" {
+				ru.action = ActionMask
+			}
+			if ru.action != ActionMask && ru.action != ActionHash && ru.action != ActionRemove {
+				return nil, fmt.Errorf("project %s: unknown scrub action %q", p.Id, cr.Action)
+			}
+			if cr.Pattern != "" {
+				re, err := regexp.Compile(cr.Pattern)
+				if err != nil {
+					return nil, fmt.Errorf("project %s: %v", p.Id, err)
+				}
+				ru.pattern = re
+			}
+			if cr.Path != "" {
+				ru.path = strings.Split(strings.ToLower(cr.Path), ".")
+			}
+			r.custom = append(r.custom, ru)
+		}
+		s.projects[p.Id] = r
+	}
+
+	return s, nil
+}
+
+// Packet returns the packet with the event payload scrubbed, the body keeps
+// its original encoding
+func (s *Scrubber) Packet(qpacket shared.QueuePacket) (shared.QueuePacket, error) {
+	var err error
+	if qpacket.Protocol == "7" {
+		qpacket.Body, err = s.envelope(qpacket.Body, qpacket.ProjectId)
+	} else {
+		qpacket.Body, err = s.store(qpacket.Body)
+	}
+	return qpacket, err
+}
+
+func (s *Scrubber) rules(projectId string) *rules {
+	if r, ok := s.projects[projectId]; ok {
+		return r
+	}
+	return s.fallback
+}
+
+func (s *Scrubber) envelope(body []byte, projectId string) ([]byte, error) {
+	compressed := len(body) > 1 && body[0] == 0x1f && body[1] == 0x8b
+
+	p, err := parser.ReadEnvelope(body)
+	if err != nil {
+		return nil, err
+	}
+
+	header, items, err := parser.SplitEnvelope(p)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for i, item := range items {
+		if item.Type != "event" {
+			continue
+		}
+		found = true
+
+		event, err := decode(item.Payload)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := encode(s.rules(projectId).apply(event))
+		if err != nil {
+			return nil, err
+		}
+		items[i].Payload = payload
+
+		// the item length would not match anymore
+		h, err := decode(item.Header)
+		if err == nil {
+			if m, ok := h.(map[string]interface{}); ok {
+				if _, ok := m["length"]; ok {
+					m["length"] = len(payload)
+					if b, err := encode(m); err == nil {
+						items[i].Header = b
+					}
+				}
+			}
+		}
+		break
+	}
+	if !found {
+		return body, nil
+	}
+
+	out := parser.JoinEnvelope(header, items)
+	if !compressed {
+		return out, nil
+	}
+
+	var b bytes.Buffer
+	z := gzip.NewWriter(&b)
+	z.Write(out)
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (s *Scrubber) store(body []byte) ([]byte, error) {
+	c, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return nil, err
+	}
+	z, err := zlib.NewReader(bytes.NewReader(c))
+	if err != nil {
+		return nil, err
+	}
+	p, err := ioutil.ReadAll(z)
+	z.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := decode(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var projectId string
+	if m, ok := event.(map[string]interface{}); ok {
+		projectId = fmt.Sprint(m["project"])
+	}
+
+	payload, err := encode(s.rules(projectId).apply(event))
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(payload)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(b.Bytes())), nil
+}
+
+func (r *rules) apply(v interface{}) interface{} {
+	out, _ := r.walk(v, nil)
+	return out
+}
+
+// walk returns the scrubbed value and false when it has to be removed
+func (r *rules) walk(v interface{}, path []string) (interface{}, bool) {
+	for _, ru := range r.custom {
+		if ru.path != nil && matchPath(ru.path, path) {
+			return ru.replace(v)
+		}
+		if ru.key != "" && len(path) > 0 && strings.ToLower(path[len(path)-1]) == ru.key {
+			return ru.replace(v)
+		}
+	}
+
+	if r.defaults && len(path) > 0 && sensitiveKey(path[len(path)-1]) {
+		return Filtered, true
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			out, keep := r.walk(child, append(path, k))
+			if keep {
+				t[k] = out
+			} else {
+				delete(t, k)
+			}
+		}
+		return t, true
+	case []interface{}:
+		// headers and cookies may be sent as list of pairs
+		if len(t) == 2 {
+			if k, ok := t[0].(string); ok && len(path) > 0 && !isIndex(path[len(path)-1]) {
+				if _, ok := t[1].(string); ok && r.defaults && sensitiveKey(k) {
+					return []interface{}{k, Filtered}, true
+				}
+			}
+		}
+		var list []interface{}
+		for i, child := range t {
+			out, keep := r.walk(child, append(path, strconv.Itoa(i)))
+			if keep {
+				list = append(list, out)
+			}
+		}
+		if list == nil {
+			list = []interface{}{}
+		}
+		return list, true
+	case string:
+		return r.value(t, path), true
+	}
+	return v, true
+}
+
+func (r *rules) value(s string, path []string) string {
+	for _, ru := range r.custom {
+		if ru.pattern == nil || ru.path != nil || ru.key != "" {
+			continue
+		}
+		s = ru.pattern.ReplaceAllStringFunc(s, func(m string) string {
+			out, keep := ru.replace(m)
+			if !keep {
+				return ""
+			}
+			return out.(string)
+		})
+	}
+
+	if r.defaults {
+		s = creditCardPattern.ReplaceAllStringFunc(s, func(m string) string {
+			if luhn(m) {
+				return Filtered
+			}
+			return m
+		})
+	}
+
+	if r.ip {
+		if len(path) > 0 && strings.ToLower(path[len(path)-1]) == "ip_address" {
+			return Filtered
+		}
+		s = ipPattern.ReplaceAllString(s, Filtered)
+	}
+	return s
+}
+
+func (ru rule) replace(v interface{}) (interface{}, bool) {
+	// pattern with key or path only replaces the matching part
+	if s, ok := v.(string); ok && ru.pattern != nil && (ru.key != "" || ru.path != nil) {
+		s = ru.pattern.ReplaceAllStringFunc(s, func(m string) string {
+			out, keep := rule{action: ru.action}.replace(m)
+			if !keep {
+				return ""
+			}
+			return out.(string)
+		})
+		return s, true
+	}
+
+	switch ru.action {
+	case ActionRemove:
+		return nil, false
+	case ActionHash:
+		b, _ := json.Marshal(v)
+		if s, ok := v.(string); ok {
+			b = []byte(s)
+		}
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:]), true
+	}
+
+	if s, ok := v.(string); ok {
+		return strings.Repeat("*", len(s)), true
+	}
+	return Filtered, true
+}
+
+func matchPath(rule []string, path []string) bool {
+	if len(rule) != len(path) {
+		return false
+	}
+	for i := range rule {
+		if rule[i] != "*" && rule[i] != strings.ToLower(path[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sensitiveKey(k string) bool {
+	k = strings.ToLower(k)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isIndex(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func luhn(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+func decode(b []byte) (interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err := d.Decode(&v)
+	return v, err
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b.Bytes(), "\n"), nil
+}
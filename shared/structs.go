@@ -4,4 +4,8 @@ type QueuePacket struct {
 	Body      []byte `json:"body"`
 	Protocol  string `json:"protocol"`
 	ProjectId string `json:"project_id"`
+	// Attempts counts the failed processing of the packet
+	Attempts int `json:"attempts,omitempty"`
+	// Queued is the unix time the frontend received the packet
+	Queued int64 `json:"queued,omitempty"`
 }
@@ -0,0 +1,289 @@
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scr34m/proof/queue"
+	"github.com/scr34m/proof/shared"
+)
+
+const (
+	// PushTimeout of the queue before the packet is spooled
+	PushTimeout   = 2 * time.Second
+	DrainInterval = 5 * time.Second
+)
+
+var ErrFull = errors.New("queue spool is full")
+
+// Status of the spool, Oldest and Age are empty without spooled packets
+type Status struct {
+	Enabled   bool   `json:"enabled"`
+	Down      bool   `json:"down"`
+	Depth     int    `json:"depth"`
+	Bytes     int64  `json:"bytes"`
+	Oldest    string `json:"oldest"`
+	Age       int64  `json:"age"`
+	Spooled   int64  `json:"spooled"`
+	Drained   int64  `json:"drained"`
+	Dropped   int64  `json:"dropped"`
+	LastError string `json:"last_error"`
+}
+
+// Spool keeps the packets in a directory while the queue can not take them,
+// they are pushed to the queue in order when it is back. The other methods
+// of the queue are used as they are
+type Spool struct {
+	queue.Queue
+	dir      string
+	maxFiles int
+	maxBytes int64
+
+	mu        sync.Mutex
+	files     int
+	bytes     int64
+	oldest    time.Time
+	down      bool
+	spooled   int64
+	drained   int64
+	dropped   int64
+	lastError string
+}
+
+func NewSpool(events queue.Queue, dir string, maxFiles int, maxBytes int64) (*Spool, error) {
+	s := &Spool{
+		Queue:    events,
+		dir:      dir,
+		maxFiles: maxFiles,
+		maxBytes: maxBytes,
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	// left by a previous run
+	files, err := s.backlog()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			s.files++
+			s.bytes += fi.Size()
+		}
+	}
+	if len(files) > 0 {
+		s.oldest = created(files[0])
+		s.down = true
+		log.Printf("%d events in the queue spool", len(files))
+	}
+	return s, nil
+}
+
+// Push queues the packet or spools it when the queue fails or the spool is
+// not empty yet
+func (s *Spool) Push(ctx context.Context, packet shared.QueuePacket) error {
+	s.mu.Lock()
+	down := s.down
+	s.mu.Unlock()
+
+	if !down {
+		c, cancel := context.WithTimeout(ctx, PushTimeout)
+		err := s.Queue.Push(c, packet)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("Queue push failed, spooling: %v", err)
+		s.mu.Lock()
+		s.down = true
+		s.lastError = err.Error()
+		s.mu.Unlock()
+	}
+	return s.write(packet)
+}
+
+func (s *Spool) write(packet shared.QueuePacket) error {
+	j, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if (s.maxFiles > 0 && s.files >= s.maxFiles) || (s.maxBytes > 0 && s.bytes+int64(len(j)) > s.maxBytes) {
+		s.dropped++
+		return ErrFull
+	}
+
+	now := time.Now()
+	file := filepath.Join(s.dir, fmt.Sprintf("%d.json", now.UnixNano()))
+	err = ioutil.WriteFile(file+".tmp", j, 0644)
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	if err != nil {
+		os.Remove(file + ".tmp")
+		return err
+	}
+
+	if s.files == 0 {
+		s.oldest = now
+	}
+	s.files++
+	s.bytes += int64(len(j))
+	s.spooled++
+	return nil
+}
+
+// Start drains the spool periodically until the context is done
+func (s *Spool) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(DrainInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.drain(ctx)
+			}
+		}
+	}()
+}
+
+// drain pushes the spooled packets oldest first and stops at the first
+// failure, the spool is bypassed again once it is empty
+func (s *Spool) drain(ctx context.Context) {
+	s.mu.Lock()
+	down := s.down
+	s.mu.Unlock()
+	if !down {
+		return
+	}
+
+	files, err := s.backlog()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	for i, file := range files {
+		if ctx.Err() != nil {
+			return
+		}
+
+		next := time.Time{}
+		if i+1 < len(files) {
+			next = created(files[i+1])
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		packet := shared.QueuePacket{}
+		if err := json.Unmarshal(b, &packet); err != nil {
+			log.Printf("Dropping unreadable spool file %s: %v", file, err)
+			s.remove(file, int64(len(b)), next, false)
+			continue
+		}
+
+		c, cancel := context.WithTimeout(ctx, PushTimeout)
+		err = s.Queue.Push(c, packet)
+		cancel()
+		if err != nil {
+			s.mu.Lock()
+			s.lastError = err.Error()
+			s.mu.Unlock()
+			return
+		}
+		s.remove(file, int64(len(b)), next, true)
+	}
+
+	// a packet may have been spooled meanwhile, leave it for the next round
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == 0 {
+		s.down = false
+		s.oldest = time.Time{}
+		log.Printf("Queue spool drained")
+	}
+}
+
+// remove deletes the file, next is the time of the following one
+func (s *Spool) remove(file string, size int64, next time.Time, drained bool) {
+	if err := os.Remove(file); err != nil {
+		log.Print(err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files--
+	s.bytes -= size
+	if drained {
+		s.drained++
+	}
+	if !next.IsZero() {
+		s.oldest = next
+	} else if files, err := s.backlog(); err == nil && len(files) > 0 {
+		// spooled while draining
+		s.oldest = created(files[0])
+	}
+}
+
+// backlog returns the spooled files, oldest first
+func (s *Spool) backlog() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// created returns the spooling time from the file name
+func created(file string) time.Time {
+	var nano int64
+	fmt.Sscanf(strings.TrimSuffix(filepath.Base(file), ".json"), "%d", &nano)
+	return time.Unix(0, nano)
+}
+
+func (s *Spool) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Status{
+		Enabled:   true,
+		Down:      s.down,
+		Depth:     s.files,
+		Bytes:     s.bytes,
+		Spooled:   s.spooled,
+		Drained:   s.drained,
+		Dropped:   s.dropped,
+		LastError: s.lastError,
+	}
+	if !s.oldest.IsZero() {
+		st.Oldest = s.oldest.Format("2006-01-02 15:04:05")
+		st.Age = int64(time.Since(s.oldest).Seconds())
+	}
+	return st
+}
@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/storage"
+)
+
+func TestAlerts(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now()
+
+		check(t, repo.Alerts().Count(1, now, "u1"))
+		check(t, repo.Alerts().Count(1, now, "u2"))
+		check(t, repo.Alerts().Count(1, now, "u1"))
+		check(t, repo.Alerts().Count(1, now.Add(-time.Hour), ""))
+		check(t, repo.Alerts().Count(2, now, "u1"))
+
+		events, err := repo.Alerts().Events(1, now.Add(-time.Minute))
+		check(t, err)
+		if events != 3 {
+			t.Fatalf("events: %d", events)
+		}
+		users, err := repo.Alerts().Users(1, now.Add(-time.Minute))
+		check(t, err)
+		if users != 2 {
+			t.Fatalf("users: %d", users)
+		}
+
+		release, err := repo.Alerts().FirstRelease(1, "1.0")
+		check(t, err)
+		if release != "1.0" {
+			t.Fatalf("first release: %s", release)
+		}
+		release, err = repo.Alerts().FirstRelease(1, "1.1")
+		check(t, err)
+		if release != "1.0" {
+			t.Fatalf("first release kept: %s", release)
+		}
+
+		fired, err := repo.Alerts().Fire("rule", 1, now, now.Add(-time.Hour))
+		check(t, err)
+		if !fired {
+			t.Fatal("first firing")
+		}
+		fired, err = repo.Alerts().Fire("rule", 1, now, now.Add(-time.Hour))
+		check(t, err)
+		if fired {
+			t.Fatal("fired again within the interval")
+		}
+		fired, err = repo.Alerts().Fire("rule", 2, now, now.Add(-time.Hour))
+		check(t, err)
+		if !fired {
+			t.Fatal("firing of another group")
+		}
+
+		e := storage.Evaluation{Rule: "rule", ProjectId: "1", GroupId: 1, Fired: true, Reason: "new issue", Created: now}
+		check(t, repo.Alerts().Log(&e))
+		if e.Id == 0 {
+			t.Fatal("evaluation id is not set")
+		}
+		check(t, repo.Alerts().Log(&storage.Evaluation{Rule: "rule", ProjectId: "1", GroupId: 2, Reason: "level", Created: now}))
+
+		history, err := repo.Alerts().History(10)
+		check(t, err)
+		if len(history) != 2 || history[0].GroupId != 2 || history[1].Id != e.Id || !history[1].Fired {
+			t.Fatalf("history: %+v", history)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+
+	"github.com/scr34m/proof/blob"
+	"github.com/scr34m/proof/database"
+)
+
+const (
+	// CodecBase64 blobs hold the decoded bytes of the base64 payload
+	CodecBase64 = "base64"
+	// CodecRaw blobs hold the payload as is
+	CodecRaw = "raw"
+)
+
+// encodePayload returns the blob content of the payload, its key, size and
+// codec
+func encodePayload(data string) ([]byte, string, int, string) {
+	b := []byte(data)
+	codec := CodecRaw
+
+	// only when the decoding is lossless, the payload id is the hash of data
+	if d, err := base64.StdEncoding.DecodeString(data); err == nil && base64.StdEncoding.EncodeToString(d) == data {
+		b = d
+		codec = CodecBase64
+	}
+	return b, blob.Key(b), len(b), codec
+}
+
+func getPayload(blobs blob.Store, key string, codec string) (string, error) {
+	b, err := blobs.Get(key)
+	if err != nil {
+		return "", fmt.Errorf("blob %s: %v", key, err)
+	}
+
+	switch codec {
+	case CodecBase64:
+		return base64.StdEncoding.EncodeToString(b), nil
+	case CodecRaw:
+		return string(b), nil
+	}
+	return "", fmt.Errorf("blob %s: unknown codec %q", key, codec)
+}
+
+// MovePayloads moves the payloads kept in the data table to the blob store
+// and returns the number of moved rows
+func MovePayloads(db *database.DB, blobs blob.Store) (int, error) {
+	moved := 0
+	for {
+		rows, err := db.Query("SELECT id, data FROM `data` WHERE blob_key = '' LIMIT 100")
+		if err != nil {
+			return moved, err
+		}
+
+		type row struct {
+			id   string
+			data string
+		}
+		var batch []row
+		for rows.Next() {
+			r := row{}
+			if err := rows.Scan(&r.id, &r.data); err != nil {
+				rows.Close()
+				return moved, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return moved, err
+		}
+
+		if len(batch) == 0 {
+			return moved, nil
+		}
+
+		for _, r := range batch {
+			b, key, size, codec := encodePayload(r.data)
+			err := blobs.Put(key, b)
+			if err != nil {
+				return moved, err
+			}
+
+			// the blob is written first, an interrupted run is safe to repeat
+			_, err = db.Exec("UPDATE `data` SET data = '', blob_key = ?, size = ?, codec = ? WHERE id = ?", key, size, codec, r.id)
+			if err != nil {
+				return moved, err
+			}
+			moved++
+		}
+		log.Printf("Moved %d payloads to the blob store", moved)
+	}
+}
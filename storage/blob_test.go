@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/scr34m/proof/blob"
+	"github.com/scr34m/proof/database"
+	"github.com/scr34m/proof/migrate"
+)
+
+func TestEncodePayload(t *testing.T) {
+	blobs, err := blob.NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := []byte{0x78, 0x9c, 0x00, 0xff, 0x10}
+	tests := []struct {
+		data  string
+		codec string
+		blob  []byte
+	}{
+		{base64.StdEncoding.EncodeToString(compressed), CodecBase64, compressed},
+		{`{"message":"Checkout opened"}`, CodecRaw, []byte(`{"message":"Checkout opened"}`)},
+		// decodes, but not back to the same text
+		{"eJw=\n", CodecRaw, []byte("eJw=\n")},
+	}
+
+	for _, tt := range tests {
+		b, key, size, codec := encodePayload(tt.data)
+		if codec != tt.codec || string(b) != string(tt.blob) || size != len(tt.blob) || key != blob.Key(tt.blob) {
+			t.Errorf("%q encoded to %q %s %d %s", tt.data, b, key, size, codec)
+			continue
+		}
+
+		if err := blobs.Put(key, b); err != nil {
+			t.Fatal(err)
+		}
+		data, err := getPayload(blobs, key, codec)
+		if err != nil || data != tt.data {
+			t.Errorf("%q decoded to %q: %v", tt.data, data, err)
+		}
+	}
+
+	if _, err := getPayload(blobs, blob.Key(compressed), "zstd"); err == nil {
+		t.Error("unknown codec decoded")
+	}
+}
+
+func TestMovePayloads(t *testing.T) {
+	db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "proof.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := migrate.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// more than a page of rows
+	var payloads []Payload
+	var received []time.Time
+	for i := 0; i < 150; i++ {
+		data := fmt.Sprintf(`{"message":"event %d"}`, i)
+		if i%2 == 0 {
+			data = base64.StdEncoding.EncodeToString([]byte(data))
+		}
+		payloads = append(payloads, Payload{Id: fmt.Sprintf("%032d", i), Data: data, Protocol: "7"})
+		received = append(received, time.Now())
+	}
+	if err := NewSQL(db, nil).Payloads().StoreMany(payloads, received); err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := blob.NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := MovePayloads(db, blobs)
+	if err != nil || moved != len(payloads) {
+		t.Fatalf("moved %d payloads: %v", moved, err)
+	}
+
+	var inline int
+	if err := db.QueryRow("SELECT COUNT(*) FROM `data` WHERE data <> '' OR blob_key = ''").Scan(&inline); err != nil || inline != 0 {
+		t.Fatalf("%d payloads left in the data column: %v", inline, err)
+	}
+
+	repo := NewSQL(db, blobs)
+	for _, p := range payloads {
+		stored, err := repo.Payloads().Get(p.Id)
+		if err != nil || stored.Data != p.Data {
+			t.Fatalf("payload %s read back as %q: %v", p.Id, stored.Data, err)
+		}
+	}
+
+	// nothing is left for a repeated run
+	if moved, err := MovePayloads(db, blobs); err != nil || moved != 0 {
+		t.Fatalf("moved %d payloads again: %v", moved, err)
+	}
+}
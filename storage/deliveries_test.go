@@ -0,0 +1,62 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/storage"
+)
+
+func TestDeliveries(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now().Truncate(time.Second)
+
+		d := storage.Delivery{Webhook: "hook", Url: "http://localhost/", Action: "created", GroupId: 1, Payload: "{}", Status: storage.DeliveryPending, Created: now, Next: now}
+		check(t, repo.Deliveries().Create(&d))
+		if d.Id == 0 {
+			t.Fatal("delivery id is not set")
+		}
+
+		due, err := repo.Deliveries().Due(now.Add(time.Second), 10)
+		check(t, err)
+		if len(due) != 1 || due[0].Id != d.Id || due[0].Attempts != 0 {
+			t.Fatalf("due deliveries: %+v", due)
+		}
+
+		ok, err := repo.Deliveries().Claim(due[0], now.Add(time.Minute))
+		check(t, err)
+		if !ok {
+			t.Fatal("claim failed")
+		}
+		// another process claimed the same attempt
+		ok, err = repo.Deliveries().Claim(due[0], now.Add(time.Minute))
+		check(t, err)
+		if ok {
+			t.Fatal("claimed twice")
+		}
+
+		due, err = repo.Deliveries().Due(now.Add(time.Second), 10)
+		check(t, err)
+		if len(due) != 0 {
+			t.Fatalf("claimed delivery is due: %+v", due)
+		}
+
+		d.Status = storage.DeliveryDelivered
+		d.Code = 200
+		d.Finished = now
+		check(t, repo.Deliveries().Finish(d))
+
+		stored, err := repo.Deliveries().Get(d.Id)
+		check(t, err)
+		if stored.Status != storage.DeliveryDelivered || stored.Code != 200 || stored.Attempts != 1 {
+			t.Fatalf("finished delivery: %+v", stored)
+		}
+
+		check(t, repo.Deliveries().Redeliver(d.Id))
+		stored, err = repo.Deliveries().Get(d.Id)
+		check(t, err)
+		if stored.Status != storage.DeliveryPending || stored.Attempts != 0 {
+			t.Fatalf("redelivered delivery: %+v", stored)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/storage"
+)
+
+func TestDigests(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now().Truncate(time.Second)
+
+		item := storage.DigestItem{Email: "a@example.com", ProjectId: "1", GroupId: 1, Kind: storage.SubscribeNew, Message: "first", Level: "error", Created: now, LastSeen: now}
+		check(t, repo.Digests().Add(item))
+		item.Kind = storage.SubscribeAll
+		item.Message = "second"
+		check(t, repo.Digests().Add(item))
+		check(t, repo.Digests().Add(storage.DigestItem{Email: "a@example.com", ProjectId: "1", GroupId: 2, Kind: storage.SubscribeAll, Message: "other", Created: now, LastSeen: now}))
+		check(t, repo.Digests().Add(storage.DigestItem{Email: "b@example.com", ProjectId: "1", GroupId: 1, Kind: storage.SubscribeAll, Message: "later", Created: now.Add(time.Hour), LastSeen: now}))
+
+		due, err := repo.Digests().Due(now.Add(time.Minute))
+		check(t, err)
+		if len(due) != 1 || due[0] != "a@example.com" {
+			t.Fatalf("due digests: %v", due)
+		}
+
+		items, err := repo.Digests().Take("a@example.com")
+		check(t, err)
+		if len(items) != 2 || items[0].GroupId != 1 || items[0].Quantity != 2 || items[0].Kind != storage.SubscribeNew || items[0].Message != "first" {
+			t.Fatalf("taken digest: %+v", items)
+		}
+		taken, err := repo.Digests().Take("a@example.com")
+		check(t, err)
+		if len(taken) != 0 {
+			t.Fatalf("taken twice: %+v", taken)
+		}
+
+		// an event arrived while the digest was sent
+		check(t, repo.Digests().Add(storage.DigestItem{Email: "a@example.com", ProjectId: "1", GroupId: 1, Kind: storage.SubscribeAll, Message: "third", Created: now.Add(time.Hour), LastSeen: now}))
+		check(t, repo.Digests().Restore(items))
+
+		due, err = repo.Digests().Due(now.Add(time.Minute))
+		check(t, err)
+		if len(due) != 1 || due[0] != "a@example.com" {
+			t.Fatalf("due restored digests: %v", due)
+		}
+		items, err = repo.Digests().Take("a@example.com")
+		check(t, err)
+		quantity := make(map[int64]int)
+		for _, item := range items {
+			quantity[item.GroupId] = item.Quantity
+		}
+		if len(items) != 2 || quantity[1] != 3 || quantity[2] != 1 {
+			t.Fatalf("restored digest: %+v", items)
+		}
+	})
+}
+
+func TestReports(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now()
+
+		a := storage.Group{ProjectId: "1", Checksum: "a", Message: "a", Level: "error"}
+		_, err := repo.Groups().Upsert(&a, now)
+		check(t, err)
+		b := storage.Group{ProjectId: "1", Checksum: "b", Message: "b", Level: "warning"}
+		_, err = repo.Groups().Upsert(&b, now)
+		check(t, err)
+
+		check(t, repo.Reports().Count("1", a.Id, "2026-01-01", true, false))
+		check(t, repo.Reports().Count("1", a.Id, "2026-01-02", false, true))
+		check(t, repo.Reports().Count("1", a.Id, "2026-01-02", false, false))
+		check(t, repo.Reports().Count("1", b.Id, "2026-01-02", true, false))
+		check(t, repo.Reports().Count("1", b.Id, "2026-01-03", false, false))
+
+		items, err := repo.Reports().Summary("2026-01-01", "2026-01-02")
+		check(t, err)
+		if len(items) != 2 {
+			t.Fatalf("summary: %+v", items)
+		}
+		if i := items[0]; i.GroupId != a.Id || i.Quantity != 3 || i.New != 1 || i.Regressed != 1 || i.Message != "a" || i.Level != "error" {
+			t.Fatalf("most frequent issue: %+v", i)
+		}
+		if i := items[1]; i.GroupId != b.Id || i.Quantity != 1 || i.New != 1 || i.Regressed != 0 {
+			t.Fatalf("second issue: %+v", i)
+		}
+
+		ok, err := repo.Reports().Sent("daily", "2026-01-02")
+		check(t, err)
+		if !ok {
+			t.Fatal("first report")
+		}
+		ok, err = repo.Reports().Sent("daily", "2026-01-02")
+		check(t, err)
+		if ok {
+			t.Fatal("report sent twice")
+		}
+		// the report that failed is sent again
+		check(t, repo.Reports().Unsent("daily", "2026-01-02"))
+		ok, err = repo.Reports().Sent("daily", "2026-01-02")
+		check(t, err)
+		if !ok {
+			t.Fatal("unsent report")
+		}
+
+		check(t, repo.Reports().Prune("2026-01-03"))
+		items, err = repo.Reports().Summary("2026-01-01", "2026-01-03")
+		check(t, err)
+		if len(items) != 1 || items[0].GroupId != b.Id || items[0].Quantity != 1 {
+			t.Fatalf("pruned summary: %+v", items)
+		}
+		ok, err = repo.Reports().Sent("daily", "2026-01-02")
+		check(t, err)
+		if !ok {
+			t.Fatal("pruned report")
+		}
+	})
+}
@@ -0,0 +1,1010 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memoryTimeFormat = "2006-01-02 15:04:05"
+
+type outcomeKey struct {
+	projectId string
+	reason    string
+	day       string
+}
+
+type alertKey struct {
+	groupId int64
+	key     string
+}
+
+type subscriptionKey struct {
+	email     string
+	projectId string
+	groupId   int64
+}
+
+// memoryStorage keeps everything in maps, it is lost on exit and meant for
+// tests and trying out
+type memoryStorage struct {
+	mu         sync.Mutex
+	tx         sync.Mutex
+	groups     map[int64]*Group
+	events     map[int64]*Event
+	payloads   map[string]Payload
+	outcomes   map[outcomeKey]int
+	deliveries map[int64]*Delivery
+	counts     map[alertKey]int
+	users      map[alertKey]time.Time
+	releases   map[int64]string
+	fired      map[alertKey]time.Time
+	history    []Evaluation
+	subs       map[subscriptionKey]Subscription
+	digests    map[alertKey]*DigestItem
+	days       map[alertKey]*ReportItem
+	sent       map[string]bool
+	digestSeq  int64
+	groupSeq   int64
+	eventSeq   int64
+	deliverSeq int64
+	historySeq int64
+}
+
+func NewMemory() Storage {
+	return &memoryStorage{
+		groups:     make(map[int64]*Group),
+		events:     make(map[int64]*Event),
+		payloads:   make(map[string]Payload),
+		outcomes:   make(map[outcomeKey]int),
+		deliveries: make(map[int64]*Delivery),
+		counts:     make(map[alertKey]int),
+		users:      make(map[alertKey]time.Time),
+		releases:   make(map[int64]string),
+		fired:      make(map[alertKey]time.Time),
+		subs:       make(map[subscriptionKey]Subscription),
+		digests:    make(map[alertKey]*DigestItem),
+		days:       make(map[alertKey]*ReportItem),
+		sent:       make(map[string]bool),
+	}
+}
+
+func (s *memoryStorage) Groups() GroupStore               { return (*memoryGroups)(s) }
+func (s *memoryStorage) Events() EventStore               { return (*memoryEvents)(s) }
+func (s *memoryStorage) Payloads() PayloadStore           { return (*memoryPayloads)(s) }
+func (s *memoryStorage) Outcomes() OutcomeStore           { return (*memoryOutcomes)(s) }
+func (s *memoryStorage) Retention() RetentionStore        { return (*memoryRetention)(s) }
+func (s *memoryStorage) Deliveries() DeliveryStore        { return (*memoryDeliveries)(s) }
+func (s *memoryStorage) Alerts() AlertStore               { return (*memoryAlerts)(s) }
+func (s *memoryStorage) Subscriptions() SubscriptionStore { return (*memorySubscriptions)(s) }
+func (s *memoryStorage) Digests() DigestStore             { return (*memoryDigests)(s) }
+func (s *memoryStorage) Reports() ReportStore             { return (*memoryReports)(s) }
+
+// Atomic runs the functions one by one, it can not be nested and there is
+// no rollback
+func (s *memoryStorage) Atomic(fn func(Storage) error) error {
+	s.tx.Lock()
+	defer s.tx.Unlock()
+	return fn(s)
+}
+
+type memoryGroups memoryStorage
+
+func (s *memoryGroups) List(status int) ([]Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Group
+	for _, g := range s.groups {
+		if g.Status == status {
+			list = append(list, *g)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen > list[j].LastSeen })
+	return list, nil
+}
+
+func (s *memoryGroups) Get(id int64) (Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.groups[id]
+	if !ok {
+		return Group{}, ErrNotFound
+	}
+	return *g, nil
+}
+
+func (s *memoryGroups) Find(projectId string, checksum string) (Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range s.groups {
+		if g.ProjectId == projectId && g.Checksum == checksum {
+			return *g, nil
+		}
+	}
+	return Group{}, ErrNotFound
+}
+
+func (s *memoryGroups) Upsert(g *Group, seen time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, found := range s.groups {
+		if found.ProjectId == g.ProjectId && found.Checksum == g.Checksum {
+			g.Id = found.Id
+			g.Status = found.Status
+			g.Assignee = found.Assignee
+			g.Seen = found.Seen
+			g.Sampled = found.Sampled
+			g.FirstSeen = found.FirstSeen
+			g.LastSeen = found.LastSeen
+			return false, nil
+		}
+	}
+
+	s.groupSeq++
+	g.Id = s.groupSeq
+	g.Seen = 1
+	g.Sampled = 0
+	g.Status = 0
+	g.FirstSeen = seen.Format(memoryTimeFormat)
+	g.LastSeen = g.FirstSeen
+
+	c := *g
+	s.groups[g.Id] = &c
+	return true, nil
+}
+
+func (s *memoryGroups) Touch(g Group, seen time.Time, count int, sampled int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.groups[g.Id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	g.FirstSeen = stored.FirstSeen
+	g.Checksum = stored.Checksum
+	g.Seen = stored.Seen + int64(count)
+	g.Sampled = stored.Sampled + int64(sampled)
+	g.Assignee = stored.Assignee
+	g.Status = GroupOpen
+	if stored.Status == GroupIgnored {
+		g.Status = GroupIgnored
+	}
+	g.LastSeen = stored.LastSeen
+	if last := seen.Format(memoryTimeFormat); last > g.LastSeen {
+		g.LastSeen = last
+	}
+	*stored = g
+	return nil
+}
+
+func (s *memoryGroups) SetStatus(id int64, status int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g, ok := s.groups[id]; ok {
+		g.Status = status
+	}
+	return nil
+}
+
+func (s *memoryGroups) Assign(id int64, assignee string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g, ok := s.groups[id]; ok {
+		g.Assignee = assignee
+	}
+	return nil
+}
+
+func (s *memoryGroups) Changed(since string) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last string
+	var c int
+	for _, g := range s.groups {
+		if g.Status != 0 || g.LastSeen <= since {
+			continue
+		}
+		c++
+		if g.LastSeen > last {
+			last = g.LastSeen
+		}
+	}
+	return last, c, nil
+}
+
+func (s *memoryGroups) Projects() ([]ProjectCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]*ProjectCount)
+	for _, g := range s.groups {
+		p, ok := counts[g.ProjectId]
+		if !ok {
+			p = &ProjectCount{ProjectId: g.ProjectId}
+			counts[g.ProjectId] = p
+		}
+		p.Groups++
+		p.Seen += int(g.Seen)
+	}
+
+	var list []ProjectCount
+	for _, p := range counts {
+		list = append(list, *p)
+	}
+	return list, nil
+}
+
+type memoryEvents memoryStorage
+
+func (s *memoryEvents) Create(e *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.eventSeq++
+	e.Id = s.eventSeq
+
+	c := *e
+	s.events[e.Id] = &c
+	return nil
+}
+
+func (s *memoryEvents) CreateMany(events []Event) error {
+	for _, e := range events {
+		if err := s.Create(&e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryEvents) Get(groupId int64, id int64) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.events[id]
+	if !ok || e.GroupId != groupId {
+		return Event{}, ErrNotFound
+	}
+	return *e, nil
+}
+
+func (s *memoryEvents) Latest(groupId int64) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest *Event
+	for _, e := range s.events {
+		if e.GroupId == groupId && (latest == nil || e.Id > latest.Id) {
+			latest = e
+		}
+	}
+	if latest == nil {
+		return Event{}, ErrNotFound
+	}
+	return *latest, nil
+}
+
+func (s *memoryEvents) Older(groupId int64, id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, e := range s.events {
+		if e.GroupId == groupId && e.Id < id && e.Id > n {
+			n = e.Id
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryEvents) Newer(groupId int64, id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, e := range s.events {
+		if e.GroupId == groupId && e.Id > id && (n == 0 || e.Id < n) {
+			n = e.Id
+		}
+	}
+	return n, nil
+}
+
+type memoryPayloads memoryStorage
+
+func (s *memoryPayloads) Store(p Payload, received time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.payloads[p.Id]; ok {
+		return nil
+	}
+	p.Timestamp = received.Format(memoryTimeFormat)
+	s.payloads[p.Id] = p
+	return nil
+}
+
+func (s *memoryPayloads) StoreMany(payloads []Payload, received []time.Time) error {
+	for i, p := range payloads {
+		if err := s.Store(p, received[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryPayloads) Get(id string) (Payload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.payloads[id]
+	if !ok {
+		return Payload{}, ErrNotFound
+	}
+	return p, nil
+}
+
+type memoryOutcomes memoryStorage
+
+func (s *memoryOutcomes) Add(projectId string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outcomes[outcomeKey{projectId, reason, time.Now().Format("2006-01-02")}]++
+	return nil
+}
+
+func (s *memoryOutcomes) Totals() (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals := make(map[string]int)
+	for k, quantity := range s.outcomes {
+		totals[k.projectId] += quantity
+	}
+	return totals, nil
+}
+
+func (s *memoryOutcomes) Summary(projectId string, today string, since string) ([]OutcomeSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reasons := make(map[string]*OutcomeSummary)
+	for k, quantity := range s.outcomes {
+		if k.projectId != projectId || k.day < since {
+			continue
+		}
+		o, ok := reasons[k.reason]
+		if !ok {
+			o = &OutcomeSummary{Reason: k.reason}
+			reasons[k.reason] = o
+		}
+		if k.day >= today {
+			o.Today += quantity
+		}
+		o.Period += quantity
+		if k.day > o.LastDay {
+			o.LastDay = k.day
+		}
+	}
+
+	var list []OutcomeSummary
+	for _, o := range reasons {
+		list = append(list, *o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Reason < list[j].Reason })
+	return list, nil
+}
+
+type memoryDeliveries memoryStorage
+
+func (s *memoryDeliveries) Create(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deliverSeq++
+	d.Id = s.deliverSeq
+	stored := *d
+	s.deliveries[d.Id] = &stored
+	return nil
+}
+
+func (s *memoryDeliveries) Get(id int64) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deliveries[id]
+	if !ok {
+		return Delivery{}, ErrNotFound
+	}
+	return *d, nil
+}
+
+func (s *memoryDeliveries) List(limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Delivery
+	for _, d := range s.deliveries {
+		list = append(list, *d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id > list[j].Id })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (s *memoryDeliveries) Due(now time.Time, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Delivery
+	for _, d := range s.deliveries {
+		if d.Status == DeliveryPending && !d.Next.After(now) {
+			list = append(list, *d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (s *memoryDeliveries) Claim(d Delivery, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.deliveries[d.Id]
+	if !ok || stored.Status != DeliveryPending || stored.Attempts != d.Attempts {
+		return false, nil
+	}
+	stored.Attempts++
+	stored.Next = until
+	return true, nil
+}
+
+func (s *memoryDeliveries) Finish(d Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.deliveries[d.Id]; ok {
+		stored.Status = d.Status
+		stored.Code = d.Code
+		stored.Error = d.Error
+		stored.Next = d.Next
+		stored.Finished = d.Finished
+	}
+	return nil
+}
+
+func (s *memoryDeliveries) Redeliver(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.deliveries[id]
+	if !ok || d.Status == DeliveryPending {
+		return ErrNotFound
+	}
+	d.Status = DeliveryPending
+	d.Attempts = 0
+	d.Next = time.Now()
+	return nil
+}
+
+func (s *memoryDeliveries) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for id, d := range s.deliveries {
+		if d.Status != DeliveryPending && d.Created.Before(before) {
+			delete(s.deliveries, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// memoryAlerts keys the event counters by the minute as a string
+type memoryAlerts memoryStorage
+
+func (s *memoryAlerts) Count(groupId int64, at time.Time, userHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[alertKey{groupId, strconv.FormatInt(at.Unix()/60, 10)}]++
+	if userHash != "" {
+		s.users[alertKey{groupId, userHash}] = at
+	}
+	return nil
+}
+
+func (s *memoryAlerts) Events(groupId int64, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for k, quantity := range s.counts {
+		minute, _ := strconv.ParseInt(k.key, 10, 64)
+		if k.groupId == groupId && minute >= since.Unix()/60 {
+			n += quantity
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryAlerts) Users(groupId int64, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for k, seen := range s.users {
+		if k.groupId == groupId && !seen.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryAlerts) FirstRelease(groupId int64, release string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.releases[groupId]; !ok && release != "" {
+		s.releases[groupId] = release
+	}
+	return s.releases[groupId], nil
+}
+
+func (s *memoryAlerts) Fire(rule string, groupId int64, now time.Time, after time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := alertKey{groupId, rule}
+	if fired, ok := s.fired[k]; ok && fired.After(after) {
+		return false, nil
+	}
+	s.fired[k] = now
+	return true, nil
+}
+
+func (s *memoryAlerts) Log(e *Evaluation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.historySeq++
+	e.Id = s.historySeq
+	s.history = append(s.history, *e)
+	return nil
+}
+
+func (s *memoryAlerts) History(limit int) ([]Evaluation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Evaluation
+	for i := len(s.history) - 1; i >= 0 && len(list) < limit; i-- {
+		list = append(list, s.history[i])
+	}
+	return list, nil
+}
+
+func (s *memoryAlerts) Prune(counters time.Time, history time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.counts {
+		minute, _ := strconv.ParseInt(k.key, 10, 64)
+		if minute < counters.Unix()/60 {
+			delete(s.counts, k)
+		}
+	}
+	for k, seen := range s.users {
+		if seen.Before(counters) {
+			delete(s.users, k)
+		}
+	}
+	for k, fired := range s.fired {
+		if fired.Before(counters) {
+			delete(s.fired, k)
+		}
+	}
+	for groupId := range s.releases {
+		if _, ok := s.groups[groupId]; !ok {
+			delete(s.releases, groupId)
+		}
+	}
+
+	kept := s.history[:0]
+	for _, e := range s.history {
+		if !e.Created.Before(history) {
+			kept = append(kept, e)
+		}
+	}
+	s.history = kept
+	return nil
+}
+
+type memorySubscriptions memoryStorage
+
+func (s *memorySubscriptions) Get(email string, projectId string, groupId int64) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.subs[subscriptionKey{email, projectId, groupId}]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (s *memorySubscriptions) List(projectId string, groupId int64) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Subscription
+	for _, sub := range s.subs {
+		if sub.ProjectId == projectId && (sub.GroupId == 0 || sub.GroupId == groupId) {
+			list = append(list, sub)
+		}
+	}
+	return list, nil
+}
+
+func (s *memorySubscriptions) Set(sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subs[subscriptionKey{sub.Email, sub.ProjectId, sub.GroupId}] = sub
+	return nil
+}
+
+func (s *memorySubscriptions) Delete(email string, projectId string, groupId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subs, subscriptionKey{email, projectId, groupId})
+	return nil
+}
+
+// memoryDigests keys the issues by the recipient
+type memoryDigests memoryStorage
+
+func (s *memoryDigests) Add(d DigestItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := alertKey{d.GroupId, d.Email}
+	if stored, ok := s.digests[k]; ok {
+		stored.Quantity++
+		stored.LastSeen = d.LastSeen
+		return nil
+	}
+	s.digestSeq++
+	d.Id = s.digestSeq
+	d.Quantity = 1
+	s.digests[k] = &d
+	return nil
+}
+
+func (s *memoryDigests) Due(before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make(map[string]bool)
+	var list []string
+	for _, d := range s.digests {
+		if !d.Created.After(before) && !due[d.Email] {
+			due[d.Email] = true
+			list = append(list, d.Email)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryDigests) Take(email string) ([]DigestItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []DigestItem
+	for k, d := range s.digests {
+		if d.Email == email {
+			list = append(list, *d)
+			delete(s.digests, k)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list, nil
+}
+
+func (s *memoryDigests) Restore(items []DigestItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range items {
+		k := alertKey{d.GroupId, d.Email}
+		if stored, ok := s.digests[k]; ok {
+			stored.Quantity += d.Quantity
+			stored.Kind = d.Kind
+			if d.Created.Before(stored.Created) {
+				stored.Created = d.Created
+			}
+			continue
+		}
+		d := d
+		s.digests[k] = &d
+	}
+	return nil
+}
+
+// memoryReports keys the counters by the day
+type memoryReports memoryStorage
+
+func (s *memoryReports) Count(projectId string, groupId int64, day string, isNew bool, isRegression bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := alertKey{groupId, day}
+	r, ok := s.days[k]
+	if !ok {
+		r = &ReportItem{ProjectId: projectId, GroupId: groupId}
+		s.days[k] = r
+	}
+	r.Quantity++
+	if isNew {
+		r.New++
+	}
+	if isRegression {
+		r.Regressed++
+	}
+	return nil
+}
+
+func (s *memoryReports) Summary(since string, until string) ([]ReportItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	issues := make(map[int64]*ReportItem)
+	for k, r := range s.days {
+		g, ok := s.groups[k.groupId]
+		if !ok || k.key < since || k.key > until {
+			continue
+		}
+		issue, ok := issues[k.groupId]
+		if !ok {
+			issue = &ReportItem{ProjectId: r.ProjectId, GroupId: r.GroupId, Message: g.Message, Level: g.Level}
+			issues[k.groupId] = issue
+		}
+		issue.Quantity += r.Quantity
+		issue.New += r.New
+		issue.Regressed += r.Regressed
+	}
+
+	var list []ReportItem
+	for _, issue := range issues {
+		list = append(list, *issue)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Quantity != list[j].Quantity {
+			return list[i].Quantity > list[j].Quantity
+		}
+		return list[i].GroupId < list[j].GroupId
+	})
+	return list, nil
+}
+
+func (s *memoryReports) Sent(kind string, day string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sent[kind+" "+day] {
+		return false, nil
+	}
+	s.sent[kind+" "+day] = true
+	return true, nil
+}
+
+func (s *memoryReports) Unsent(kind string, day string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sent, kind+" "+day)
+	return nil
+}
+
+func (s *memoryReports) Prune(before string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.days {
+		if k.key < before {
+			delete(s.days, k)
+		}
+	}
+	for k := range s.sent {
+		if k[strings.Index(k, " ")+1:] < before {
+			delete(s.sent, k)
+		}
+	}
+	return nil
+}
+
+type memoryRetention memoryStorage
+
+// projectEvents returns the events of the project, newest first
+func (s *memoryRetention) projectEvents(projectId string) []*Event {
+	var list []*Event
+	for _, e := range s.events {
+		if g, ok := s.groups[e.GroupId]; ok && g.ProjectId == projectId {
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id > list[j].Id })
+	return list
+}
+
+func (s *memoryRetention) Aged(projectId string, before time.Time) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := before.Format(memoryTimeFormat)
+	var list []int64
+	for _, e := range s.projectEvents(projectId) {
+		if p, ok := s.payloads[e.DataId]; ok && p.Timestamp < limit {
+			list = append(list, e.Id)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryRetention) Overflow(projectId string, max int) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[int64]int)
+	var list []int64
+	for _, e := range s.projectEvents(projectId) {
+		seen[e.GroupId]++
+		if seen[e.GroupId] > max {
+			list = append(list, e.Id)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryRetention) Oversize(projectId string, max int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	var list []int64
+	for _, e := range s.projectEvents(projectId) {
+		if p, ok := s.payloads[e.DataId]; ok {
+			total += int64(len(p.Data))
+			if total > max {
+				list = append(list, e.Id)
+			}
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryRetention) Delete(events []int64, dryRun bool) (Removed, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := Removed{}
+
+	gone := make(map[int64]bool)
+	for _, id := range events {
+		if _, ok := s.events[id]; ok {
+			gone[id] = true
+		}
+	}
+	removed.Events = len(gone)
+
+	// what is still referred to by the remaining events
+	groups := make(map[int64]bool)
+	payloads := make(map[string]bool)
+	for id, e := range s.events {
+		if !gone[id] {
+			groups[e.GroupId] = true
+			payloads[e.DataId] = true
+		}
+	}
+
+	var emptyGroups []int64
+	for id := range gone {
+		groupId := s.events[id].GroupId
+		if !groups[groupId] {
+			emptyGroups = append(emptyGroups, groupId)
+			groups[groupId] = true
+		}
+	}
+
+	var orphans []string
+	for id := range gone {
+		dataId := s.events[id].DataId
+		if p, ok := s.payloads[dataId]; ok && !payloads[dataId] {
+			orphans = append(orphans, dataId)
+			removed.Bytes += int64(len(p.Data))
+			payloads[dataId] = true
+		}
+	}
+	removed.Groups = len(emptyGroups)
+	removed.Payloads = len(orphans)
+
+	if dryRun {
+		return removed, nil
+	}
+
+	for id := range gone {
+		delete(s.events, id)
+	}
+	for _, dataId := range orphans {
+		delete(s.payloads, dataId)
+	}
+	for _, groupId := range emptyGroups {
+		delete(s.groups, groupId)
+	}
+	return removed, nil
+}
+
+func (s *memoryRetention) Orphans(dryRun bool) (Removed, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := Removed{}
+
+	groups := make(map[int64]bool)
+	payloads := make(map[string]bool)
+	for _, e := range s.events {
+		groups[e.GroupId] = true
+		payloads[e.DataId] = true
+	}
+
+	var orphans []string
+	for dataId, p := range s.payloads {
+		if !payloads[dataId] {
+			orphans = append(orphans, dataId)
+			removed.Bytes += int64(len(p.Data))
+		}
+	}
+	var emptyGroups []int64
+	for id := range s.groups {
+		if !groups[id] {
+			emptyGroups = append(emptyGroups, id)
+		}
+	}
+	removed.Groups = len(emptyGroups)
+	removed.Payloads = len(orphans)
+
+	if dryRun {
+		return removed, nil
+	}
+
+	for _, dataId := range orphans {
+		delete(s.payloads, dataId)
+	}
+	for _, groupId := range emptyGroups {
+		delete(s.groups, groupId)
+	}
+	return removed, nil
+}
+
+func (s *memoryRetention) Compact() error {
+	return nil
+}
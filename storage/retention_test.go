@@ -0,0 +1,159 @@
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/storage"
+)
+
+func TestRetention(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now()
+
+		a := storage.Group{ProjectId: "1", Checksum: "a", Message: "a"}
+		_, err := repo.Groups().Upsert(&a, now)
+		check(t, err)
+		b := storage.Group{ProjectId: "1", Checksum: "b", Message: "b"}
+		_, err = repo.Groups().Upsert(&b, now)
+		check(t, err)
+
+		check(t, repo.Payloads().StoreMany([]storage.Payload{{Id: "old", Data: "old", Protocol: "4"}, {Id: "new", Data: "new", Protocol: "4"}}, []time.Time{now.Add(-48 * time.Hour), now}))
+		check(t, repo.Events().CreateMany([]storage.Event{
+			{GroupId: a.Id, DataId: "old", Checksum: "a"},
+			{GroupId: a.Id, DataId: "new", Checksum: "a"},
+			{GroupId: b.Id, DataId: "old", Checksum: "b"},
+		}))
+
+		aged, err := repo.Retention().Aged("1", now.Add(-24*time.Hour))
+		check(t, err)
+		if len(aged) != 2 {
+			t.Fatalf("aged events: %v", aged)
+		}
+		overflow, err := repo.Retention().Overflow("1", 1)
+		check(t, err)
+		if len(overflow) != 1 {
+			t.Fatalf("overflow events: %v", overflow)
+		}
+
+		removed, err := repo.Retention().Delete(aged, true)
+		check(t, err)
+		want := storage.Removed{Events: 2, Payloads: 1, Groups: 1, Bytes: 3}
+		if removed != want {
+			t.Fatalf("dry run: %+v", removed)
+		}
+		if _, err := repo.Groups().Get(b.Id); err != nil {
+			t.Fatalf("dry run deleted the group: %v", err)
+		}
+
+		removed, err = repo.Retention().Delete(aged, false)
+		check(t, err)
+		if removed != want {
+			t.Fatalf("deleted: %+v", removed)
+		}
+		if _, err := repo.Groups().Get(b.Id); err != storage.ErrNotFound {
+			t.Fatalf("group without events: %v", err)
+		}
+		if _, err := repo.Payloads().Get("old"); err != storage.ErrNotFound {
+			t.Fatalf("payload without events: %v", err)
+		}
+		if _, err := repo.Events().Latest(a.Id); err != nil {
+			t.Fatalf("remaining event: %v", err)
+		}
+		check(t, repo.Retention().Compact())
+	})
+}
+
+func TestRetentionOversize(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now()
+
+		g := storage.Group{ProjectId: "1", Checksum: "a", Message: "a"}
+		_, err := repo.Groups().Upsert(&g, now)
+		check(t, err)
+
+		// more than a page of 10 byte payloads
+		var payloads []storage.Payload
+		var received []time.Time
+		var events []storage.Event
+		for i := 0; i < 600; i++ {
+			id := fmt.Sprintf("%010d", i)
+			payloads = append(payloads, storage.Payload{Id: id, Data: id, Protocol: "7"})
+			received = append(received, now)
+			events = append(events, storage.Event{GroupId: g.Id, DataId: id, Checksum: "a"})
+		}
+		check(t, repo.Payloads().StoreMany(payloads, received))
+		check(t, repo.Events().CreateMany(events))
+
+		newest, err := repo.Events().Latest(g.Id)
+		check(t, err)
+
+		for _, tt := range []struct {
+			max  int64
+			over int
+		}{{6000, 0}, {5995, 1}, {1000, 500}, {0, 600}} {
+			over, err := repo.Retention().Oversize("1", tt.max)
+			check(t, err)
+			if len(over) != tt.over {
+				t.Fatalf("%d events over %d bytes", len(over), tt.max)
+			}
+			// the oldest ones, newest first
+			if tt.over > 0 && over[0] != newest.Id-int64(600-tt.over) {
+				t.Fatalf("events over %d bytes from %d", tt.max, over[0])
+			}
+		}
+
+		if over, err := repo.Retention().Oversize("2", 0); err != nil || len(over) != 0 {
+			t.Fatalf("events of another project: %v %v", over, err)
+		}
+	})
+}
+
+func TestRetentionOrphans(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now()
+
+		kept := storage.Group{ProjectId: "1", Checksum: "a", Message: "a"}
+		_, err := repo.Groups().Upsert(&kept, now)
+		check(t, err)
+		empty := storage.Group{ProjectId: "2", Checksum: "b", Message: "b"}
+		_, err = repo.Groups().Upsert(&empty, now)
+		check(t, err)
+
+		check(t, repo.Payloads().StoreMany([]storage.Payload{{Id: "kept", Data: "kept", Protocol: "7"}, {Id: "stray", Data: "stray", Protocol: "7"}}, []time.Time{now, now}))
+		check(t, repo.Events().Create(&storage.Event{GroupId: kept.Id, DataId: "kept", Checksum: "a"}))
+
+		// the deletion of other events leaves them
+		removed, err := repo.Retention().Delete(nil, false)
+		check(t, err)
+		if removed != (storage.Removed{}) {
+			t.Fatalf("deleted without events: %+v", removed)
+		}
+
+		want := storage.Removed{Payloads: 1, Groups: 1, Bytes: 5}
+		removed, err = repo.Retention().Orphans(true)
+		check(t, err)
+		if removed != want {
+			t.Fatalf("dry run: %+v", removed)
+		}
+		removed, err = repo.Retention().Orphans(false)
+		check(t, err)
+		if removed != want {
+			t.Fatalf("deleted: %+v", removed)
+		}
+
+		if _, err := repo.Groups().Get(empty.Id); err != storage.ErrNotFound {
+			t.Fatalf("group without events: %v", err)
+		}
+		if _, err := repo.Payloads().Get("stray"); err != storage.ErrNotFound {
+			t.Fatalf("payload without events: %v", err)
+		}
+		if _, err := repo.Groups().Get(kept.Id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Payloads().Get("kept"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,1167 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/scr34m/proof/blob"
+	"github.com/scr34m/proof/database"
+)
+
+// sqlStorage keeps everything in the database, the dialect differences are
+// handled by database.DB. Inside Atomic q is the transaction
+type sqlStorage struct {
+	db    *database.DB
+	q     database.Querier
+	blobs blob.Store
+}
+
+// NewSQL returns the database storage, the payloads are kept in the blob
+// store when blobs is set
+func NewSQL(db *database.DB, blobs blob.Store) Storage {
+	return &sqlStorage{db: db, q: db, blobs: blobs}
+}
+
+func (s *sqlStorage) Groups() GroupStore               { return (*sqlGroups)(s) }
+func (s *sqlStorage) Events() EventStore               { return (*sqlEvents)(s) }
+func (s *sqlStorage) Payloads() PayloadStore           { return (*sqlPayloads)(s) }
+func (s *sqlStorage) Outcomes() OutcomeStore           { return (*sqlOutcomes)(s) }
+func (s *sqlStorage) Retention() RetentionStore        { return (*sqlRetention)(s) }
+func (s *sqlStorage) Deliveries() DeliveryStore        { return (*sqlDeliveries)(s) }
+func (s *sqlStorage) Alerts() AlertStore               { return (*sqlAlerts)(s) }
+func (s *sqlStorage) Subscriptions() SubscriptionStore { return (*sqlSubscriptions)(s) }
+func (s *sqlStorage) Digests() DigestStore             { return (*sqlDigests)(s) }
+func (s *sqlStorage) Reports() ReportStore             { return (*sqlReports)(s) }
+
+func (s *sqlStorage) Atomic(fn func(Storage) error) error {
+	// already in a transaction
+	if _, ok := s.q.(*database.Tx); ok {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// a panic does not leave the transaction open
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	err = fn(&sqlStorage{db: s.db, q: tx, blobs: s.blobs})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+type sqlGroups sqlStorage
+
+const groupColumns = "id, project_id, checksum, logger, `level`, message, `server_name`, url, site, platform, status, assignee, seen, sampled, first_seen, last_seen"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanGroup(row scanner) (Group, error) {
+	g := Group{}
+	err := row.Scan(&g.Id, &g.ProjectId, &g.Checksum, &g.Logger, &g.Level, &g.Message, &g.ServerName, &g.Url, &g.Site, &g.Platform, &g.Status, &g.Assignee, &g.Seen, &g.Sampled, &g.FirstSeen, &g.LastSeen)
+	return g, notFound(err)
+}
+
+func (s *sqlGroups) List(status int) ([]Group, error) {
+	rows, err := s.q.Query("SELECT "+groupColumns+" FROM `group` WHERE status = ? ORDER BY last_seen DESC", status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Group
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, g)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlGroups) Get(id int64) (Group, error) {
+	return scanGroup(s.q.QueryRow("SELECT "+groupColumns+" FROM `group` WHERE id = ?", id))
+}
+
+func (s *sqlGroups) Find(projectId string, checksum string) (Group, error) {
+	return scanGroup(s.q.QueryRow("SELECT "+groupColumns+" FROM `group` WHERE checksum = ? AND project_id = ?", checksum, projectId))
+}
+
+func (s *sqlGroups) Upsert(g *Group, seen time.Time) (bool, error) {
+	query := "INSERT INTO `group` (logger, `level`, message, checksum, seen, sampled, last_seen, first_seen, project_id, `server_name`, url, site, platform, status) VALUES (?, ?, ?, ?, 1, 0, ?, ?, ?, ?, ?, ?, ?, 0)"
+	args := []interface{}{g.Logger, g.Level, g.Message, g.Checksum, seen, seen, g.ProjectId, g.ServerName, g.Url, g.Site, g.Platform}
+
+	var id int64
+	var created bool
+	switch s.db.Dialect {
+	case database.Postgres:
+		err := s.q.QueryRow(query+" ON CONFLICT (project_id, checksum) DO NOTHING RETURNING id", args...).Scan(&id)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		created = err == nil
+	default:
+		// the no-op update keeps the affected rows 0 on MySQL
+		conflict := " ON CONFLICT (project_id, checksum) DO NOTHING"
+		if s.db.Dialect == database.MySQL {
+			conflict = " ON DUPLICATE KEY UPDATE id = id"
+		}
+		res, err := s.q.Exec(query+conflict, args...)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		if n == 1 {
+			created = true
+			id, err = res.LastInsertId()
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if created {
+		g.Id = id
+		g.Seen = 1
+		g.Sampled = 0
+		g.Status = 0
+		return true, nil
+	}
+
+	// SQLite locks the whole database for the writing transaction
+	lock := " FOR UPDATE"
+	if s.db.Dialect == database.SQLite {
+		lock = ""
+	}
+
+	found, err := scanGroup(s.q.QueryRow("SELECT "+groupColumns+" FROM `group` WHERE checksum = ? AND project_id = ?"+lock, g.Checksum, g.ProjectId))
+	if err != nil {
+		return false, err
+	}
+	g.Id = found.Id
+	g.Status = found.Status
+	g.Assignee = found.Assignee
+	g.Seen = found.Seen
+	g.Sampled = found.Sampled
+	g.FirstSeen = found.FirstSeen
+	g.LastSeen = found.LastSeen
+	return false, nil
+}
+
+func (s *sqlGroups) Touch(g Group, seen time.Time, count int, sampled int) error {
+	// an event arriving late does not move the last seen time back
+	latest := "GREATEST(last_seen, ?)"
+	if s.db.Dialect == database.SQLite {
+		latest = "MAX(last_seen, ?)"
+	}
+	_, err := s.q.Exec("UPDATE `group` SET last_seen = "+latest+", seen = seen + ?, sampled = sampled + ?, status = CASE WHEN status = ? THEN ? ELSE ? END, logger = ?, `level` = ?, message = ?, project_id = ?, `server_name` = ?, url = ?, site = ?, platform = ? WHERE id = ?",
+		seen, count, sampled, GroupIgnored, GroupIgnored, GroupOpen, g.Logger, g.Level, g.Message, g.ProjectId, g.ServerName, g.Url, g.Site, g.Platform, g.Id)
+	return err
+}
+
+func (s *sqlGroups) SetStatus(id int64, status int) error {
+	_, err := s.q.Exec("UPDATE `group` SET status = ? WHERE id = ?", status, id)
+	return err
+}
+
+func (s *sqlGroups) Assign(id int64, assignee string) error {
+	_, err := s.q.Exec("UPDATE `group` SET assignee = ? WHERE id = ?", assignee, id)
+	return err
+}
+
+func (s *sqlGroups) Changed(since string) (string, int, error) {
+	var last sql.NullString
+	var c int
+	err := s.q.QueryRow("SELECT MAX(last_seen) AS last, COUNT(*) AS c FROM `group` WHERE status = 0 AND last_seen > ?", since).Scan(&last, &c)
+	return last.String, c, err
+}
+
+func (s *sqlGroups) Projects() ([]ProjectCount, error) {
+	rows, err := s.q.Query("SELECT project_id, COUNT(*), SUM(seen) FROM `group` GROUP BY project_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ProjectCount
+	for rows.Next() {
+		p := ProjectCount{}
+		err = rows.Scan(&p.ProjectId, &p.Groups, &p.Seen)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+type sqlEvents sqlStorage
+
+func (s *sqlEvents) Create(e *Event) error {
+	id, err := s.q.Insert("INSERT INTO event (data_id, group_id, message, checksum) VALUES (?, ?, ?, ?)", e.DataId, e.GroupId, e.Message, e.Checksum)
+	if err != nil {
+		return err
+	}
+	e.Id = id
+	return nil
+}
+
+func (s *sqlEvents) CreateMany(events []Event) error {
+	for i := 0; i < len(events); i += insertRows {
+		end := i + insertRows
+		if end > len(events) {
+			end = len(events)
+		}
+
+		var args []interface{}
+		for _, e := range events[i:end] {
+			args = append(args, e.DataId, e.GroupId, e.Message, e.Checksum)
+		}
+		_, err := s.q.Exec("INSERT INTO event (data_id, group_id, message, checksum) VALUES "+values(end-i, 4), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlEvents) Get(groupId int64, id int64) (Event, error) {
+	e := Event{}
+	err := s.q.QueryRow("SELECT id, group_id, data_id, message, checksum FROM event WHERE group_id = ? AND id = ?", groupId, id).Scan(&e.Id, &e.GroupId, &e.DataId, &e.Message, &e.Checksum)
+	return e, notFound(err)
+}
+
+func (s *sqlEvents) Latest(groupId int64) (Event, error) {
+	e := Event{}
+	err := s.q.QueryRow("SELECT id, group_id, data_id, message, checksum FROM event WHERE group_id = ? ORDER BY id DESC LIMIT 1", groupId).Scan(&e.Id, &e.GroupId, &e.DataId, &e.Message, &e.Checksum)
+	return e, notFound(err)
+}
+
+func (s *sqlEvents) Older(groupId int64, id int64) (int64, error) {
+	return s.neighbour("SELECT id FROM event WHERE group_id = ? AND id < ? ORDER BY id DESC LIMIT 1", groupId, id)
+}
+
+func (s *sqlEvents) Newer(groupId int64, id int64) (int64, error) {
+	return s.neighbour("SELECT id FROM event WHERE group_id = ? AND id > ? ORDER BY id ASC LIMIT 1", groupId, id)
+}
+
+func (s *sqlEvents) neighbour(query string, groupId int64, id int64) (int64, error) {
+	var n int64
+	err := s.q.QueryRow(query, groupId, id).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return n, err
+}
+
+type sqlPayloads sqlStorage
+
+func (s *sqlPayloads) Store(p Payload, received time.Time) error {
+	if s.blobs == nil {
+		_, err := s.q.Exec("INSERT INTO `data` (id, data, timestamp, protocol) VALUES (?, ?, ?, ?)"+s.ignoreConflict("id"), p.Id, p.Data, received, p.Protocol)
+		return err
+	}
+
+	data, key, size, codec := encodePayload(p.Data)
+
+	res, err := s.q.Exec("INSERT INTO `data` (id, data, blob_key, size, codec, timestamp, protocol) VALUES (?, '', ?, ?, ?, ?, ?)"+s.ignoreConflict("id"), p.Id, key, size, codec, received, p.Protocol)
+	if err != nil {
+		return err
+	}
+
+	// the row is not visible until the commit, a failed upload rolls it back
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+	return s.blobs.Put(key, data)
+}
+
+// StoreMany stores the payloads one by one with the blob store, the upload
+// is only needed for the new ones
+func (s *sqlPayloads) StoreMany(payloads []Payload, received []time.Time) error {
+	if s.blobs != nil {
+		for i, p := range payloads {
+			if err := s.Store(p, received[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := 0; i < len(payloads); i += insertRows {
+		end := i + insertRows
+		if end > len(payloads) {
+			end = len(payloads)
+		}
+
+		var args []interface{}
+		for j, p := range payloads[i:end] {
+			args = append(args, p.Id, p.Data, received[i+j], p.Protocol)
+		}
+		_, err := s.q.Exec("INSERT INTO `data` (id, data, timestamp, protocol) VALUES "+values(end-i, 4)+s.ignoreConflict("id"), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignoreConflict returns the clause which turns the insert into a no-op
+// when the key exists
+func (s *sqlPayloads) ignoreConflict(key string) string {
+	if s.db.Dialect == database.MySQL {
+		return " ON DUPLICATE KEY UPDATE " + key + " = " + key
+	}
+	return " ON CONFLICT (" + key + ") DO NOTHING"
+}
+
+func (s *sqlPayloads) Get(id string) (Payload, error) {
+	p := Payload{}
+	var key, codec string
+	err := s.q.QueryRow("SELECT id, data, blob_key, codec, protocol, timestamp FROM `data` WHERE id = ?", id).Scan(&p.Id, &p.Data, &key, &codec, &p.Protocol, &p.Timestamp)
+	if err != nil {
+		return p, notFound(err)
+	}
+
+	if key != "" {
+		if s.blobs == nil {
+			return p, fmt.Errorf("payload %s is in the blob store, which is not configured", id)
+		}
+		p.Data, err = getPayload(s.blobs, key, codec)
+	}
+	return p, err
+}
+
+type sqlOutcomes sqlStorage
+
+func (s *sqlOutcomes) Add(projectId string, reason string) error {
+	day := time.Now().Format("2006-01-02")
+
+	conflict := " ON CONFLICT (project_id, reason, day) DO UPDATE SET quantity = outcome.quantity + 1"
+	if s.db.Dialect == database.MySQL {
+		conflict = " ON DUPLICATE KEY UPDATE quantity = quantity + 1"
+	}
+
+	_, err := s.q.Exec("INSERT INTO outcome (project_id, reason, day, quantity) VALUES (?, ?, ?, 1)"+conflict, projectId, reason, day)
+	return err
+}
+
+func (s *sqlOutcomes) Totals() (map[string]int, error) {
+	rows, err := s.q.Query("SELECT project_id, SUM(quantity) FROM outcome GROUP BY project_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var quantity int
+		err = rows.Scan(&id, &quantity)
+		if err != nil {
+			return nil, err
+		}
+		totals[id] = quantity
+	}
+	return totals, rows.Err()
+}
+
+func (s *sqlOutcomes) Summary(projectId string, today string, since string) ([]OutcomeSummary, error) {
+	rows, err := s.q.Query("SELECT reason, SUM(CASE WHEN day >= ? THEN quantity ELSE 0 END), SUM(quantity), MAX(day) FROM outcome WHERE project_id = ? AND day >= ? GROUP BY reason ORDER BY reason", today, projectId, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []OutcomeSummary
+	for rows.Next() {
+		o := OutcomeSummary{}
+		err = rows.Scan(&o.Reason, &o.Today, &o.Period, &o.LastDay)
+		if err != nil {
+			return nil, err
+		}
+		if len(o.LastDay) > 10 {
+			o.LastDay = o.LastDay[:10]
+		}
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+type sqlDeliveries sqlStorage
+
+const deliveryColumns = "id, webhook, url, action, group_id, payload, status, attempts, code, error, created, next_at, finished"
+
+func scanDelivery(row scanner) (Delivery, error) {
+	d := Delivery{}
+	var created, next, finished int64
+	err := row.Scan(&d.Id, &d.Webhook, &d.Url, &d.Action, &d.GroupId, &d.Payload, &d.Status, &d.Attempts, &d.Code, &d.Error, &created, &next, &finished)
+	d.Created = unix(created)
+	d.Next = unix(next)
+	d.Finished = unix(finished)
+	return d, err
+}
+
+// unix returns the time of the seconds, zero is no time
+func unix(s int64) time.Time {
+	if s == 0 {
+		return time.Time{}
+	}
+	return time.Unix(s, 0)
+}
+
+func (s *sqlDeliveries) Create(d *Delivery) error {
+	id, err := s.q.Insert("INSERT INTO delivery (webhook, url, action, group_id, payload, status, attempts, code, error, created, next_at, finished) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)",
+		d.Webhook, d.Url, d.Action, d.GroupId, d.Payload, d.Status, d.Attempts, d.Code, d.Error, d.Created.Unix(), d.Next.Unix())
+	if err != nil {
+		return err
+	}
+	d.Id = id
+	return nil
+}
+
+func (s *sqlDeliveries) Get(id int64) (Delivery, error) {
+	d, err := scanDelivery(s.q.QueryRow("SELECT "+deliveryColumns+" FROM delivery WHERE id = ?", id))
+	return d, notFound(err)
+}
+
+func (s *sqlDeliveries) List(limit int) ([]Delivery, error) {
+	return s.list("SELECT "+deliveryColumns+" FROM delivery ORDER BY id DESC LIMIT ?", limit)
+}
+
+func (s *sqlDeliveries) Due(now time.Time, limit int) ([]Delivery, error) {
+	return s.list("SELECT "+deliveryColumns+" FROM delivery WHERE status = ? AND next_at <= ? ORDER BY next_at, id LIMIT ?", DeliveryPending, now.Unix(), limit)
+}
+
+func (s *sqlDeliveries) list(query string, args ...interface{}) ([]Delivery, error) {
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlDeliveries) Claim(d Delivery, until time.Time) (bool, error) {
+	res, err := s.q.Exec("UPDATE delivery SET attempts = attempts + 1, next_at = ? WHERE id = ? AND status = ? AND attempts = ?", until.Unix(), d.Id, DeliveryPending, d.Attempts)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqlDeliveries) Finish(d Delivery) error {
+	var finished int64
+	if !d.Finished.IsZero() {
+		finished = d.Finished.Unix()
+	}
+	_, err := s.q.Exec("UPDATE delivery SET status = ?, code = ?, error = ?, next_at = ?, finished = ? WHERE id = ?", d.Status, d.Code, d.Error, d.Next.Unix(), finished, d.Id)
+	return err
+}
+
+func (s *sqlDeliveries) Redeliver(id int64) error {
+	res, err := s.q.Exec("UPDATE delivery SET status = ?, attempts = 0, next_at = ? WHERE id = ? AND status <> ?", DeliveryPending, time.Now().Unix(), id, DeliveryPending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlDeliveries) Prune(before time.Time) (int, error) {
+	res, err := s.q.Exec("DELETE FROM delivery WHERE status <> ? AND created < ?", DeliveryPending, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+type sqlAlerts sqlStorage
+
+func (s *sqlAlerts) Count(groupId int64, at time.Time, userHash string) error {
+	conflict := " ON CONFLICT (group_id, minute) DO UPDATE SET quantity = alert_count.quantity + 1"
+	if s.db.Dialect == database.MySQL {
+		conflict = " ON DUPLICATE KEY UPDATE quantity = quantity + 1"
+	}
+	_, err := s.q.Exec("INSERT INTO alert_count (group_id, minute, quantity) VALUES (?, ?, 1)"+conflict, groupId, at.Unix()/60)
+	if err != nil || userHash == "" {
+		return err
+	}
+
+	conflict = " ON CONFLICT (group_id, user_hash) DO UPDATE SET last_seen = excluded.last_seen"
+	if s.db.Dialect == database.MySQL {
+		conflict = " ON DUPLICATE KEY UPDATE last_seen = VALUES(last_seen)"
+	}
+	_, err = s.q.Exec("INSERT INTO alert_user (group_id, user_hash, last_seen) VALUES (?, ?, ?)"+conflict, groupId, userHash, at.Unix())
+	return err
+}
+
+func (s *sqlAlerts) Events(groupId int64, since time.Time) (int, error) {
+	var n int
+	err := s.q.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM alert_count WHERE group_id = ? AND minute >= ?", groupId, since.Unix()/60).Scan(&n)
+	return n, err
+}
+
+func (s *sqlAlerts) Users(groupId int64, since time.Time) (int, error) {
+	var n int
+	err := s.q.QueryRow("SELECT COUNT(*) FROM alert_user WHERE group_id = ? AND last_seen >= ?", groupId, since.Unix()).Scan(&n)
+	return n, err
+}
+
+func (s *sqlAlerts) FirstRelease(groupId int64, release string) (string, error) {
+	if release != "" {
+		_, err := s.q.Exec("INSERT INTO alert_release (group_id, first_release) VALUES (?, ?)"+(*sqlPayloads)(s).ignoreConflict("group_id"), groupId, release)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var first string
+	err := s.q.QueryRow("SELECT first_release FROM alert_release WHERE group_id = ?", groupId).Scan(&first)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return first, err
+}
+
+// Fire keeps the affected rows 0 when the rule has fired after the time, the
+// same way on every database
+func (s *sqlAlerts) Fire(rule string, groupId int64, now time.Time, after time.Time) (bool, error) {
+	conflict := " ON CONFLICT (rule_id, group_id) DO UPDATE SET fired = excluded.fired WHERE alert_fired.fired <= ?"
+	if s.db.Dialect == database.MySQL {
+		conflict = " ON DUPLICATE KEY UPDATE fired = IF(fired <= ?, VALUES(fired), fired)"
+	}
+	res, err := s.q.Exec("INSERT INTO alert_fired (rule_id, group_id, fired) VALUES (?, ?, ?)"+conflict, rule, groupId, now.Unix(), after.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlAlerts) Log(e *Evaluation) error {
+	fired := 0
+	if e.Fired {
+		fired = 1
+	}
+	id, err := s.q.Insert("INSERT INTO alert_log (rule_id, project_id, group_id, fired, reason, created) VALUES (?, ?, ?, ?, ?, ?)",
+		e.Rule, e.ProjectId, e.GroupId, fired, e.Reason, e.Created.Unix())
+	if err != nil {
+		return err
+	}
+	e.Id = id
+	return nil
+}
+
+func (s *sqlAlerts) History(limit int) ([]Evaluation, error) {
+	rows, err := s.q.Query("SELECT id, rule_id, project_id, group_id, fired, reason, created FROM alert_log ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Evaluation
+	for rows.Next() {
+		e := Evaluation{}
+		var fired int
+		var created int64
+		err = rows.Scan(&e.Id, &e.Rule, &e.ProjectId, &e.GroupId, &fired, &e.Reason, &created)
+		if err != nil {
+			return nil, err
+		}
+		e.Fired = fired == 1
+		e.Created = unix(created)
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlAlerts) Prune(counters time.Time, history time.Time) error {
+	queries := []struct {
+		query string
+		arg   int64
+	}{
+		{"DELETE FROM alert_count WHERE minute < ?", counters.Unix() / 60},
+		{"DELETE FROM alert_user WHERE last_seen < ?", counters.Unix()},
+		{"DELETE FROM alert_fired WHERE fired < ?", counters.Unix()},
+		{"DELETE FROM alert_log WHERE created < ?", history.Unix()},
+	}
+	for _, q := range queries {
+		if _, err := s.q.Exec(q.query, q.arg); err != nil {
+			return err
+		}
+	}
+
+	// the groups deleted by the retention
+	_, err := s.q.Exec("DELETE FROM alert_release WHERE group_id NOT IN (SELECT id FROM `group`)")
+	return err
+}
+
+type sqlSubscriptions sqlStorage
+
+func (s *sqlSubscriptions) Get(email string, projectId string, groupId int64) (Subscription, error) {
+	sub := Subscription{}
+	err := s.q.QueryRow("SELECT email, project_id, group_id, mode FROM subscription WHERE email = ? AND project_id = ? AND group_id = ?", email, projectId, groupId).Scan(&sub.Email, &sub.ProjectId, &sub.GroupId, &sub.Mode)
+	return sub, notFound(err)
+}
+
+func (s *sqlSubscriptions) List(projectId string, groupId int64) ([]Subscription, error) {
+	rows, err := s.q.Query("SELECT email, project_id, group_id, mode FROM subscription WHERE project_id = ? AND group_id IN (0, ?)", projectId, groupId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Subscription
+	for rows.Next() {
+		sub := Subscription{}
+		if err := rows.Scan(&sub.Email, &sub.ProjectId, &sub.GroupId, &sub.Mode); err != nil {
+			return nil, err
+		}
+		list = append(list, sub)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlSubscriptions) Set(sub Subscription) error {
+	conflict := " ON CONFLICT (email, project_id, group_id) DO UPDATE SET mode = excluded.mode"
+	if s.db.Dialect == database.MySQL {
+		conflict = " ON DUPLICATE KEY UPDATE mode = VALUES(mode)"
+	}
+	_, err := s.q.Exec("INSERT INTO subscription (email, project_id, group_id, mode) VALUES (?, ?, ?, ?)"+conflict, sub.Email, sub.ProjectId, sub.GroupId, sub.Mode)
+	return err
+}
+
+func (s *sqlSubscriptions) Delete(email string, projectId string, groupId int64) error {
+	_, err := s.q.Exec("DELETE FROM subscription WHERE email = ? AND project_id = ? AND group_id = ?", email, projectId, groupId)
+	return err
+}
+
+type sqlDigests sqlStorage
+
+func (s *sqlDigests) Add(d DigestItem) error {
+	conflict := " ON CONFLICT (email, group_id) DO UPDATE SET quantity = digest.quantity + 1, last_seen = excluded.last_seen"
+	if s.db.Dialect == database.MySQL {
+		conflict = " ON DUPLICATE KEY UPDATE quantity = quantity + 1, last_seen = VALUES(last_seen)"
+	}
+	_, err := s.q.Exec("INSERT INTO digest (email, project_id, group_id, kind, message, `level`, stacktrace, quantity, created, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?, ?)"+conflict,
+		d.Email, d.ProjectId, d.GroupId, d.Kind, d.Message, d.Level, d.Stacktrace, d.Created.Unix(), d.LastSeen.Unix())
+	return err
+}
+
+func (s *sqlDigests) Due(before time.Time) ([]string, error) {
+	rows, err := s.q.Query("SELECT DISTINCT email FROM digest WHERE created <= ?", before.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		list = append(list, email)
+	}
+	return list, rows.Err()
+}
+
+// Take deletes the rows it read by id, the affected rows tell if another
+// process deleted them first
+func (s *sqlDigests) Take(email string) ([]DigestItem, error) {
+	var list []DigestItem
+	err := (*sqlStorage)(s).Atomic(func(tx Storage) error {
+		q := tx.(*sqlStorage).q
+
+		rows, err := q.Query("SELECT id, email, project_id, group_id, kind, message, `level`, stacktrace, quantity, created, last_seen FROM digest WHERE email = ? ORDER BY id", email)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var ids []interface{}
+		for rows.Next() {
+			d := DigestItem{}
+			var created, lastSeen int64
+			err = rows.Scan(&d.Id, &d.Email, &d.ProjectId, &d.GroupId, &d.Kind, &d.Message, &d.Level, &d.Stacktrace, &d.Quantity, &created, &lastSeen)
+			if err != nil {
+				return err
+			}
+			d.Created = unix(created)
+			d.LastSeen = unix(lastSeen)
+			list = append(list, d)
+			ids = append(ids, d.Id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		if len(ids) == 0 {
+			return nil
+		}
+		res, err := q.Exec("DELETE FROM digest WHERE id IN ("+placeholders(len(ids))+")", ids...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n != int64(len(ids)) {
+			list = nil
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *sqlDigests) Restore(items []DigestItem) error {
+	conflict := " ON CONFLICT (email, group_id) DO UPDATE SET quantity = digest.quantity + excluded.quantity, kind = excluded.kind, created = MIN(digest.created, excluded.created)"
+	switch s.db.Dialect {
+	case database.MySQL:
+		conflict = " ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity), kind = VALUES(kind), created = LEAST(created, VALUES(created))"
+	case database.Postgres:
+		conflict = " ON CONFLICT (email, group_id) DO UPDATE SET quantity = digest.quantity + excluded.quantity, kind = excluded.kind, created = LEAST(digest.created, excluded.created)"
+	}
+
+	return (*sqlStorage)(s).Atomic(func(tx Storage) error {
+		q := tx.(*sqlStorage).q
+		for _, d := range items {
+			_, err := q.Exec("INSERT INTO digest (email, project_id, group_id, kind, message, `level`, stacktrace, quantity, created, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"+conflict,
+				d.Email, d.ProjectId, d.GroupId, d.Kind, d.Message, d.Level, d.Stacktrace, d.Quantity, d.Created.Unix(), d.LastSeen.Unix())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type sqlReports sqlStorage
+
+func (s *sqlReports) Count(projectId string, groupId int64, day string, isNew bool, isRegression bool) error {
+	created, regressed := 0, 0
+	if isNew {
+		created = 1
+	}
+	if isRegression {
+		regressed = 1
+	}
+
+	conflict := " ON CONFLICT (group_id, day) DO UPDATE SET quantity = report_day.quantity + 1, new_count = report_day.new_count + excluded.new_count, regressed_count = report_day.regressed_count + excluded.regressed_count"
+	if s.db.Dialect == database.MySQL {
+		conflict = " ON DUPLICATE KEY UPDATE quantity = quantity + 1, new_count = new_count + VALUES(new_count), regressed_count = regressed_count + VALUES(regressed_count)"
+	}
+	_, err := s.q.Exec("INSERT INTO report_day (group_id, day, project_id, quantity, new_count, regressed_count) VALUES (?, ?, ?, 1, ?, ?)"+conflict, groupId, day, projectId, created, regressed)
+	return err
+}
+
+func (s *sqlReports) Summary(since string, until string) ([]ReportItem, error) {
+	rows, err := s.q.Query("SELECT r.project_id, r.group_id, g.message, g.`level`, SUM(r.quantity) AS total, SUM(r.new_count), SUM(r.regressed_count) FROM report_day r JOIN `group` g ON g.id = r.group_id WHERE r.day >= ? AND r.day <= ? GROUP BY r.project_id, r.group_id, g.message, g.`level` ORDER BY total DESC, r.group_id", since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ReportItem
+	for rows.Next() {
+		r := ReportItem{}
+		if err := rows.Scan(&r.ProjectId, &r.GroupId, &r.Message, &r.Level, &r.Quantity, &r.New, &r.Regressed); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlReports) Sent(kind string, day string) (bool, error) {
+	conflict := " ON CONFLICT (kind, day) DO NOTHING"
+	if s.db.Dialect == database.MySQL {
+		conflict = " ON DUPLICATE KEY UPDATE kind = kind"
+	}
+	res, err := s.q.Exec("INSERT INTO report_sent (kind, day) VALUES (?, ?)"+conflict, kind, day)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqlReports) Unsent(kind string, day string) error {
+	_, err := s.q.Exec("DELETE FROM report_sent WHERE kind = ? AND day = ?", kind, day)
+	return err
+}
+
+func (s *sqlReports) Prune(before string) error {
+	if _, err := s.q.Exec("DELETE FROM report_day WHERE day < ?", before); err != nil {
+		return err
+	}
+	_, err := s.q.Exec("DELETE FROM report_sent WHERE day < ?", before)
+	return err
+}
+
+type sqlRetention sqlStorage
+
+const (
+	// chunkSize keeps the IN lists of the queries short
+	chunkSize = 500
+	// insertRows is the number of rows of a multi-row insert, within the
+	// placeholder limit of every database
+	insertRows = 100
+)
+
+const payloadSize = "CASE WHEN d.blob_key = '' THEN LENGTH(d.data) ELSE d.size END"
+
+func (s *sqlRetention) ids(query string, args ...interface{}) ([]int64, error) {
+	rows, err := s.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		list = append(list, id)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlRetention) count(query string, args ...interface{}) (int, error) {
+	var c int
+	err := s.q.QueryRow(query, args...).Scan(&c)
+	return c, err
+}
+
+func (s *sqlRetention) Aged(projectId string, before time.Time) ([]int64, error) {
+	return s.ids("SELECT e.id FROM event e JOIN `group` g ON g.id = e.group_id JOIN `data` d ON d.id = e.data_id WHERE g.project_id = ? AND d.timestamp < ?", projectId, before)
+}
+
+func (s *sqlRetention) Overflow(projectId string, max int) ([]int64, error) {
+	groups, err := s.ids("SELECT e.group_id FROM event e JOIN `group` g ON g.id = e.group_id WHERE g.project_id = ? GROUP BY e.group_id HAVING COUNT(*) > ?", projectId, max)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []int64
+	for _, groupId := range groups {
+		events, err := s.ids("SELECT id FROM event WHERE group_id = ? ORDER BY id DESC", groupId)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > max {
+			list = append(list, events[max:]...)
+		}
+	}
+	return list, nil
+}
+
+// Oversize pages through the sizes of the newest events until they are over
+// max, the older ones are read by their ids only
+func (s *sqlRetention) Oversize(projectId string, max int64) ([]int64, error) {
+	var total int64
+	var last int64
+	for {
+		query := "SELECT e.id, " + payloadSize + " FROM event e JOIN `group` g ON g.id = e.group_id JOIN `data` d ON d.id = e.data_id WHERE g.project_id = ?"
+		args := []interface{}{projectId}
+		if last != 0 {
+			query += " AND e.id < ?"
+			args = append(args, last)
+		}
+		rows, err := s.q.Query(query+" ORDER BY e.id DESC LIMIT ?", append(args, chunkSize)...)
+		if err != nil {
+			return nil, err
+		}
+
+		n := 0
+		for rows.Next() {
+			var id, size int64
+			if err := rows.Scan(&id, &size); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			n++
+			last = id
+			total += size
+			if total > max {
+				break
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		if total > max {
+			return s.ids("SELECT e.id FROM event e JOIN `group` g ON g.id = e.group_id WHERE g.project_id = ? AND e.id <= ? ORDER BY e.id DESC", projectId, last)
+		}
+		if n < chunkSize {
+			return nil, nil
+		}
+	}
+}
+
+func (s *sqlRetention) Delete(events []int64, dryRun bool) (Removed, error) {
+	removed := Removed{}
+
+	// events to go by group and by payload
+	groups := make(map[int64]int)
+	payloads := make(map[string]int)
+	for _, chunk := range chunks(events) {
+		rows, err := s.q.Query("SELECT group_id, data_id FROM event WHERE id IN ("+placeholders(len(chunk))+")", chunk...)
+		if err != nil {
+			return removed, err
+		}
+		for rows.Next() {
+			var groupId int64
+			var dataId string
+			if err := rows.Scan(&groupId, &dataId); err != nil {
+				rows.Close()
+				return removed, err
+			}
+			groups[groupId]++
+			payloads[dataId]++
+			removed.Events++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return removed, err
+		}
+	}
+
+	var emptyGroups []int64
+	for groupId, n := range groups {
+		c, err := s.count("SELECT COUNT(*) FROM event WHERE group_id = ?", groupId)
+		if err != nil {
+			return removed, err
+		}
+		if c <= n {
+			emptyGroups = append(emptyGroups, groupId)
+		}
+	}
+
+	var orphans []string
+	for dataId, n := range payloads {
+		c, err := s.count("SELECT COUNT(*) FROM event WHERE data_id = ?", dataId)
+		if err != nil {
+			return removed, err
+		}
+		if c <= n {
+			orphans = append(orphans, dataId)
+		}
+	}
+
+	bytes, err := s.size(orphans)
+	if err != nil {
+		return removed, err
+	}
+	removed.Bytes = bytes
+	removed.Groups = len(emptyGroups)
+	removed.Payloads = len(orphans)
+
+	if dryRun {
+		return removed, nil
+	}
+
+	for _, chunk := range chunks(events) {
+		_, err := s.q.Exec("DELETE FROM event WHERE id IN ("+placeholders(len(chunk))+")", chunk...)
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	if err := s.deletePayloads(orphans); err != nil {
+		return removed, err
+	}
+	return removed, s.deleteGroups(emptyGroups)
+}
+
+func (s *sqlRetention) Orphans(dryRun bool) (Removed, error) {
+	removed := Removed{}
+
+	rows, err := s.q.Query("SELECT d.id FROM `data` d LEFT JOIN event e ON e.data_id = d.id WHERE e.id IS NULL")
+	if err != nil {
+		return removed, err
+	}
+	var orphans []string
+	for rows.Next() {
+		var dataId string
+		if err := rows.Scan(&dataId); err != nil {
+			rows.Close()
+			return removed, err
+		}
+		orphans = append(orphans, dataId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return removed, err
+	}
+
+	emptyGroups, err := s.ids("SELECT g.id FROM `group` g LEFT JOIN event e ON e.group_id = g.id WHERE e.id IS NULL")
+	if err != nil {
+		return removed, err
+	}
+
+	bytes, err := s.size(orphans)
+	if err != nil {
+		return removed, err
+	}
+	removed.Bytes = bytes
+	removed.Groups = len(emptyGroups)
+	removed.Payloads = len(orphans)
+
+	if dryRun {
+		return removed, nil
+	}
+
+	if err := s.deletePayloads(orphans); err != nil {
+		return removed, err
+	}
+	return removed, s.deleteGroups(emptyGroups)
+}
+
+// size returns the bytes of the payloads
+func (s *sqlRetention) size(payloads []string) (int64, error) {
+	var total int64
+	for _, dataId := range payloads {
+		var size int64
+		err := s.q.QueryRow("SELECT "+payloadSize+" FROM `data` d WHERE d.id = ?", dataId).Scan(&size)
+		if err != nil && err != sql.ErrNoRows {
+			return total, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// deletePayloads removes the payloads and their blobs, an event may have
+// arrived since, the payload stays then
+func (s *sqlRetention) deletePayloads(payloads []string) error {
+	for _, dataId := range payloads {
+		var key string
+		err := s.q.QueryRow("SELECT blob_key FROM `data` WHERE id = ?", dataId).Scan(&key)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if key != "" && s.blobs == nil {
+			return fmt.Errorf("payload %s is in the blob store, which is not configured", dataId)
+		}
+
+		_, err = s.q.Exec("DELETE FROM `data` WHERE id = ? AND NOT EXISTS (SELECT 1 FROM event WHERE data_id = ?)", dataId, dataId)
+		if err != nil {
+			return err
+		}
+
+		if key == "" {
+			continue
+		}
+		c, err := s.count("SELECT COUNT(*) FROM `data` WHERE blob_key = ?", key)
+		if err != nil {
+			return err
+		}
+		if c == 0 {
+			if err := s.blobs.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteGroups removes the groups still without events
+func (s *sqlRetention) deleteGroups(groups []int64) error {
+	for _, groupId := range groups {
+		_, err := s.q.Exec("DELETE FROM `group` WHERE id = ? AND NOT EXISTS (SELECT 1 FROM event WHERE group_id = ?)", groupId, groupId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlRetention) Compact() error {
+	switch s.db.Dialect {
+	case database.SQLite, database.Postgres:
+		_, err := s.q.Exec("VACUUM")
+		return err
+	case database.MySQL:
+		// OPTIMIZE returns a status row for every table
+		rows, err := s.q.Query("OPTIMIZE TABLE `event`, `data`, `group`")
+		if err != nil {
+			return err
+		}
+		return rows.Close()
+	}
+	return nil
+}
+
+func chunks(ids []int64) [][]interface{} {
+	var list [][]interface{}
+	for i := 0; i < len(ids); i += chunkSize {
+		end := i + chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk := make([]interface{}, 0, end-i)
+		for _, id := range ids[i:end] {
+			chunk = append(chunk, id)
+		}
+		list = append(list, chunk)
+	}
+	return list
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// values returns the rows of a multi-row insert
+func values(n int, columns int) string {
+	return strings.TrimSuffix(strings.Repeat("("+placeholders(columns)+"), ", n), ", ")
+}
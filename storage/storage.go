@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// The statuses of a group, a new event reopens a resolved one only
+const (
+	GroupOpen     = 0
+	GroupResolved = 1
+	GroupIgnored  = 2
+)
+
+// Group is an issue, events with the same checksum in a project. The seen
+// times are kept as the database returns them
+type Group struct {
+	Id         int64
+	ProjectId  string
+	Checksum   string
+	Logger     string
+	Level      string
+	Message    string
+	ServerName string
+	Url        string
+	Site       string
+	Platform   string
+	Status     int
+	// Assignee is the email address of the user working on it
+	Assignee  string
+	Seen      int64
+	Sampled   int64
+	FirstSeen string
+	LastSeen  string
+}
+
+// Event is one stored occurrence of a group, the payload is shared by the
+// events with the same content
+type Event struct {
+	Id       int64
+	GroupId  int64
+	DataId   string
+	Message  string
+	Checksum string
+}
+
+// Payload is the raw event as received, Data is base64 encoded
+type Payload struct {
+	Id        string
+	Data      string
+	Protocol  string
+	Timestamp string
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is a request of an outgoing webhook, Payload is the JSON body.
+// Next is the time of the next attempt while it is pending
+type Delivery struct {
+	Id       int64
+	Webhook  string
+	Url      string
+	Action   string
+	GroupId  int64
+	Payload  string
+	Status   string
+	Attempts int
+	Code     int
+	Error    string
+	Created  time.Time
+	Next     time.Time
+	Finished time.Time
+}
+
+// Evaluation is a check of an alert rule on an event, Reason tells why it
+// fired or not
+type Evaluation struct {
+	Id        int64
+	Rule      string
+	ProjectId string
+	GroupId   int64
+	Fired     bool
+	Reason    string
+	Created   time.Time
+}
+
+const (
+	// SubscribeAll is every event
+	SubscribeAll        = "all"
+	SubscribeNew        = "new"
+	SubscribeRegression = "regression"
+	SubscribeNone       = "none"
+)
+
+// Subscription is the email notification preference of a user for the
+// project, or for an issue of it when GroupId is set
+type Subscription struct {
+	Email     string
+	ProjectId string
+	GroupId   int64
+	Mode      string
+}
+
+// DigestItem is an issue waiting in the digest email of a recipient, Kind
+// is the subscription mode of its first event
+type DigestItem struct {
+	Id         int64
+	Email      string
+	ProjectId  string
+	GroupId    int64
+	Kind       string
+	Message    string
+	Level      string
+	Stacktrace string
+	Quantity   int
+	Created    time.Time
+	LastSeen   time.Time
+}
+
+// ReportItem is an issue of a summary report with its counters of the days
+type ReportItem struct {
+	ProjectId string
+	GroupId   int64
+	Message   string
+	Level     string
+	Quantity  int
+	New       int
+	Regressed int
+}
+
+type ProjectCount struct {
+	ProjectId string
+	Groups    int
+	Seen      int
+}
+
+type OutcomeSummary struct {
+	Reason  string
+	Today   int
+	Period  int
+	LastDay string
+}
+
+type GroupStore interface {
+	// List returns the groups with the status, latest first
+	List(status int) ([]Group, error)
+	Get(id int64) (Group, error)
+	Find(projectId string, checksum string) (Group, error)
+	// Upsert stores the group seen once unless one with the same project and
+	// checksum exists, then the id, status and counters of that one are set
+	// and it stays locked until the end of the transaction
+	Upsert(g *Group, seen time.Time) (bool, error)
+	// Touch counts count new events of the group, sampled of them without a
+	// stored payload, and reopens a resolved one. The last seen time only
+	// moves forward
+	Touch(g Group, seen time.Time, count int, sampled int) error
+	SetStatus(id int64, status int) error
+	// Assign sets the assignee of the group, empty clears it
+	Assign(id int64, assignee string) error
+	// Changed returns the latest seen time and the number of open groups seen
+	// after since
+	Changed(since string) (string, int, error)
+	Projects() ([]ProjectCount, error)
+}
+
+type EventStore interface {
+	// Create stores the event and sets its id
+	Create(e *Event) error
+	// CreateMany stores the events with multi-row inserts, the ids are not set
+	CreateMany(events []Event) error
+	Get(groupId int64, id int64) (Event, error)
+	Latest(groupId int64) (Event, error)
+	// Older and Newer return the id of the neighbour event or 0
+	Older(groupId int64, id int64) (int64, error)
+	Newer(groupId int64, id int64) (int64, error)
+}
+
+type PayloadStore interface {
+	// Store saves the payload, one with the same id is left as is
+	Store(p Payload, received time.Time) error
+	// StoreMany saves the payloads received at the times of the same index
+	// with multi-row inserts
+	StoreMany(payloads []Payload, received []time.Time) error
+	Get(id string) (Payload, error)
+}
+
+type OutcomeStore interface {
+	// Add counts a dropped event of the project by reason for today
+	Add(projectId string, reason string) error
+	// Totals returns the dropped events by project
+	Totals() (map[string]int, error)
+	// Summary returns the dropped events of the project by reason since the
+	// day, Today counts the ones from the today day
+	Summary(projectId string, today string, since string) ([]OutcomeSummary, error)
+}
+
+type DeliveryStore interface {
+	// Create stores the delivery and sets its id
+	Create(d *Delivery) error
+	Get(id int64) (Delivery, error)
+	// List returns the latest deliveries first
+	List(limit int) ([]Delivery, error)
+	// Due returns the pending deliveries whose next attempt has come
+	Due(now time.Time, limit int) ([]Delivery, error)
+	// Claim counts an attempt of the delivery and holds it until the time,
+	// false means another process took it meanwhile
+	Claim(d Delivery, until time.Time) (bool, error)
+	// Finish records the status, code, error, next and finished time of the
+	// attempt
+	Finish(d Delivery) error
+	// Redeliver makes the finished delivery pending again from the first
+	// attempt
+	Redeliver(id int64) error
+	// Prune deletes the finished deliveries created before the time
+	Prune(before time.Time) (int, error)
+}
+
+type AlertStore interface {
+	// Count counts an event of the group in the minute of the time, and the
+	// user of the event unless userHash is empty
+	Count(groupId int64, at time.Time, userHash string) error
+	// Events returns the number of events of the group from the minute of
+	// the time
+	Events(groupId int64, since time.Time) (int, error)
+	// Users returns the number of users of the group seen since the time
+	Users(groupId int64, since time.Time) (int, error)
+	// FirstRelease returns the release the group was first seen in, the
+	// release is kept as that one when the group has none yet
+	FirstRelease(groupId int64, release string) (string, error)
+	// Fire records the rule fired for the group at now unless it has fired
+	// after the time, false means it has
+	Fire(rule string, groupId int64, now time.Time, after time.Time) (bool, error)
+	// Log stores the evaluation and sets its id
+	Log(e *Evaluation) error
+	// History returns the latest evaluations first
+	History(limit int) ([]Evaluation, error)
+	// Prune deletes the counters and firing times before the first time and
+	// the evaluations before the second one
+	Prune(counters time.Time, history time.Time) error
+}
+
+type SubscriptionStore interface {
+	// Get returns the preference of the user for the project with zero
+	// groupId, for the issue otherwise
+	Get(email string, projectId string, groupId int64) (Subscription, error)
+	// List returns the preferences of every user for the project and the
+	// issue
+	List(projectId string, groupId int64) ([]Subscription, error)
+	// Set stores the preference, it replaces the one of the same user,
+	// project and issue
+	Set(s Subscription) error
+	Delete(email string, projectId string, groupId int64) error
+}
+
+type DigestStore interface {
+	// Add counts an event of the issue in the digest of the recipient, the
+	// other fields are kept from the first one
+	Add(item DigestItem) error
+	// Due returns the recipients with an issue waiting since before the time
+	Due(before time.Time) ([]string, error)
+	// Take removes and returns the issues of the recipient, none when
+	// another process took them meanwhile
+	Take(email string) ([]DigestItem, error)
+	// Restore puts back the taken issues of a digest that was not sent, they
+	// are merged with the ones added meanwhile
+	Restore(items []DigestItem) error
+}
+
+type ReportStore interface {
+	// Count counts an event of the issue for the day
+	Count(projectId string, groupId int64, day string, isNew bool, isRegression bool) error
+	// Summary returns the issues seen from the first day until the last
+	// one, the most frequent first
+	Summary(since string, until string) ([]ReportItem, error)
+	// Sent records the report of the kind and day, false means it was sent
+	// before
+	Sent(kind string, day string) (bool, error)
+	// Unsent forgets the record of a report that was not sent, the next run
+	// sends it
+	Unsent(kind string, day string) error
+	// Prune deletes the counters and the sent reports before the day
+	Prune(before string) error
+}
+
+// Removed counts what a cleanup deleted or would delete
+type Removed struct {
+	Events   int
+	Payloads int
+	Groups   int
+	Bytes    int64
+}
+
+type RetentionStore interface {
+	// Aged returns the events of the project received before the time
+	Aged(projectId string, before time.Time) ([]int64, error)
+	// Overflow returns the events of the project beyond the newest max of
+	// each group
+	Overflow(projectId string, max int) ([]int64, error)
+	// Oversize returns the oldest events of the project whose payloads do not
+	// fit in max bytes
+	Oversize(projectId string, max int64) ([]int64, error)
+	// Delete removes the events, their payloads nothing refers to anymore and
+	// their groups left without events. With dryRun only the counts are
+	// returned
+	Delete(events []int64, dryRun bool) (Removed, error)
+	// Orphans removes every payload and group without events, the ones left
+	// behind by an interrupted cleanup
+	Orphans(dryRun bool) (Removed, error)
+	// Compact gives the free space back to the system where the database
+	// needs it
+	Compact() error
+}
+
+// Storage gives access to the repositories, see NewSQL and NewMemory
+type Storage interface {
+	Groups() GroupStore
+	Events() EventStore
+	Payloads() PayloadStore
+	Outcomes() OutcomeStore
+	Retention() RetentionStore
+	Deliveries() DeliveryStore
+	Alerts() AlertStore
+	Subscriptions() SubscriptionStore
+	Digests() DigestStore
+	Reports() ReportStore
+	// Atomic runs fn in a transaction, it is rolled back when fn fails
+	Atomic(fn func(Storage) error) error
+}
+
+// UserStore gives access to the users of the authenticated mode
+type UserStore interface {
+	// Authenticate returns the id of the enabled user with the credentials
+	Authenticate(email string, password string) (int, bool)
+	// Recipients returns the email addresses of the enabled users
+	Recipients() []string
+	// Email returns the email address of the enabled user with the id
+	Email(id int) (string, bool)
+}
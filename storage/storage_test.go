@@ -0,0 +1,290 @@
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/scr34m/proof/database"
+	"github.com/scr34m/proof/migrate"
+	"github.com/scr34m/proof/storage"
+)
+
+// PostgresEnv is the DSN of a throwaway Postgres database, its schema is
+// dropped and migrated again for every test
+const PostgresEnv = "PROOF_TEST_POSTGRES"
+
+// backends runs the test against every storage, Postgres only when its DSN
+// is set
+func backends(t *testing.T, test func(t *testing.T, repo storage.Storage)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, storage.NewMemory())
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "proof.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		migrated(t, db, false)
+		test(t, storage.NewSQL(db, nil))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(PostgresEnv)
+		if dsn == "" {
+			t.Skipf("%s is not set", PostgresEnv)
+		}
+		db, err := database.Open(database.Postgres, dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		migrated(t, db, true)
+		test(t, storage.NewSQL(db, nil))
+	})
+}
+
+// migrated applies the migrations, with reset the existing schema is
+// reverted first
+func migrated(t *testing.T, db *database.DB, reset bool) {
+	m, err := migrate.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for reset {
+		version, err := m.Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version == 0 {
+			break
+		}
+		if err := m.Down(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now()
+
+		g := storage.Group{ProjectId: "1", Checksum: "a", Logger: "go", Level: "error", Message: "first", Platform: "go"}
+		new, err := repo.Groups().Upsert(&g, now)
+		check(t, err)
+		if !new || g.Id == 0 || g.Seen != 1 || g.Status != 0 {
+			t.Fatalf("new group: %v %+v", new, g)
+		}
+
+		again := storage.Group{ProjectId: "1", Checksum: "a", Message: "second"}
+		new, err = repo.Groups().Upsert(&again, now)
+		check(t, err)
+		if new || again.Id != g.Id || again.Seen != 1 {
+			t.Fatalf("existing group: %v %+v", new, again)
+		}
+
+		other := storage.Group{ProjectId: "2", Checksum: "a", Message: "other"}
+		new, err = repo.Groups().Upsert(&other, now)
+		check(t, err)
+		if !new || other.Id == g.Id {
+			t.Fatalf("group of another project: %v %+v", new, other)
+		}
+
+		check(t, repo.Groups().SetStatus(g.Id, 1))
+		open, err := repo.Groups().List(0)
+		check(t, err)
+		if len(open) != 1 || open[0].Id != other.Id {
+			t.Fatalf("open groups: %+v", open)
+		}
+
+		g.Message = "latest"
+		check(t, repo.Groups().Touch(g, now.Add(time.Second), 3, 2))
+
+		found, err := repo.Groups().Find("1", "a")
+		check(t, err)
+		if found.Id != g.Id || found.Seen != 4 || found.Sampled != 2 || found.Status != 0 || found.Message != "latest" {
+			t.Fatalf("touched group: %+v", found)
+		}
+		if found.FirstSeen == "" || found.LastSeen == "" {
+			t.Fatalf("seen times: %+v", found)
+		}
+
+		// an older event counts but leaves the last seen time, an ignored
+		// group stays ignored
+		check(t, repo.Groups().SetStatus(g.Id, storage.GroupIgnored))
+		check(t, repo.Groups().Assign(g.Id, "ops@example.com"))
+		check(t, repo.Groups().Touch(found, now.Add(-time.Hour), 1, 0))
+		late, err := repo.Groups().Get(g.Id)
+		check(t, err)
+		if late.Seen != 5 || late.LastSeen != found.LastSeen {
+			t.Fatalf("last seen %s moved back to %s", found.LastSeen, late.LastSeen)
+		}
+		if late.Status != storage.GroupIgnored || late.Assignee != "ops@example.com" {
+			t.Fatalf("ignored group: %+v", late)
+		}
+
+		if _, err := repo.Groups().Get(g.Id + other.Id); err != storage.ErrNotFound {
+			t.Fatalf("missing group: %v", err)
+		}
+		if _, err := repo.Groups().Find("1", "b"); err != storage.ErrNotFound {
+			t.Fatalf("missing checksum: %v", err)
+		}
+
+		projects, err := repo.Groups().Projects()
+		check(t, err)
+		seen := make(map[string]int)
+		for _, p := range projects {
+			seen[p.ProjectId] = p.Seen
+		}
+		if len(seen) != 2 || seen["1"] != 5 || seen["2"] != 1 {
+			t.Fatalf("projects: %+v", projects)
+		}
+	})
+}
+
+func TestEvents(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		now := time.Now()
+
+		g := storage.Group{ProjectId: "1", Checksum: "a", Message: "event"}
+		_, err := repo.Groups().Upsert(&g, now)
+		check(t, err)
+
+		payloads := []storage.Payload{
+			{Id: "p1", Data: "eyJhIjoxfQ==", Protocol: "7"},
+			{Id: "p2", Data: "plain", Protocol: "4"},
+		}
+		check(t, repo.Payloads().StoreMany(payloads, []time.Time{now, now}))
+		// the stored one is left as is
+		check(t, repo.Payloads().Store(storage.Payload{Id: "p1", Data: "e30=", Protocol: "7"}, now))
+
+		for _, want := range payloads {
+			p, err := repo.Payloads().Get(want.Id)
+			check(t, err)
+			if p.Data != want.Data || p.Protocol != want.Protocol || p.Timestamp == "" {
+				t.Fatalf("payload %s: %+v", want.Id, p)
+			}
+		}
+		if _, err := repo.Payloads().Get("p3"); err != storage.ErrNotFound {
+			t.Fatalf("missing payload: %v", err)
+		}
+
+		first := storage.Event{GroupId: g.Id, DataId: "p1", Message: "event", Checksum: "a"}
+		check(t, repo.Events().Create(&first))
+		if first.Id == 0 {
+			t.Fatal("event id is not set")
+		}
+		check(t, repo.Events().CreateMany([]storage.Event{
+			{GroupId: g.Id, DataId: "p2", Message: "event", Checksum: "a"},
+			{GroupId: g.Id, DataId: "p1", Message: "event", Checksum: "a"},
+		}))
+
+		latest, err := repo.Events().Latest(g.Id)
+		check(t, err)
+		if latest.Id <= first.Id || latest.DataId != "p1" {
+			t.Fatalf("latest event: %+v", latest)
+		}
+
+		middle, err := repo.Events().Older(g.Id, latest.Id)
+		check(t, err)
+		older, err := repo.Events().Older(g.Id, middle)
+		check(t, err)
+		if middle == 0 || older != first.Id {
+			t.Fatalf("older events: %d %d", middle, older)
+		}
+		newer, err := repo.Events().Newer(g.Id, first.Id)
+		check(t, err)
+		if newer != middle {
+			t.Fatalf("newer event: %d", newer)
+		}
+		if n, err := repo.Events().Newer(g.Id, latest.Id); err != nil || n != 0 {
+			t.Fatalf("newest event: %d %v", n, err)
+		}
+
+		e, err := repo.Events().Get(g.Id, middle)
+		check(t, err)
+		if e.DataId != "p2" {
+			t.Fatalf("event: %+v", e)
+		}
+		if _, err := repo.Events().Get(g.Id+1, middle); err != storage.ErrNotFound {
+			t.Fatalf("event of another group: %v", err)
+		}
+	})
+}
+
+func TestOutcomes(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		check(t, repo.Outcomes().Add("1", "sampled"))
+		check(t, repo.Outcomes().Add("1", "sampled"))
+		check(t, repo.Outcomes().Add("1", "filtered"))
+		check(t, repo.Outcomes().Add("2", "sampled"))
+
+		totals, err := repo.Outcomes().Totals()
+		check(t, err)
+		if len(totals) != 2 || totals["1"] != 3 || totals["2"] != 1 {
+			t.Fatalf("totals: %v", totals)
+		}
+
+		today := time.Now().Format("2006-01-02")
+		summary, err := repo.Outcomes().Summary("1", today, today)
+		check(t, err)
+		if len(summary) != 2 || summary[0].Reason != "filtered" || summary[1].Reason != "sampled" {
+			t.Fatalf("summary: %+v", summary)
+		}
+		if s := summary[1]; s.Today != 2 || s.Period != 2 || s.LastDay != today {
+			t.Fatalf("sampled summary: %+v", s)
+		}
+	})
+}
+
+func TestAtomicPanic(t *testing.T) {
+	db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "proof.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrated(t, db, false)
+	repo := storage.NewSQL(db, nil)
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("the panic is swallowed")
+			}
+		}()
+		repo.Atomic(func(repo storage.Storage) error {
+			g := storage.Group{ProjectId: "1", Checksum: "a", Message: "a"}
+			if _, err := repo.Groups().Upsert(&g, time.Now()); err != nil {
+				return err
+			}
+			panic("malformed event")
+		})
+	}()
+
+	// rolled back, the database is not locked by the transaction
+	if _, err := repo.Groups().Find("1", "a"); err != storage.ErrNotFound {
+		t.Fatalf("group of the panicked transaction: %v", err)
+	}
+	g := storage.Group{ProjectId: "1", Checksum: "b", Message: "b"}
+	_, err = repo.Groups().Upsert(&g, time.Now())
+	check(t, err)
+}
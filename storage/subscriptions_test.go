@@ -0,0 +1,38 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/scr34m/proof/storage"
+)
+
+func TestSubscriptions(t *testing.T) {
+	backends(t, func(t *testing.T, repo storage.Storage) {
+		check(t, repo.Subscriptions().Set(storage.Subscription{Email: "a@example.com", ProjectId: "1", Mode: storage.SubscribeNew}))
+		check(t, repo.Subscriptions().Set(storage.Subscription{Email: "a@example.com", ProjectId: "1", Mode: storage.SubscribeAll}))
+		check(t, repo.Subscriptions().Set(storage.Subscription{Email: "a@example.com", ProjectId: "1", GroupId: 5, Mode: storage.SubscribeNone}))
+		check(t, repo.Subscriptions().Set(storage.Subscription{Email: "b@example.com", ProjectId: "2", Mode: storage.SubscribeAll}))
+
+		s, err := repo.Subscriptions().Get("a@example.com", "1", 0)
+		check(t, err)
+		if s.Mode != storage.SubscribeAll {
+			t.Fatalf("replaced subscription: %+v", s)
+		}
+
+		list, err := repo.Subscriptions().List("1", 5)
+		check(t, err)
+		if len(list) != 2 {
+			t.Fatalf("subscriptions of the issue: %+v", list)
+		}
+		list, err = repo.Subscriptions().List("1", 6)
+		check(t, err)
+		if len(list) != 1 || list[0].GroupId != 0 {
+			t.Fatalf("subscriptions of another issue: %+v", list)
+		}
+
+		check(t, repo.Subscriptions().Delete("a@example.com", "1", 5))
+		if _, err := repo.Subscriptions().Get("a@example.com", "1", 5); err != storage.ErrNotFound {
+			t.Fatalf("deleted subscription: %v", err)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"github.com/scr34m/proof/config"
+)
+
+// configUsers reads the users from the authentication config
+type configUsers struct {
+	auth *config.AuthConfig
+}
+
+func NewConfigUsers(auth *config.AuthConfig) UserStore {
+	return &configUsers{auth: auth}
+}
+
+func (u *configUsers) Authenticate(email string, password string) (int, bool) {
+	for idx, user := range u.auth.User {
+		if user.Enabled && email == user.Email && password == user.Password {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+func (u *configUsers) Email(id int) (string, bool) {
+	if id < 0 || id >= len(u.auth.User) || !u.auth.User[id].Enabled {
+		return "", false
+	}
+	return u.auth.User[id].Email, true
+}
+
+func (u *configUsers) Recipients() []string {
+	var recipients []string
+	for _, user := range u.auth.User {
+		if user.Enabled {
+			recipients = append(recipients, user.Email)
+		}
+	}
+	return recipients
+}
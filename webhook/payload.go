@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/scr34m/proof/storage"
+)
+
+// Payload is the JSON body of a webhook request
+type Payload struct {
+	Action    string        `json:"action"`
+	Timestamp string        `json:"timestamp"`
+	Url       string        `json:"url"`
+	Group     GroupPayload  `json:"group"`
+	Event     *EventPayload `json:"event"`
+	Alert     *AlertPayload `json:"alert,omitempty"`
+}
+
+type GroupPayload struct {
+	Id         int64  `json:"id"`
+	Project    string `json:"project"`
+	Checksum   string `json:"checksum"`
+	Logger     string `json:"logger"`
+	Level      string `json:"level"`
+	Message    string `json:"message"`
+	ServerName string `json:"server_name"`
+	Url        string `json:"url"`
+	Site       string `json:"site"`
+	Platform   string `json:"platform"`
+	Resolved   bool   `json:"resolved"`
+	Ignored    bool   `json:"ignored"`
+	Assignee   string `json:"assignee"`
+	Seen       int64  `json:"seen"`
+	FirstSeen  string `json:"first_seen"`
+	LastSeen   string `json:"last_seen"`
+}
+
+// EventPayload is the latest event of the group
+type EventPayload struct {
+	Id      int64  `json:"id"`
+	Message string `json:"message"`
+	Url     string `json:"url"`
+}
+
+// AlertPayload is the alert rule that fired for the group
+type AlertPayload struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+func (s *Sender) payload(action string, g storage.Group, alert *AlertPayload) ([]byte, error) {
+	p := Payload{
+		Action:    action,
+		Alert:     alert,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Url:       fmt.Sprintf("%s/details/%d", s.siteUrl, g.Id),
+		Group: GroupPayload{
+			Id:         g.Id,
+			Project:    g.ProjectId,
+			Checksum:   g.Checksum,
+			Logger:     g.Logger,
+			Level:      g.Level,
+			Message:    g.Message,
+			ServerName: g.ServerName,
+			Url:        g.Url,
+			Site:       g.Site,
+			Platform:   g.Platform,
+			Resolved:   g.Status == storage.GroupResolved,
+			Ignored:    g.Status == storage.GroupIgnored,
+			Assignee:   g.Assignee,
+			Seen:       g.Seen,
+			FirstSeen:  g.FirstSeen,
+			LastSeen:   g.LastSeen,
+		},
+	}
+
+	// a group may be left without events by the retention
+	e, err := s.repo.Events().Latest(g.Id)
+	if err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		p.Event = &EventPayload{
+			Id:      e.Id,
+			Message: e.Message,
+			Url:     fmt.Sprintf("%s/details/%d/%d", s.siteUrl, g.Id, e.Id),
+		}
+	}
+
+	return json.Marshal(p)
+}
@@ -0,0 +1,398 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/storage"
+)
+
+const (
+	Created   = "created"
+	Regressed = "regressed"
+	Resolved  = "resolved"
+	Ignored   = "ignored"
+	Assigned  = "assigned"
+	// Event is every stored event
+	Event = "event"
+	// Alert is sent by the alert rules to their webhooks only
+	Alert = "alert"
+)
+
+const (
+	Timeout       = 10 * time.Second
+	RetryInterval = 10 * time.Second
+	MaxRetryDelay = time.Hour
+	// MaxAttempts of a delivery, it has failed after the last one
+	MaxAttempts = 8
+	// DeliveryAge of the finished deliveries in the log
+	DeliveryAge = 7 * 24 * time.Hour
+	// PruneInterval of deleting the old deliveries
+	PruneInterval = time.Hour
+	// DueLimit is the number of deliveries taken at once
+	DueLimit = 100
+)
+
+var ErrNotConfigured = errors.New("webhook is not configured anymore")
+
+type hook struct {
+	config.Webhook
+	id     string
+	events []string
+}
+
+func (h *hook) match(action string, projectId string) bool {
+	if !contains(h.events, action) {
+		return false
+	}
+	if len(h.Projects) > 0 && !contains(h.Projects, projectId) {
+		return false
+	}
+	return true
+}
+
+// Host returns the host of the webhook, the rest of the url may be a secret
+func (h *hook) Host() string {
+	u, err := url.Parse(h.Url)
+	if err != nil {
+		return "?"
+	}
+	return u.Host
+}
+
+// Status is a configured webhook as shown on the delivery log page
+type Status struct {
+	Id       string
+	Host     string
+	Events   []string
+	Projects []string
+}
+
+// Sender stores the webhook requests of the issue lifecycle events in the
+// delivery log and sends them from there, so the processes sharing the
+// database can retry each other's deliveries
+type Sender struct {
+	hooks   []*hook
+	repo    storage.Storage
+	siteUrl string
+	client  *http.Client
+	wake    chan struct{}
+}
+
+func NewSender(webhooks []config.Webhook, repo storage.Storage, siteUrl string) (*Sender, error) {
+	s := &Sender{
+		repo:    repo,
+		siteUrl: strings.TrimRight(siteUrl, "/"),
+		client:  &http.Client{Timeout: Timeout},
+		wake:    make(chan struct{}, 1),
+	}
+
+	for _, w := range webhooks {
+		if !w.Enabled {
+			continue
+		}
+		if w.Url == "" {
+			return nil, errors.New("webhook without url")
+		}
+
+		h := &hook{Webhook: w, events: []string{Created, Regressed, Resolved, Ignored, Assigned}}
+		if len(w.Events) > 0 {
+			h.events = nil
+			for _, e := range w.Events {
+				e = strings.ToLower(e)
+				if e != Created && e != Regressed && e != Resolved && e != Ignored && e != Assigned && e != Event {
+					return nil, fmt.Errorf("unknown webhook event: %s", e)
+				}
+				h.events = append(h.events, e)
+			}
+		}
+
+		hasher := md5.New()
+		hasher.Write([]byte(w.Url))
+		h.id = hex.EncodeToString(hasher.Sum(nil))[:12]
+		s.hooks = append(s.hooks, h)
+	}
+	return s, nil
+}
+
+// Start sends the due deliveries until the context is done
+func (s *Sender) Start(ctx context.Context) {
+	if len(s.hooks) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(RetryInterval)
+		defer ticker.Stop()
+
+		var pruned time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.wake:
+			}
+
+			s.deliver(ctx)
+
+			if time.Since(pruned) > PruneInterval {
+				pruned = time.Now()
+				if _, err := s.repo.Deliveries().Prune(time.Now().Add(-DeliveryAge)); err != nil {
+					log.Printf("Pruning the webhook deliveries failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Fire stores a delivery of the group for every matching webhook, they are
+// sent in the background
+func (s *Sender) Fire(action string, groupId int64) {
+	if len(s.hooks) == 0 {
+		return
+	}
+
+	match := func(h *hook, g storage.Group) bool {
+		return h.match(action, g.ProjectId)
+	}
+	if err := s.fire(action, groupId, match, nil); err != nil {
+		log.Printf("Webhook %s of group %d failed: %v", action, groupId, err)
+	}
+}
+
+// Alert stores a delivery of the group for the webhooks of the urls, whatever
+// their events and projects are
+func (s *Sender) Alert(urls []string, rule string, reason string, groupId int64) error {
+	match := func(h *hook, g storage.Group) bool {
+		return contains(urls, h.Url)
+	}
+	return s.fire(Alert, groupId, match, &AlertPayload{Rule: rule, Reason: reason})
+}
+
+// Has tells if the url is an enabled webhook
+func (s *Sender) Has(url string) bool {
+	for _, h := range s.hooks {
+		if h.Url == url {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Sender) fire(action string, groupId int64, match func(*hook, storage.Group) bool, alert *AlertPayload) error {
+	g, err := s.repo.Groups().Get(groupId)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	fired := false
+	for _, h := range s.hooks {
+		if !match(h, g) {
+			continue
+		}
+
+		// the same body for every webhook
+		if body == nil {
+			body, err = s.payload(action, g, alert)
+			if err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		d := &storage.Delivery{
+			Webhook: h.id,
+			Url:     h.Url,
+			Action:  action,
+			GroupId: g.Id,
+			Payload: string(body),
+			Status:  storage.DeliveryPending,
+			Created: now,
+			Next:    now,
+		}
+		if err := s.repo.Deliveries().Create(d); err != nil {
+			return err
+		}
+		fired = true
+	}
+
+	if fired {
+		s.nudge()
+	}
+	return nil
+}
+
+// Redeliver sends the finished delivery again
+func (s *Sender) Redeliver(id int64) error {
+	if err := s.repo.Deliveries().Redeliver(id); err != nil {
+		return err
+	}
+	s.nudge()
+	return nil
+}
+
+// Webhooks returns the configured webhooks
+func (s *Sender) Webhooks() []Status {
+	var list []Status
+	for _, h := range s.hooks {
+		list = append(list, Status{Id: h.id, Host: h.Host(), Events: h.events, Projects: h.Projects})
+	}
+	return list
+}
+
+func (s *Sender) nudge() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends the due deliveries, more processes may run it at the same time
+func (s *Sender) deliver(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := s.repo.Deliveries().Due(time.Now(), DueLimit)
+		if err != nil {
+			log.Printf("Loading the webhook deliveries failed: %v", err)
+			return
+		}
+
+		sent := 0
+		for _, d := range due {
+			// held while it is sent
+			ok, err := s.repo.Deliveries().Claim(d, time.Now().Add(2*Timeout))
+			if err != nil {
+				log.Printf("Claiming webhook delivery %d failed: %v", d.Id, err)
+				return
+			}
+			if !ok {
+				continue
+			}
+			d.Attempts++
+			sent++
+
+			s.attempt(&d)
+			if err := s.repo.Deliveries().Finish(d); err != nil {
+				log.Printf("Recording webhook delivery %d failed: %v", d.Id, err)
+			}
+		}
+
+		if sent == 0 || len(due) < DueLimit {
+			return
+		}
+	}
+}
+
+// attempt sends the delivery and sets its status for the result
+func (s *Sender) attempt(d *storage.Delivery) {
+	d.Code = 0
+	d.Error = ""
+
+	var err error
+	h := s.find(d.Webhook)
+	if h == nil {
+		err = ErrNotConfigured
+	} else {
+		d.Code, err = s.send(h, d)
+	}
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		d.Status = storage.DeliveryDelivered
+		d.Finished = now
+	case h == nil || d.Attempts >= MaxAttempts:
+		d.Status = storage.DeliveryFailed
+		d.Error = err.Error()
+		d.Finished = now
+	default:
+		d.Error = err.Error()
+		d.Next = now.Add(backoff(d.Attempts))
+	}
+}
+
+func (s *Sender) find(id string) *hook {
+	for _, h := range s.hooks {
+		if h.id == id {
+			return h
+		}
+	}
+	return nil
+}
+
+// send posts the payload, X-Proof-Signature is the hex HMAC-SHA256 of the
+// timestamp of X-Proof-Timestamp, a dot and the body with the secret
+func (s *Sender) send(h *hook, d *storage.Delivery) (int, error) {
+	req, err := http.NewRequest("POST", h.Url, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Proof/"+config.VERSION)
+	req.Header.Set("X-Proof-Event", d.Action)
+	req.Header.Set("X-Proof-Delivery", fmt.Sprintf("%d", d.Id))
+	if h.Secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set("X-Proof-Timestamp", strconv.FormatInt(timestamp, 10))
+		req.Header.Set("X-Proof-Signature", "sha256="+Sign(h.Secret, timestamp, []byte(d.Payload)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 300 {
+		body = bytes.TrimSpace(body)
+		if len(body) > 200 {
+			body = body[:200]
+		}
+		return resp.StatusCode, fmt.Errorf("webhook responded %s: %s", resp.Status, strings.ToValidUTF8(string(body), ""))
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of the unix timestamp, a dot and the body,
+// the receivers compare it to the X-Proof-Signature header without the
+// sha256= prefix and reject the old timestamps of replayed requests
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff doubles the delay after every attempt up to MaxRetryDelay
+func backoff(attempts int) time.Duration {
+	delay := RetryInterval << uint(attempts-1)
+	if delay > MaxRetryDelay || delay <= 0 {
+		delay = MaxRetryDelay
+	}
+	return delay
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
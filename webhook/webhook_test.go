@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/scr34m/proof/config"
+	"github.com/scr34m/proof/storage"
+)
+
+// sender returns the sender of the webhook with a group of project 1 to fire
+func sender(t *testing.T, w config.Webhook) (*Sender, storage.Storage, int64) {
+	t.Helper()
+
+	repo := storage.NewMemory()
+	w.Enabled = true
+	s, err := NewSender([]config.Webhook{w}, repo, "http://localhost:2017/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := storage.Group{ProjectId: "1", Checksum: "a", Level: "error", Message: "Connection refused", Platform: "go"}
+	if _, err := repo.Groups().Upsert(&g, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return s, repo, g.Id
+}
+
+func delivery(t *testing.T, repo storage.Storage) storage.Delivery {
+	t.Helper()
+
+	list, err := repo.Deliveries().List(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("%d deliveries", len(list))
+	}
+	return list[0]
+}
+
+func TestNewSender(t *testing.T) {
+	repo := storage.NewMemory()
+	for _, events := range [][]string{{"Ignored", "assigned"}, {"created", "event"}} {
+		if _, err := NewSender([]config.Webhook{{Url: "http://localhost/", Events: events, Enabled: true}}, repo, ""); err != nil {
+			t.Errorf("events %v: %v", events, err)
+		}
+	}
+	if _, err := NewSender([]config.Webhook{{Url: "http://localhost/", Events: []string{"deleted"}, Enabled: true}}, repo, ""); err == nil {
+		t.Error("unknown event is accepted")
+	}
+	if _, err := NewSender([]config.Webhook{{Enabled: true}}, repo, ""); err == nil {
+		t.Error("webhook without url is accepted")
+	}
+}
+
+func TestSign(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	s, repo, id := sender(t, config.Webhook{Url: server.URL, Secret: "8f2b0c"})
+	s.Fire(Ignored, id)
+	s.deliver(context.Background())
+
+	d := delivery(t, repo)
+	if d.Status != storage.DeliveryDelivered || d.Code != http.StatusOK || d.Attempts != 1 {
+		t.Fatalf("delivery: %+v", d)
+	}
+	if header.Get("X-Proof-Event") != Ignored || header.Get("X-Proof-Delivery") != strconv.FormatInt(d.Id, 10) {
+		t.Errorf("headers: %v", header)
+	}
+
+	// the receiver checks the timestamp and the body it got
+	timestamp, err := strconv.ParseInt(header.Get("X-Proof-Timestamp"), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+		t.Fatalf("timestamp %q", header.Get("X-Proof-Timestamp"))
+	}
+	mac := hmac.New(sha256.New, []byte("8f2b0c"))
+	mac.Write([]byte(header.Get("X-Proof-Timestamp") + "." + string(body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get("X-Proof-Signature") != want {
+		t.Errorf("signature %s instead of %s", header.Get("X-Proof-Signature"), want)
+	}
+	if Sign("8f2b0c", timestamp+1, body) == Sign("8f2b0c", timestamp, body) {
+		t.Error("the timestamp is not signed")
+	}
+
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Action != Ignored || p.Group.Id != id || p.Url != "http://localhost:2017/details/"+strconv.FormatInt(id, 10) {
+		t.Errorf("payload: %s", body)
+	}
+}
+
+func TestUnsigned(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+
+	s, _, id := sender(t, config.Webhook{Url: server.URL})
+	s.Fire(Assigned, id)
+	s.deliver(context.Background())
+
+	if header.Get("X-Proof-Event") != Assigned || header.Get("X-Proof-Signature") != "" || header.Get("X-Proof-Timestamp") != "" {
+		t.Errorf("headers: %v", header)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	s, repo, id := sender(t, config.Webhook{Url: server.URL})
+	s.Fire(Created, id)
+	s.deliver(context.Background())
+
+	// retried after the first delay, it is not due yet
+	d := delivery(t, repo)
+	if d.Status != storage.DeliveryPending || d.Attempts != 1 || d.Code != http.StatusServiceUnavailable || d.Error != "webhook responded 503 Service Unavailable: maintenance" {
+		t.Fatalf("failed delivery: %+v", d)
+	}
+	if delay := time.Until(d.Next); delay < RetryInterval-time.Second || delay > RetryInterval {
+		t.Errorf("retried in %v", delay)
+	}
+	s.deliver(context.Background())
+	if calls != 1 {
+		t.Fatalf("%d requests before the retry is due", calls)
+	}
+
+	// the attempts double the delay
+	d.Attempts++
+	s.attempt(&d)
+	if delay := time.Until(d.Next); d.Status != storage.DeliveryPending || delay < 2*RetryInterval-time.Second || delay > 2*RetryInterval {
+		t.Errorf("second attempt retried in %v: %+v", delay, d)
+	}
+	d.Attempts++
+	s.attempt(&d)
+	if d.Status != storage.DeliveryDelivered || d.Code != http.StatusOK || d.Error != "" || d.Finished.IsZero() {
+		t.Errorf("third attempt: %+v", d)
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	s, repo, id := sender(t, config.Webhook{Url: server.URL})
+	s.Fire(Regressed, id)
+
+	d := delivery(t, repo)
+	d.Attempts = MaxAttempts
+	s.attempt(&d)
+	if d.Status != storage.DeliveryFailed || d.Finished.IsZero() {
+		t.Fatalf("last attempt: %+v", d)
+	}
+
+	// a webhook removed from the config fails right away
+	d = delivery(t, repo)
+	d.Webhook = "removed"
+	d.Attempts = 1
+	s.attempt(&d)
+	if d.Status != storage.DeliveryFailed || d.Error != ErrNotConfigured.Error() {
+		t.Fatalf("delivery of a removed webhook: %+v", d)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second, 320 * time.Second, 640 * time.Second, 1280 * time.Second, 2560 * time.Second, time.Hour, time.Hour}
+	for i, delay := range want {
+		if got := backoff(i + 1); got != delay {
+			t.Errorf("attempt %d: %v instead of %v", i+1, got, delay)
+		}
+	}
+	if got := backoff(100); got != MaxRetryDelay {
+		t.Errorf("attempt 100: %v", got)
+	}
+}